@@ -17,22 +17,107 @@ CREATE TABLE IF NOT EXISTS "Document" (
 );
 
 -- Create DocumentChunk table
--- CREATE TABLE IF NOT EXISTS "DocumentChunk" (
---     id VARCHAR(255) PRIMARY KEY,
---     document_id VARCHAR(255) NOT NULL REFERENCES "Document"(id) ON DELETE CASCADE,
---     chunk_index INTEGER NOT NULL,
---     content TEXT NOT NULL,
---     token_count INTEGER,
---     embedding vector(1536),
---     metadata JSONB,
---     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
---     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
--- );
+CREATE TABLE IF NOT EXISTS "DocumentChunk" (
+    id VARCHAR(255) PRIMARY KEY,
+    document_id VARCHAR(255) NOT NULL REFERENCES "Document"(id) ON DELETE CASCADE,
+    chunk_index INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    token_count INTEGER,
+    -- Must match OPENAI_EMBEDDING_DIMENSIONS; the service checks at startup
+    embedding vector(1536),
+    metadata JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (document_id, chunk_index)
+);
+
+-- Create DocumentPage table (per-page OCR results of multi-page documents)
+CREATE TABLE IF NOT EXISTS "DocumentPage" (
+    document_id VARCHAR(255) NOT NULL REFERENCES "Document"(id) ON DELETE CASCADE,
+    page_number INTEGER NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    content TEXT,
+    error TEXT,
+    metadata JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (document_id, page_number)
+);
+
+-- Create ProcessingJob table (durable work queue, claimed with FOR UPDATE SKIP LOCKED)
+CREATE TABLE IF NOT EXISTS "ProcessingJob" (
+    id VARCHAR(255) PRIMARY KEY,
+    document_id VARCHAR(255) NOT NULL REFERENCES "Document"(id) ON DELETE CASCADE,
+    status VARCHAR(50) NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    last_error TEXT,
+    run_after TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_by VARCHAR(255),
+    lease_expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Create ProcessingEvent table (progress log streamed to clients over SSE)
+CREATE TABLE IF NOT EXISTS "ProcessingEvent" (
+    id BIGSERIAL PRIMARY KEY,
+    document_id VARCHAR(255) NOT NULL REFERENCES "Document"(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    data JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Persian text search configuration. Postgres ships no Persian dictionary, so
+-- normalization, stopword removal and stemming happen in pkg/persian before
+-- the analyzed terms are written to search_text; this configuration only
+-- lowercases and indexes them.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'persian') THEN
+        CREATE TEXT SEARCH CONFIGURATION persian (COPY = pg_catalog.simple);
+    END IF;
+END
+$$;
+
+-- Full-text search columns used by keyword and hybrid search
+ALTER TABLE "Document" ADD COLUMN IF NOT EXISTS search_text TEXT;
+ALTER TABLE "Document" ADD COLUMN IF NOT EXISTS content_tsv tsvector
+    GENERATED ALWAYS AS (to_tsvector('persian', coalesce(search_text, ''))) STORED;
+ALTER TABLE "DocumentChunk" ADD COLUMN IF NOT EXISTS search_text TEXT;
+ALTER TABLE "DocumentChunk" ADD COLUMN IF NOT EXISTS content_tsv tsvector
+    GENERATED ALWAYS AS (to_tsvector('persian', coalesce(search_text, ''))) STORED;
+
+-- Upload content types: what the client declared and what the content is
+ALTER TABLE "Document" ADD COLUMN IF NOT EXISTS declared_content_type VARCHAR(255);
+ALTER TABLE "Document" ADD COLUMN IF NOT EXISTS detected_content_type VARCHAR(255);
+
+-- Content-addressed uploads: SHA-256 of the file, and the document whose
+-- results were reused for an identical upload
+ALTER TABLE "Document" ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+ALTER TABLE "Document" ADD COLUMN IF NOT EXISTS file_size BIGINT;
+ALTER TABLE "Document" ADD COLUMN IF NOT EXISTS duplicate_of VARCHAR(255);
+
+-- Soft delete: set while a document is in the trash
+ALTER TABLE "Document" ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Per-page summaries of multi-page documents
+ALTER TABLE "DocumentPage" ADD COLUMN IF NOT EXISTS summary TEXT;
 
 -- Create indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_document_status ON "Document"(status);
--- CREATE INDEX IF NOT EXISTS idx_document_chunk_document_id ON "DocumentChunk"(document_id);
--- CREATE INDEX IF NOT EXISTS idx_document_chunk_embedding ON "DocumentChunk" USING hnsw (embedding vector_cosine_ops);
+CREATE INDEX IF NOT EXISTS idx_document_content_hash ON "Document"(content_hash);
+CREATE INDEX IF NOT EXISTS idx_document_deleted_at ON "Document"(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_document_chunk_document_id ON "DocumentChunk"(document_id);
+CREATE INDEX IF NOT EXISTS idx_document_chunk_embedding ON "DocumentChunk" USING hnsw (embedding vector_cosine_ops);
+CREATE INDEX IF NOT EXISTS idx_processing_job_claim ON "ProcessingJob"(run_after) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_processing_job_lease ON "ProcessingJob"(lease_expires_at) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_processing_job_document_id ON "ProcessingJob"(document_id);
+CREATE INDEX IF NOT EXISTS idx_processing_event_document_id ON "ProcessingEvent"(document_id, id);
+CREATE INDEX IF NOT EXISTS idx_processing_event_created_at ON "ProcessingEvent"(created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_processing_job_active ON "ProcessingJob"(document_id) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS idx_document_content_tsv ON "Document" USING gin (content_tsv);
+CREATE INDEX IF NOT EXISTS idx_document_chunk_content_tsv ON "DocumentChunk" USING gin (content_tsv);
 
 -- Alternative index (choose one based on your use case)
 -- CREATE INDEX IF NOT EXISTS idx_document_chunk_embedding_ivfflat ON "DocumentChunk" USING ivfflat (embedding vector_cosine_ops) WITH (lists = 100);
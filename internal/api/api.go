@@ -2,23 +2,36 @@
 package api
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
+	"document-embeddings/internal/config"
+	"document-embeddings/internal/models"
+	"document-embeddings/internal/repository"
 	"document-embeddings/internal/services"
 	"document-embeddings/pkg/logger"
 )
 
 type Handler struct {
-	services *services.Services
-	logger   *logger.Logger
+	services      *services.Services
+	maxUploadSize int64
+	adminAPIKey   string
+	logger        *logger.Logger
 }
 
-func New(services *services.Services, logger *logger.Logger) *Handler {
+func New(services *services.Services, cfg *config.Config, logger *logger.Logger) *Handler {
 	return &Handler{
-		services: services,
-		logger:   logger,
+		services:      services,
+		maxUploadSize: int64(cfg.Server.MaxUploadSizeMB) << 20,
+		adminAPIKey:   cfg.Server.AdminAPIKey,
+		logger:        logger,
 	}
 }
 
@@ -28,11 +41,21 @@ func RegisterRoutes(r *gin.Engine, h *Handler) {
 		api.GET("/health", h.HealthCheck)
 		api.POST("/process", h.ProcessDocument)
 		api.GET("/process/:id/status", h.GetProcessingStatus)
-		// api.POST("/search", h.SearchDocuments)
-		// api.GET("/documents/:id/chunks", h.GetDocumentChunks)
+		api.GET("/process/:id/events", h.StreamDocumentEvents)
+		api.GET("/events", h.StreamEvents)
+		api.POST("/search", h.SearchDocuments)
+		api.POST("/ask", h.Ask)
+		api.POST("/ask/stream", h.AskStream)
+		api.GET("/documents/:id/chunks", h.GetDocumentChunks)
+		api.GET("/documents/:id/pages", h.GetDocumentPages)
+		api.POST("/documents/:id/pages/retry", h.RetryFailedPages)
 		api.GET("/documents", h.ListDocuments)
 		api.POST("/documents/batch", h.GetDocumentsByIDs)
+		api.GET("/documents/trash", h.ListTrash)
+		api.POST("/documents/:id/restore", h.RestoreDocument)
 		api.DELETE("/documents/:id", h.DeleteDocument)
+		api.GET("/jobs", h.ListJobs)
+		api.POST("/jobs/:id/retry", h.RetryJob)
 	}
 }
 
@@ -44,6 +67,22 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 }
 
 func (h *Handler) ProcessDocument(c *gin.Context) {
+	if h.maxUploadSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize)
+	}
+
+	// Parse the form up front so an oversized body is reported as such. Large
+	// files are spooled to disk by the parser, never held in memory.
+	if _, err := c.MultipartForm(); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("upload exceeds the maximum size of %d MB", h.maxUploadSize>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form"})
+		return
+	}
+
 	// Get form data
 	documentID := c.PostForm("documentId")
 
@@ -59,30 +98,49 @@ func (h *Handler) ProcessDocument(c *gin.Context) {
 		return
 	}
 
-	// Validate file type
-	allowedTypes := map[string]bool{
-		"application/pdf": true,
-		"image/jpeg":      true,
-		"image/jpg":       true,
-		"image/png":       true,
-		"image/gif":       true,
-		"image/bmp":       true,
-		"image/webp":      true,
-		"image/tiff":      true,
-	}
-
-	if !allowedTypes[file.Header.Get("Content-Type")] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type"})
+	onDuplicate := c.DefaultPostForm("onDuplicate", models.DuplicatePolicyAsk)
+	switch onDuplicate {
+	case models.DuplicatePolicyAsk, models.DuplicatePolicyLink, models.DuplicatePolicyProcess:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "onDuplicate must be ask, link or process"})
 		return
 	}
 
-	// Process the document with file upload
-	if err := h.services.Processing.ProcessDocumentWithFile(c.Request.Context(), documentID, file); err != nil {
+	// Process the document with file upload; the file type is detected from
+	// its content
+	doc, err := h.services.Processing.ProcessDocumentWithFile(c.Request.Context(), documentID, file, onDuplicate)
+	if err != nil {
+		var duplicateErr *services.DuplicateError
+		if errors.As(err, &duplicateErr) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":              err.Error(),
+				"existingDocumentId": duplicateErr.DocumentID,
+			})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidUpload) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		h.logger.Error("Failed to process document", "documentId", documentID, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start document processing"})
 		return
 	}
 
+	if doc.DuplicateOf != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Identical file already processed, results linked",
+			"documentId":  documentID,
+			"filename":    file.Filename,
+			"duplicateOf": *doc.DuplicateOf,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Document processing started",
 		"documentId": documentID,
@@ -103,56 +161,112 @@ func (h *Handler) GetProcessingStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
-// func (h *Handler) SearchDocuments(c *gin.Context) {
-// 	var req struct {
-// 		Query   string                 `json:"query" binding:"required"`
-// 		Limit   int                    `json:"limit"`
-// 		UserID  string                 `json:"userId" binding:"required"`
-// 		Filters map[string]interface{} `json:"filters"`
-// 	}
-
-// 	if err := c.ShouldBindJSON(&req); err != nil {
-// 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-// 		return
-// 	}
-
-// 	searchReq := &models.SearchRequest{
-// 		Query:   req.Query,
-// 		Limit:   req.Limit,
-// 		UserID:  req.UserID,
-// 		Filters: req.Filters,
-// 	}
-
-// 	results, err := h.services.Search.SearchSimilarDocuments(c.Request.Context(), searchReq)
-// 	if err != nil {
-// 		h.logger.Error("Failed to search documents", "error", err)
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search documents"})
-// 		return
-// 	}
-
-// 	c.JSON(http.StatusOK, results)
-// }
-
-// func (h *Handler) GetDocumentChunks(c *gin.Context) {
-// 	documentID := c.Param("id")
-
-// 	chunks, err := h.services.Search.GetDocumentChunks(c.Request.Context(), documentID)
-// 	if err != nil {
-// 		h.logger.Error("Failed to get document chunks", "documentId", documentID, "error", err)
-// 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document chunks"})
-// 		return
-// 	}
-
-// 	c.JSON(http.StatusOK, gin.H{
-// 		"chunks": chunks,
-// 		"total":  len(chunks),
-// 	})
-// }
+func (h *Handler) SearchDocuments(c *gin.Context) {
+	var req models.SearchRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.services.Search.Search(c.Request.Context(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSearchRequest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to search documents", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+func (h *Handler) Ask(c *gin.Context) {
+	var req models.AskRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	answer, err := h.services.Ask.Ask(c.Request.Context(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAskRequest) || errors.Is(err, services.ErrInvalidSearchRequest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to answer question", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to answer question"})
+		return
+	}
+
+	c.JSON(http.StatusOK, answer)
+}
+
+func (h *Handler) GetDocumentChunks(c *gin.Context) {
+	documentID := c.Param("id")
+
+	chunks, err := h.services.Search.GetDocumentChunks(c.Request.Context(), documentID)
+	if err != nil {
+		h.logger.Error("Failed to get document chunks", "documentId", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document chunks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chunks": chunks,
+		"total":  len(chunks),
+	})
+}
+
+func (h *Handler) GetDocumentPages(c *gin.Context) {
+	documentID := c.Param("id")
+
+	pages, err := h.services.Processing.GetDocumentPages(c.Request.Context(), documentID)
+	if err != nil {
+		h.logger.Error("Failed to get document pages", "documentId", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document pages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pages": pages,
+		"total": len(pages),
+	})
+}
+
+func (h *Handler) RetryFailedPages(c *gin.Context) {
+	documentID := c.Param("id")
+
+	if err := h.services.Processing.RetryFailedPages(c.Request.Context(), documentID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		case errors.Is(err, services.ErrCannotRetryPages):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to retry failed pages", "documentId", documentID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry failed pages"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Failed pages queued for retry",
+		"documentId": documentID,
+	})
+}
 
 func (h *Handler) ListDocuments(c *gin.Context) {
 
-	documents, err := h.services.Search.ListDocuments(c.Request.Context(), "500", "0")
+	documents, err := h.services.Search.ListDocuments(c.Request.Context(), "500", "0", queryFilters(c.Request.URL.Query()))
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidSearchRequest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		h.logger.Error("Failed to list documents", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list documents"})
 		return
@@ -164,6 +278,51 @@ func (h *Handler) ListDocuments(c *gin.Context) {
 	})
 }
 
+// filterParams are the query parameters ListDocuments takes as filters.
+var filterParams = map[string]bool{
+	"fileType":      true,
+	"fileTypes":     true,
+	"documentIds":   true,
+	"createdAfter":  true,
+	"createdBefore": true,
+}
+
+// queryFilters turns query parameters into search filters: a parameter given
+// once is a string, one given several times a list, and image.<field>
+// parameters are collected into the image filter. Other parameters, such as
+// cache-busters or tracking parameters, are ignored.
+func queryFilters(query url.Values) map[string]interface{} {
+	filters := make(map[string]interface{})
+	image := make(map[string]interface{})
+
+	for key, values := range query {
+		field, isImage := strings.CutPrefix(key, "image.")
+		if !isImage && !filterParams[key] {
+			continue
+		}
+
+		var value interface{} = values[0]
+		if len(values) > 1 {
+			list := make([]interface{}, len(values))
+			for i, v := range values {
+				list[i] = v
+			}
+			value = list
+		}
+
+		if isImage {
+			image[field] = value
+		} else {
+			filters[key] = value
+		}
+	}
+
+	if len(image) > 0 {
+		filters["image"] = image
+	}
+	return filters
+}
+
 func (h *Handler) GetDocumentsByIDs(c *gin.Context) {
 	var req struct {
 		IDs []string `json:"ids" binding:"required"`
@@ -192,17 +351,152 @@ func (h *Handler) GetDocumentsByIDs(c *gin.Context) {
 	})
 }
 
+// DeleteDocument moves a document to the trash. With ?hard=true it is
+// deleted permanently instead, which requires the admin key.
 func (h *Handler) DeleteDocument(c *gin.Context) {
 	documentID := c.Param("id")
 
-	if err := h.services.Search.DeleteDocument(c.Request.Context(), documentID); err != nil {
+	hard, err := strconv.ParseBool(c.DefaultQuery("hard", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hard must be true or false"})
+		return
+	}
+
+	if !hard {
+		if err := h.services.Documents.TrashDocument(c.Request.Context(), documentID); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+				return
+			}
+			h.logger.Error("Failed to trash document", "documentId", documentID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Document moved to trash",
+			"documentId": documentID,
+		})
+		return
+	}
+
+	if !h.isAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permanent delete requires a valid X-Admin-Key"})
+		return
+	}
+
+	if err := h.services.Documents.DeleteDocument(c.Request.Context(), documentID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+			return
+		}
 		h.logger.Error("Failed to delete document", "documentId", documentID, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":    "Document deleted successfully",
+		"message":    "Document deleted permanently",
 		"documentId": documentID,
 	})
 }
+
+// isAdmin reports whether the request carries the admin key. Admin
+// operations are refused outright when no key is configured.
+func (h *Handler) isAdmin(c *gin.Context) bool {
+	key := c.GetHeader("X-Admin-Key")
+	return h.adminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(h.adminAPIKey)) == 1
+}
+
+func (h *Handler) ListTrash(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+		return
+	}
+
+	documents, err := h.services.Documents.ListTrash(c.Request.Context(), limit, offset)
+	if err != nil {
+		h.logger.Error("Failed to list trash", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"documents": documents,
+		"total":     len(documents),
+	})
+}
+
+func (h *Handler) RestoreDocument(c *gin.Context) {
+	documentID := c.Param("id")
+
+	doc, err := h.services.Documents.RestoreDocument(c.Request.Context(), documentID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "document not found in trash"})
+			return
+		}
+		h.logger.Error("Failed to restore document", "documentId", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Document restored",
+		"documentId": documentID,
+		"status":     doc.Status,
+	})
+}
+
+func (h *Handler) ListJobs(c *gin.Context) {
+	status := c.Query("status")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	jobs, err := h.services.Jobs.ListJobs(c.Request.Context(), status, limit)
+	if err != nil {
+		h.logger.Error("Failed to list jobs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":  jobs,
+		"total": len(jobs),
+	})
+}
+
+func (h *Handler) RetryJob(c *gin.Context) {
+	jobID := c.Param("id")
+
+	job, err := h.services.Jobs.RetryJob(c.Request.Context(), jobID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead job not found"})
+			return
+		}
+		if errors.Is(err, repository.ErrActiveJob) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Document already has a queued or running job"})
+			return
+		}
+		h.logger.Error("Failed to retry job", "jobId", jobID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Job queued for retry",
+		"job":     job,
+	})
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestQueryFilters(t *testing.T) {
+	tests := []struct {
+		query string
+		want  map[string]interface{}
+	}{
+		{
+			query: "fileType=pdf&documentIds=doc-1&createdAfter=2024-01-01&createdBefore=2024-02-01",
+			want: map[string]interface{}{
+				"fileType":      "pdf",
+				"documentIds":   "doc-1",
+				"createdAfter":  "2024-01-01",
+				"createdBefore": "2024-02-01",
+			},
+		},
+		{
+			query: "fileTypes=pdf&fileTypes=docx&documentIds=doc-1&documentIds=doc-2",
+			want: map[string]interface{}{
+				"fileTypes":   []interface{}{"pdf", "docx"},
+				"documentIds": []interface{}{"doc-1", "doc-2"},
+			},
+		},
+		{
+			query: "fileType=png&image.colors=white&image.colors=blue&image.image_type=Invoice",
+			want: map[string]interface{}{
+				"fileType": "png",
+				"image": map[string]interface{}{
+					"colors":     []interface{}{"white", "blue"},
+					"image_type": "Invoice",
+				},
+			},
+		},
+		{
+			query: "limit=10&_=1700000000&utm_source=mail",
+			want:  map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := queryFilters(query); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("queryFilters = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// internal/api/stream.go
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+
+	"document-embeddings/internal/models"
+	"document-embeddings/internal/repository"
+	"document-embeddings/internal/services"
+)
+
+// StreamDocumentEvents streams the processing events of one document as
+// server-sent events. A new stream starts with the document's latest
+// processing run, so a client connecting just after the upload misses
+// nothing.
+func (h *Handler) StreamDocumentEvents(c *gin.Context) {
+	documentID := c.Param("id")
+	h.streamEvents(c, documentID, []string{documentID})
+}
+
+// StreamEvents streams the processing events of the documents named by
+// documentId (repeated or comma-separated), or of all documents, as
+// server-sent events. A new stream only sees events recorded after it
+// started.
+func (h *Handler) StreamEvents(c *gin.Context) {
+	var documentIDs []string
+	for _, value := range c.QueryArray("documentId") {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				documentIDs = append(documentIDs, id)
+			}
+		}
+	}
+	h.streamEvents(c, "", documentIDs)
+}
+
+// streamEvents writes events until the client disconnects. Each event
+// carries its ID, so a reconnecting client resumes after the last one it
+// received by sending it as Last-Event-ID (or as the lastEventId query
+// parameter, for clients that cannot set headers).
+func (h *Handler) streamEvents(c *gin.Context, documentID string, documentIDs []string) {
+	var lastEventID *int64
+	if value := c.GetHeader("Last-Event-ID"); value != "" || c.Query("lastEventId") != "" {
+		if value == "" {
+			value = c.Query("lastEventId")
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be an event ID"})
+			return
+		}
+		lastEventID = &id
+	}
+
+	ctx := c.Request.Context()
+	afterID, err := h.services.Events.StartID(ctx, documentID, lastEventID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+			return
+		}
+		h.logger.Error("Failed to start event stream", "documentId", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stream events"})
+		return
+	}
+
+	startStream(c)
+	err = h.services.Events.Subscribe(ctx, afterID, documentIDs, func(event *models.ProcessingEvent) error {
+		if event == nil {
+			return writeHeartbeat(c)
+		}
+		return writeEvent(c, sse.Event{
+			Id:    strconv.FormatInt(event.ID, 10),
+			Event: event.Type,
+			Data:  event,
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		h.logger.Error("Failed to stream events", "documentId", documentID, "error", err)
+		writeEvent(c, sse.Event{Event: "error", Data: gin.H{"error": "Failed to stream events"}})
+	}
+}
+
+// AskStream answers a question like Ask, streaming the answer as "token"
+// events while it is written. A final "answer" event carries the complete
+// response with its citations, or an "error" event reports that answering
+// failed after the stream started.
+func (h *Handler) AskStream(c *gin.Context) {
+	var req models.AskRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The stream starts with the first token, so that errors found before
+	// asking the model still get a status code
+	var started bool
+	answer, err := h.services.Ask.AskStream(c.Request.Context(), &req, func(delta string) error {
+		if !started {
+			startStream(c)
+			started = true
+		}
+		return writeEvent(c, sse.Event{Event: "token", Data: gin.H{"text": delta}})
+	})
+	if err != nil {
+		switch {
+		case started:
+			// A client that left needs no error event
+			if c.Request.Context().Err() == nil {
+				h.logger.Error("Failed to answer question", "error", err)
+				writeEvent(c, sse.Event{Event: "error", Data: gin.H{"error": "Failed to answer question"}})
+			}
+		case errors.Is(err, services.ErrInvalidAskRequest) || errors.Is(err, services.ErrInvalidSearchRequest):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to answer question", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to answer question"})
+		}
+		return
+	}
+
+	if !started {
+		startStream(c)
+	}
+	writeEvent(c, sse.Event{Event: "answer", Data: answer})
+}
+
+// startStream sends the headers of a server-sent event stream. Proxies are
+// asked not to buffer it.
+func startStream(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+}
+
+func writeEvent(c *gin.Context, event sse.Event) error {
+	if err := sse.Encode(c.Writer, event); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// writeHeartbeat sends a comment, which clients ignore, to keep proxies from
+// closing an idle stream.
+func writeHeartbeat(c *gin.Context) error {
+	if _, err := io.WriteString(c.Writer, ": ping\n\n"); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
@@ -4,18 +4,35 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	MinIO    MinIOConfig
-	OpenAI   OpenAIConfig
-	LogLevel string
+	Server    ServerConfig
+	Database  DatabaseConfig
+	MinIO     MinIOConfig
+	OpenAI    OpenAIConfig
+	Provider  ProviderConfig
+	Prompts   PromptConfig
+	Embedding EmbeddingConfig
+	Search    SearchConfig
+	Summary   SummaryConfig
+	Ask       AskConfig
+	Queue     QueueConfig
+	Events    EventsConfig
+	OCR       OCRConfig
+	PDF       PDFConfig
+	Office    OfficeConfig
+	Storage   StorageConfig
+	LogLevel  string
 }
 
 type ServerConfig struct {
-	Port int
+	Port            int
+	MaxUploadSizeMB int
+	// AdminAPIKey authorizes admin-only operations such as permanent deletes.
+	// They are disabled while it is empty.
+	AdminAPIKey string
 }
 
 type DatabaseConfig struct {
@@ -31,16 +48,144 @@ type MinIOConfig struct {
 }
 
 type OpenAIConfig struct {
-	APIKey     string
-	BaseURL    string
-	Model      string
-	MaxRetries int
+	APIKey              string
+	BaseURL             string
+	Model               string
+	VisionModel         string
+	ChatModel           string
+	EmbeddingDimensions int
+	MaxRetries          int
+	RetryBaseDelay      time.Duration
+	RetryMaxDelay       time.Duration
+	// RequestTimeout bounds each attempt of a request whose reply is not
+	// streamed, and how long any request waits for the response headers.
+	// Streamed replies are then only bounded by the caller's context.
+	RequestTimeout time.Duration
+	// StructuredOutput is how replies are constrained to JSON: "json_schema",
+	// "json_object" or "off".
+	StructuredOutput string
+	// SendEmbeddingDimensions asks the API for embeddings of
+	// EmbeddingDimensions. It is only set when OPENAI_EMBEDDING_DIMENSIONS
+	// is, since some models and servers reject the dimensions parameter.
+	SendEmbeddingDimensions bool
+}
+
+// ProviderConfig selects the implementation behind embeddings, image reading
+// and text generation: "openai" for the OpenAI-compatible API, or "fake" for
+// the deterministic offline provider.
+type ProviderConfig struct {
+	Embedding string
+	Vision    string
+	Chat      string
+}
+
+// PromptConfig locates prompt templates that override or extend the built-in
+// ones, and the language whose variants are used.
+type PromptConfig struct {
+	Dir      string
+	Language string
+}
+
+type EmbeddingConfig struct {
+	ChunkSize    int
+	ChunkOverlap int
+	BatchSize    int
+}
+
+// SummaryConfig controls the summaries written for documents other than
+// images. Long documents are summarized page by page, or in parts of at most
+// PartChars characters, and the part summaries are then combined.
+type SummaryConfig struct {
+	Enabled      bool
+	MaxWords     int
+	PageMaxWords int
+	// Language is the language code summaries are written in; empty keeps
+	// the document's language.
+	Language    string
+	PartChars   int
+	Concurrency int
+}
+
+// AskConfig controls question answering: at most MaxSources chunks, and at
+// most MaxContextChars characters of them, are given to the chat model.
+// Vector hits below MinScore similarity are not used.
+type AskConfig struct {
+	Mode            string
+	MaxSources      int
+	MaxContextChars int
+	MinScore        float64
+}
+
+type QueueConfig struct {
+	Workers        int
+	PollInterval   time.Duration
+	LeaseDuration  time.Duration
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+type OCRConfig struct {
+	PageConcurrency    int
+	TesseractMode      string
+	TesseractLanguages string
+	TesseractPSM       int
+	EscalationPolicy   string
+	MinConfidence      float64
+}
+
+type PDFConfig struct {
+	Rasterizer          string
+	DPI                 int
+	ImageFormat         string
+	TextLayer           bool
+	TextLayerMinChars   int
+	TextLayerMinQuality float64
+}
+
+type OfficeConfig struct {
+	AnalyzeImages bool
+	MaxImages     int
+	MinImageBytes int
+	// MaxEntrySizeMB caps the uncompressed size of each part of an office
+	// archive, images included, that is read
+	MaxEntrySizeMB int
+}
+
+type StorageConfig struct {
+	Backend             string
+	LocalDir            string
+	OrphanSweepInterval time.Duration
+	OrphanMinAge        time.Duration
+	OrphanDelete        bool
+	TrashRetention      time.Duration
+	TrashPurgeInterval  time.Duration
+}
+
+// EventsConfig controls the processing event log streamed over SSE.
+// Streams look for new events every PollInterval, or at once when they are
+// recorded by this instance, and send a keep-alive comment after
+// HeartbeatInterval without events. Events older than Retention are pruned
+// every PruneInterval.
+type EventsConfig struct {
+	PollInterval      time.Duration
+	HeartbeatInterval time.Duration
+	Retention         time.Duration
+	PruneInterval     time.Duration
+}
+
+type SearchConfig struct {
+	RRFK          int
+	VectorWeight  float64
+	KeywordWeight float64
 }
 
 func Load() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port: getEnvAsInt("PORT", 8080),
+			Port:            getEnvAsInt("PORT", 8080),
+			MaxUploadSizeMB: getEnvAsInt("MAX_UPLOAD_SIZE_MB", 512),
+			AdminAPIKey:     getEnv("ADMIN_API_KEY", ""),
 		},
 		Database: DatabaseConfig{
 			URL: getEnv("DATABASE_URL", "postgres://localhost/embeddings?sslmode=disable"),
@@ -53,10 +198,97 @@ func Load() *Config {
 			BucketName:      getEnv("MINIO_BUCKET", "documents"),
 		},
 		OpenAI: OpenAIConfig{
-			APIKey:     getEnv("OPENAI_API_KEY", ""),
-			BaseURL:    getEnv("OPENAI_BASE_URL", "https://api.avalai.ir/v1"),
-			Model:      getEnv("OPENAI_MODEL", "text-embedding-3-small"),
-			MaxRetries: getEnvAsInt("OPENAI_MAX_RETRIES", 3),
+			APIKey:              getEnv("OPENAI_API_KEY", ""),
+			BaseURL:             getEnv("OPENAI_BASE_URL", "https://api.avalai.ir/v1"),
+			Model:               getEnv("OPENAI_MODEL", "text-embedding-3-small"),
+			VisionModel:         getEnv("OPENAI_VISION_MODEL", "gpt-4o-mini"),
+			ChatModel:           getEnv("OPENAI_CHAT_MODEL", "gpt-4o-mini"),
+			EmbeddingDimensions: getEnvAsInt("OPENAI_EMBEDDING_DIMENSIONS", 1536),
+			MaxRetries:          getEnvAsInt("OPENAI_MAX_RETRIES", 3),
+			RetryBaseDelay:      getEnvAsDuration("OPENAI_RETRY_BASE_DELAY", time.Second),
+			RetryMaxDelay:       getEnvAsDuration("OPENAI_RETRY_MAX_DELAY", 30*time.Second),
+			RequestTimeout:      getEnvAsDuration("OPENAI_REQUEST_TIMEOUT", 60*time.Second),
+			StructuredOutput:    getEnv("OPENAI_STRUCTURED_OUTPUT", "json_schema"),
+
+			SendEmbeddingDimensions: os.Getenv("OPENAI_EMBEDDING_DIMENSIONS") != "",
+		},
+		Provider: ProviderConfig{
+			Embedding: getEnv("EMBEDDING_PROVIDER", "openai"),
+			Vision:    getEnv("VISION_PROVIDER", "openai"),
+			Chat:      getEnv("CHAT_PROVIDER", "openai"),
+		},
+		Prompts: PromptConfig{
+			Dir:      getEnv("PROMPT_DIR", ""),
+			Language: getEnv("PROMPT_LANGUAGE", ""),
+		},
+		Embedding: EmbeddingConfig{
+			ChunkSize:    getEnvAsInt("CHUNK_SIZE", 1000),
+			ChunkOverlap: getEnvAsInt("CHUNK_OVERLAP", 200),
+			BatchSize:    getEnvAsInt("EMBEDDING_BATCH_SIZE", 64),
+		},
+		Search: SearchConfig{
+			RRFK:          getEnvAsInt("SEARCH_RRF_K", 60),
+			VectorWeight:  getEnvAsFloat("SEARCH_VECTOR_WEIGHT", 1.0),
+			KeywordWeight: getEnvAsFloat("SEARCH_KEYWORD_WEIGHT", 1.0),
+		},
+		Summary: SummaryConfig{
+			Enabled:      getEnvAsBool("SUMMARY_ENABLED", true),
+			MaxWords:     getEnvAsInt("SUMMARY_MAX_WORDS", 150),
+			PageMaxWords: getEnvAsInt("SUMMARY_PAGE_MAX_WORDS", 60),
+			Language:     getEnv("SUMMARY_LANGUAGE", ""),
+			PartChars:    getEnvAsInt("SUMMARY_PART_CHARS", 12000),
+			Concurrency:  getEnvAsInt("SUMMARY_CONCURRENCY", 4),
+		},
+		Ask: AskConfig{
+			Mode:            getEnv("ASK_SEARCH_MODE", "hybrid"),
+			MaxSources:      getEnvAsInt("ASK_MAX_SOURCES", 8),
+			MaxContextChars: getEnvAsInt("ASK_MAX_CONTEXT_CHARS", 12000),
+			MinScore:        getEnvAsFloat("ASK_MIN_SCORE", 0.3),
+		},
+		Events: EventsConfig{
+			PollInterval:      getEnvAsDuration("EVENTS_POLL_INTERVAL", time.Second),
+			HeartbeatInterval: getEnvAsDuration("EVENTS_HEARTBEAT_INTERVAL", 15*time.Second),
+			Retention:         getEnvAsDuration("EVENTS_RETENTION", 7*24*time.Hour),
+			PruneInterval:     getEnvAsDuration("EVENTS_PRUNE_INTERVAL", time.Hour),
+		},
+		Queue: QueueConfig{
+			Workers:        getEnvAsInt("WORKER_COUNT", 4),
+			PollInterval:   getEnvAsDuration("JOB_POLL_INTERVAL", 2*time.Second),
+			LeaseDuration:  getEnvAsDuration("JOB_LEASE_DURATION", 5*time.Minute),
+			MaxAttempts:    getEnvAsInt("JOB_MAX_ATTEMPTS", 5),
+			RetryBaseDelay: getEnvAsDuration("JOB_RETRY_BASE_DELAY", 30*time.Second),
+			RetryMaxDelay:  getEnvAsDuration("JOB_RETRY_MAX_DELAY", 30*time.Minute),
+		},
+		OCR: OCRConfig{
+			PageConcurrency:    getEnvAsInt("OCR_PAGE_CONCURRENCY", 4),
+			TesseractMode:      getEnv("OCR_TESSERACT_MODE", "off"),
+			TesseractLanguages: getEnv("OCR_TESSERACT_LANGUAGES", "fas+eng"),
+			TesseractPSM:       getEnvAsInt("OCR_TESSERACT_PSM", 3),
+			EscalationPolicy:   getEnv("OCR_ESCALATION_POLICY", "low_confidence"),
+			MinConfidence:      getEnvAsFloat("OCR_MIN_CONFIDENCE", 0.7),
+		},
+		PDF: PDFConfig{
+			Rasterizer:          getEnv("PDF_RASTERIZER", "imagemagick"),
+			DPI:                 getEnvAsInt("PDF_DPI", 150),
+			ImageFormat:         getEnv("PDF_IMAGE_FORMAT", "png"),
+			TextLayer:           getEnvAsBool("PDF_TEXT_LAYER", true),
+			TextLayerMinChars:   getEnvAsInt("PDF_TEXT_LAYER_MIN_CHARS", 50),
+			TextLayerMinQuality: getEnvAsFloat("PDF_TEXT_LAYER_MIN_QUALITY", 0.9),
+		},
+		Office: OfficeConfig{
+			AnalyzeImages:  getEnvAsBool("OFFICE_ANALYZE_IMAGES", true),
+			MaxImages:      getEnvAsInt("OFFICE_MAX_IMAGES", 20),
+			MinImageBytes:  getEnvAsInt("OFFICE_MIN_IMAGE_BYTES", 4096),
+			MaxEntrySizeMB: getEnvAsInt("OFFICE_MAX_ENTRY_SIZE_MB", 100),
+		},
+		Storage: StorageConfig{
+			Backend:             getEnv("STORAGE_BACKEND", "minio"),
+			LocalDir:            getEnv("STORAGE_LOCAL_DIR", "./data/objects"),
+			OrphanSweepInterval: getEnvAsDuration("ORPHAN_SWEEP_INTERVAL", 24*time.Hour),
+			OrphanMinAge:        getEnvAsDuration("ORPHAN_MIN_AGE", 24*time.Hour),
+			OrphanDelete:        getEnvAsBool("ORPHAN_SWEEP_DELETE", false),
+			TrashRetention:      getEnvAsDuration("TRASH_RETENTION", 30*24*time.Hour),
+			TrashPurgeInterval:  getEnvAsDuration("TRASH_PURGE_INTERVAL", time.Hour),
 		},
 		LogLevel: getEnv("LOG_LEVEL", "info"),
 	}
@@ -78,6 +310,24 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			return durationValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
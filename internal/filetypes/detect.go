@@ -0,0 +1,86 @@
+package filetypes
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// SniffLen is how many leading bytes of a file Detect looks at.
+const SniffLen = 3072
+
+type claim struct {
+	source string
+	ft     FileType
+}
+
+// Detect resolves the type of an upload from its content. The magic bytes
+// are authoritative: an upload whose declared Content-Type or extension names
+// a different kind of file is rejected. The claims are only used to tell
+// apart formats that look alike on the wire, such as Markdown or CSV versus
+// plain text, or an office file that is only recognizable as a zip archive.
+// Unregistered declared types such as application/octet-stream are ignored.
+//
+// It returns the resolved type and the detected MIME type.
+func Detect(data []byte, filename, declared string) (FileType, string, error) {
+	detected := mimetype.Detect(data)
+	detectedType := mediaType(detected.String())
+
+	var claims []claim
+	if ft, ok := FromContentType(declared); ok {
+		claims = append(claims, claim{"declared type " + mediaType(declared), ft})
+	}
+	if ft, ok := FromExtension(filename); ok {
+		claims = append(claims, claim{"extension " + strings.ToLower(filepath.Ext(filename)), ft})
+	}
+
+	ft, ok := fromMIME(detected)
+	if !ok {
+		if detected.Is("application/zip") {
+			for _, c := range claims {
+				if c.ft.Kind == KindOffice {
+					return c.ft, detectedType, nil
+				}
+			}
+		}
+		return FileType{}, detectedType, fmt.Errorf("file content is %s, which is not a supported type", detectedType)
+	}
+
+	for _, c := range claims {
+		if c.ft.Kind != ft.Kind {
+			return FileType{}, detectedType, fmt.Errorf("%s does not match the file content, which is %s", c.source, detectedType)
+		}
+	}
+
+	// Any text type may be sent as text/plain; prefer the most specific
+	// claim, then what the content looks like
+	if ft.Kind == KindText {
+		for _, c := range claims {
+			if c.ft.Name != "txt" {
+				return c.ft, detectedType, nil
+			}
+		}
+	}
+	return ft, detectedType, nil
+}
+
+// fromMIME resolves a detected type, walking up the mimetype hierarchy so
+// that e.g. an unregistered text subtype still resolves to plain text.
+func fromMIME(m *mimetype.MIME) (FileType, bool) {
+	for ; m != nil; m = m.Parent() {
+		if ft, ok := FromContentType(m.String()); ok {
+			return ft, true
+		}
+	}
+	return FileType{}, false
+}
+
+func mediaType(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt
+	}
+	return strings.TrimSpace(contentType)
+}
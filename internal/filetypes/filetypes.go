@@ -0,0 +1,113 @@
+// Package filetypes is the registry of document types the service accepts.
+// The API validator and the processing pipeline both resolve uploads through
+// it, so adding a type here is what makes it uploadable.
+package filetypes
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Kind groups file types that share an extraction pipeline.
+type Kind string
+
+const (
+	KindPDF    Kind = "pdf"
+	KindImage  Kind = "image"
+	KindOffice Kind = "office"
+	KindText   Kind = "text"
+)
+
+type FileType struct {
+	// Name is the short type stored in Document.file_type.
+	Name string
+	Kind Kind
+	// MimeType is the canonical MIME type; Aliases are also accepted.
+	MimeType   string
+	Aliases    []string
+	Extensions []string
+}
+
+var registry = []FileType{
+	{Name: "pdf", Kind: KindPDF, MimeType: "application/pdf", Extensions: []string{".pdf"}},
+
+	{Name: "jpg", Kind: KindImage, MimeType: "image/jpeg", Aliases: []string{"image/jpg"}, Extensions: []string{".jpg", ".jpeg"}},
+	{Name: "png", Kind: KindImage, MimeType: "image/png", Extensions: []string{".png"}},
+	{Name: "gif", Kind: KindImage, MimeType: "image/gif", Extensions: []string{".gif"}},
+	{Name: "bmp", Kind: KindImage, MimeType: "image/bmp", Extensions: []string{".bmp"}},
+	{Name: "webp", Kind: KindImage, MimeType: "image/webp", Extensions: []string{".webp"}},
+	{Name: "tiff", Kind: KindImage, MimeType: "image/tiff", Extensions: []string{".tiff", ".tif"}},
+
+	{Name: "docx", Kind: KindOffice, MimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Extensions: []string{".docx"}},
+	{Name: "xlsx", Kind: KindOffice, MimeType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Extensions: []string{".xlsx"}},
+	{Name: "pptx", Kind: KindOffice, MimeType: "application/vnd.openxmlformats-officedocument.presentationml.presentation", Extensions: []string{".pptx"}},
+	{Name: "odt", Kind: KindOffice, MimeType: "application/vnd.oasis.opendocument.text", Extensions: []string{".odt"}},
+	{Name: "ods", Kind: KindOffice, MimeType: "application/vnd.oasis.opendocument.spreadsheet", Extensions: []string{".ods"}},
+	{Name: "odp", Kind: KindOffice, MimeType: "application/vnd.oasis.opendocument.presentation", Extensions: []string{".odp"}},
+
+	{Name: "txt", Kind: KindText, MimeType: "text/plain", Extensions: []string{".txt", ".text"}},
+	{Name: "md", Kind: KindText, MimeType: "text/markdown", Aliases: []string{"text/x-markdown"}, Extensions: []string{".md", ".markdown"}},
+	{Name: "html", Kind: KindText, MimeType: "text/html", Aliases: []string{"application/xhtml+xml"}, Extensions: []string{".html", ".htm", ".xhtml"}},
+	{Name: "csv", Kind: KindText, MimeType: "text/csv", Aliases: []string{"application/csv", "text/comma-separated-values"}, Extensions: []string{".csv"}},
+	{Name: "json", Kind: KindText, MimeType: "application/json", Aliases: []string{"text/json"}, Extensions: []string{".json", ".jsonl"}},
+}
+
+// FromContentType resolves a MIME type, ignoring parameters such as charset.
+func FromContentType(contentType string) (FileType, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	for _, ft := range registry {
+		if ft.MimeType == mediaType {
+			return ft, true
+		}
+		for _, alias := range ft.Aliases {
+			if alias == mediaType {
+				return ft, true
+			}
+		}
+	}
+	return FileType{}, false
+}
+
+// FromExtension resolves a file name by its extension.
+func FromExtension(filename string) (FileType, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		return FileType{}, false
+	}
+
+	for _, ft := range registry {
+		for _, e := range ft.Extensions {
+			if e == ext {
+				return ft, true
+			}
+		}
+	}
+	return FileType{}, false
+}
+
+// FromName resolves a stored Document.file_type. Extensions without the dot
+// ("jpeg", "tif") are accepted for older rows.
+func FromName(name string) (FileType, bool) {
+	name = strings.ToLower(name)
+	for _, ft := range registry {
+		if ft.Name == name {
+			return ft, true
+		}
+	}
+	return FromExtension("." + name)
+}
+
+// ContentTypes lists every accepted canonical MIME type.
+func ContentTypes() []string {
+	types := make([]string, len(registry))
+	for i, ft := range registry {
+		types[i] = ft.MimeType
+	}
+	return types
+}
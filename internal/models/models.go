@@ -6,51 +6,249 @@ import (
 )
 
 type Document struct {
-	ID        string                 `json:"id" db:"id"`
-	Filename  string                 `json:"filename" db:"filename"`
-	FileType  string                 `json:"fileType" db:"file_type"`
-	FilePath  string                 `json:"filePath" db:"file_path"`
-	Content   *string                `json:"content" db:"content"`
-	Summary   *string                `json:"summary" db:"summary"`
-	Metadata  map[string]interface{} `json:"metadata" db:"metadata"`
-	Status    string                 `json:"status" db:"status"`
-	CreatedAt time.Time              `json:"createdAt" db:"created_at"`
-	UpdatedAt time.Time              `json:"updatedAt" db:"updated_at"`
-}
-
-// type DocumentChunk struct {
-// 	ID         string    `json:"id" db:"id"`
-// 	DocumentID string    `json:"documentId" db:"document_id"`
-// 	ChunkIndex int       `json:"chunkIndex" db:"chunk_index"`
-// 	Content    string    `json:"content" db:"content"`
-// 	TokenCount *int      `json:"tokenCount" db:"token_count"`
-// 	Embedding  []float32 `json:"embedding" db:"embedding"`
-// 	Metadata   []byte    `json:"metadata" db:"metadata"`
-// 	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
-// 	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"`
-// 	Document   *Document `json:"document,omitempty"`
-// 	Similarity *float64  `json:"similarity,omitempty"`
-// }
+	ID       string                 `json:"id" db:"id"`
+	Filename string                 `json:"filename" db:"filename"`
+	FileType string                 `json:"fileType" db:"file_type"`
+	FilePath string                 `json:"filePath" db:"file_path"`
+	Content  *string                `json:"content" db:"content"`
+	Summary  *string                `json:"summary" db:"summary"`
+	Metadata map[string]interface{} `json:"metadata" db:"metadata"`
+	Status   string                 `json:"status" db:"status"`
+	// DeclaredContentType is the Content-Type the client sent with the upload;
+	// DetectedContentType is what the file's content turned out to be.
+	DeclaredContentType *string `json:"declaredContentType" db:"declared_content_type"`
+	DetectedContentType *string `json:"detectedContentType" db:"detected_content_type"`
+	// ContentHash is the hex SHA-256 of the uploaded file.
+	ContentHash *string `json:"contentHash" db:"content_hash"`
+	FileSize    *int64  `json:"fileSize" db:"file_size"`
+	// DuplicateOf is the document whose results were reused because it had
+	// an identical file.
+	DuplicateOf *string `json:"duplicateOf,omitempty" db:"duplicate_of"`
+	// DeletedAt is set while the document is in the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+type DocumentChunk struct {
+	ID         string                 `json:"id" db:"id"`
+	DocumentID string                 `json:"documentId" db:"document_id"`
+	ChunkIndex int                    `json:"chunkIndex" db:"chunk_index"`
+	Content    string                 `json:"content" db:"content"`
+	SearchText string                 `json:"-" db:"search_text"`
+	TokenCount *int                   `json:"tokenCount" db:"token_count"`
+	Embedding  []float32              `json:"embedding,omitempty" db:"embedding"`
+	Metadata   map[string]interface{} `json:"metadata" db:"metadata"`
+	CreatedAt  time.Time              `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time              `json:"updatedAt" db:"updated_at"`
+}
+
+const (
+	PageStatusPending   = "pending"
+	PageStatusProcessed = "processed"
+	PageStatusFailed    = "failed"
+)
+
+type DocumentPage struct {
+	DocumentID string                 `json:"documentId" db:"document_id"`
+	PageNumber int                    `json:"pageNumber" db:"page_number"`
+	Status     string                 `json:"status" db:"status"`
+	Content    *string                `json:"content" db:"content"`
+	Summary    *string                `json:"summary" db:"summary"`
+	Error      *string                `json:"error" db:"error"`
+	Metadata   map[string]interface{} `json:"metadata" db:"metadata"`
+	CreatedAt  time.Time              `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time              `json:"updatedAt" db:"updated_at"`
+}
+
+// PageCounts summarizes per-page OCR progress of a document.
+type PageCounts struct {
+	Total  int
+	Done   int
+	Failed int
+}
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusDead      = "dead"
+)
+
+type ProcessingJob struct {
+	ID             string     `json:"id" db:"id"`
+	DocumentID     string     `json:"documentId" db:"document_id"`
+	Status         string     `json:"status" db:"status"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	MaxAttempts    int        `json:"maxAttempts" db:"max_attempts"`
+	LastError      *string    `json:"lastError" db:"last_error"`
+	RunAfter       time.Time  `json:"runAfter" db:"run_after"`
+	LockedBy       *string    `json:"lockedBy" db:"locked_by"`
+	LeaseExpiresAt *time.Time `json:"leaseExpiresAt" db:"lease_expires_at"`
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+// Processing event types.
+const (
+	// EventQueued: the document was queued for processing
+	EventQueued = "queued"
+	// EventStage: processing moved on to another stage (Data["stage"])
+	EventStage = "stage"
+	// EventPage: a page was processed or failed
+	EventPage = "page"
+	// EventProcessed: the document was processed
+	EventProcessed = "processed"
+	// EventFailed: a processing attempt failed; Data["willRetry"] says
+	// whether another attempt follows
+	EventFailed = "failed"
+)
+
+// Stages reported by EventStage.
+const (
+	StageDownloading = "downloading"
+	StageExtracting  = "extracting"
+	StageSummarizing = "summarizing"
+	StageEmbedding   = "embedding"
+)
+
+// ProcessingEvent records progress of a document's processing. IDs increase
+// in the order events were recorded, across all documents.
+type ProcessingEvent struct {
+	ID         int64                  `json:"id" db:"id"`
+	DocumentID string                 `json:"documentId" db:"document_id"`
+	Type       string                 `json:"type" db:"type"`
+	Data       map[string]interface{} `json:"data" db:"data"`
+	CreatedAt  time.Time              `json:"createdAt" db:"created_at"`
+}
 
 type ProcessRequest struct {
 	ID string `json:"id" binding:"required"`
 }
 
+// Duplicate policies decide what happens when an uploaded file is identical
+// to one that was already processed.
+const (
+	// DuplicatePolicyAsk rejects the upload and reports the existing document.
+	DuplicatePolicyAsk = "ask"
+	// DuplicatePolicyLink reuses the existing document's results.
+	DuplicatePolicyLink = "link"
+	// DuplicatePolicyProcess processes the file again.
+	DuplicatePolicyProcess = "process"
+)
+
+const (
+	SearchModeVector  = "vector"
+	SearchModeKeyword = "keyword"
+	SearchModeHybrid  = "hybrid"
+)
+
 type SearchRequest struct {
-	Query   string                 `json:"query" binding:"required"`
-	Limit   int                    `json:"limit"`
-	UserID  string                 `json:"userId" binding:"required"`
-	Filters map[string]interface{} `json:"filters"`
+	Query    string                 `json:"query" binding:"required"`
+	Mode     string                 `json:"mode"`
+	Limit    int                    `json:"limit"`
+	MinScore float64                `json:"minScore"`
+	Weights  *SearchWeights         `json:"weights"`
+	UserID   string                 `json:"userId"`
+	Filters  map[string]interface{} `json:"filters"`
+}
+
+// SearchWeights scale each retriever's contribution to the fused hybrid score.
+type SearchWeights struct {
+	Vector  float64 `json:"vector"`
+	Keyword float64 `json:"keyword"`
+}
+
+// SearchFilters is the validated form of SearchRequest.Filters.
+type SearchFilters struct {
+	DocumentIDs   []string
+	FileTypes     []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Metadata      map[string]interface{}
+	// MetadataFields match single fields of the metadata.
+	MetadataFields []MetadataFieldFilter
 }
 
-// type SearchResponse struct {
-// 	Results []DocumentChunk `json:"results"`
-// 	Total   int             `json:"total"`
-// }
+// MetadataFieldFilter matches a top-level metadata field case-insensitively.
+// A text field matches if it equals any of Values; a list field (List) must
+// contain all of them.
+type MetadataFieldFilter struct {
+	Field  string
+	List   bool
+	Values []string
+}
+
+type SearchResult struct {
+	ChunkID     string                 `json:"chunkId"`
+	DocumentID  string                 `json:"documentId"`
+	Filename    string                 `json:"filename"`
+	FileType    string                 `json:"fileType"`
+	ChunkIndex  int                    `json:"chunkIndex"`
+	Content     string                 `json:"content"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Score       float64                `json:"score"`
+	Similarity  *float64               `json:"similarity,omitempty"`
+	KeywordRank *float64               `json:"keywordRank,omitempty"`
+	Retrievers  []string               `json:"retrievers"`
+}
+
+type SearchResponse struct {
+	Mode    string         `json:"mode"`
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+}
+
+// Reasons an AskResponse gives for not answering.
+const (
+	// AskRefusalNoContext: no chunk matched the question
+	AskRefusalNoContext = "no_context"
+	// AskRefusalUnsupported: the matching chunks do not answer the question
+	AskRefusalUnsupported = "unsupported"
+)
+
+// AskRequest is a question about the processed documents. Mode, MinScore
+// and Filters select the chunks it is answered from, as in a SearchRequest;
+// Limit caps their number.
+type AskRequest struct {
+	Question string                 `json:"question" binding:"required"`
+	Mode     string                 `json:"mode"`
+	Limit    int                    `json:"limit"`
+	MinScore *float64               `json:"minScore"`
+	Filters  map[string]interface{} `json:"filters"`
+}
+
+// AskResponse is an answer grounded in the cited chunks. When the question
+// could not be answered, Answered is false and RefusalReason says why.
+type AskResponse struct {
+	Answer        string     `json:"answer"`
+	Answered      bool       `json:"answered"`
+	RefusalReason string     `json:"refusalReason,omitempty"`
+	Citations     []Citation `json:"citations"`
+}
+
+// Citation points from a [n] marker in an answer to the chunk it cites.
+// Offsets are rune offsets into the document's content.
+type Citation struct {
+	Source      int     `json:"source"`
+	DocumentID  string  `json:"documentId"`
+	Filename    string  `json:"filename"`
+	ChunkID     string  `json:"chunkId"`
+	ChunkIndex  int     `json:"chunkIndex"`
+	PageNumbers []int   `json:"pageNumbers,omitempty"`
+	StartOffset int     `json:"startOffset"`
+	EndOffset   int     `json:"endOffset"`
+	Excerpt     string  `json:"excerpt"`
+	Score       float64 `json:"score"`
+}
 
 type StatusResponse struct {
-	Status string `json:"status"`
-	// ChunkCount int    `json:"chunkCount"`
+	Status      string         `json:"status"`
+	ChunkCount  int            `json:"chunkCount"`
+	PagesTotal  int            `json:"pagesTotal"`
+	PagesDone   int            `json:"pagesDone"`
+	PagesFailed int            `json:"pagesFailed"`
+	Job         *ProcessingJob `json:"job,omitempty"`
 }
 
 type DocumentListItem struct {
@@ -58,3 +256,14 @@ type DocumentListItem struct {
 	Filename string  `json:"filename"`
 	Summary  *string `json:"summary"`
 }
+
+// TrashedDocument is a soft-deleted document; it is purged for good at
+// PurgeAt.
+type TrashedDocument struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	FileType  string    `json:"fileType"`
+	Status    string    `json:"status"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
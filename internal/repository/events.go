@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"document-embeddings/internal/models"
+)
+
+// AppendEvent records an event and sets its ID and creation time. Events are
+// recorded one at a time, under a lock held until the insert commits, so
+// they commit in ID order: a reader that has seen an event never misses one
+// with a lower ID that commits later.
+func (r *Repository) AppendEvent(ctx context.Context, event *models.ProcessingEvent) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('ProcessingEvent'))`); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO "ProcessingEvent" (document_id, type, data, created_at)
+			  VALUES ($1, $2, $3, NOW())
+			  RETURNING id, created_at`
+
+	if err := tx.QueryRow(ctx, query, event.DocumentID, event.Type, event.Data).Scan(&event.ID, &event.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListEventsAfter returns up to limit events with an ID greater than afterID,
+// oldest first, for the given documents or for all documents if none are
+// given.
+func (r *Repository) ListEventsAfter(ctx context.Context, afterID int64, documentIDs []string, limit int) ([]models.ProcessingEvent, error) {
+	query := `SELECT id, document_id, type, data, created_at FROM "ProcessingEvent"
+			  WHERE id > $1 AND (cardinality($2::text[]) = 0 OR document_id = ANY($2))
+			  ORDER BY id
+			  LIMIT $3`
+
+	if documentIDs == nil {
+		documentIDs = []string{}
+	}
+	rows, err := r.db.Query(ctx, query, afterID, documentIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.ProcessingEvent
+	for rows.Next() {
+		var event models.ProcessingEvent
+		if err := rows.Scan(&event.ID, &event.DocumentID, &event.Type, &event.Data, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// LatestEventID returns the ID of the newest event of the given document
+// and type, where empty values match any, or 0 if there is none.
+func (r *Repository) LatestEventID(ctx context.Context, documentID, eventType string) (int64, error) {
+	query := `SELECT COALESCE(MAX(id), 0) FROM "ProcessingEvent"
+			  WHERE ($1 = '' OR document_id = $1) AND ($2 = '' OR type = $2)`
+
+	var id int64
+	err := r.db.QueryRow(ctx, query, documentID, eventType).Scan(&id)
+	return id, err
+}
+
+// DeleteEventsBefore deletes events recorded before the given time and
+// returns how many were deleted.
+func (r *Repository) DeleteEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM "ProcessingEvent" WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"document-embeddings/internal/models"
+)
+
+// queryBuilder accumulates WHERE conditions and their positional arguments.
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg registers a query argument and returns its placeholder.
+func (b *queryBuilder) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *queryBuilder) where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+func (b *queryBuilder) clause() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(b.conditions, " AND ")
+}
+
+// applyDocumentFilters adds conditions on the "Document" table, aliased as d.
+// Documents in the trash are always excluded.
+func (b *queryBuilder) applyDocumentFilters(filters *models.SearchFilters) {
+	b.where("d.deleted_at IS NULL")
+
+	if filters == nil {
+		return
+	}
+
+	if len(filters.DocumentIDs) > 0 {
+		b.where(fmt.Sprintf("d.id = ANY(%s)", b.arg(filters.DocumentIDs)))
+	}
+	if len(filters.FileTypes) > 0 {
+		b.where(fmt.Sprintf("d.file_type = ANY(%s)", b.arg(filters.FileTypes)))
+	}
+	if filters.CreatedAfter != nil {
+		b.where(fmt.Sprintf("d.created_at >= %s", b.arg(*filters.CreatedAfter)))
+	}
+	if filters.CreatedBefore != nil {
+		b.where(fmt.Sprintf("d.created_at < %s", b.arg(*filters.CreatedBefore)))
+	}
+	if len(filters.Metadata) > 0 {
+		b.where(fmt.Sprintf("d.metadata @> %s::jsonb", b.arg(filters.Metadata)))
+	}
+	for _, field := range filters.MetadataFields {
+		key := b.arg(field.Field)
+		if !field.List {
+			b.where(fmt.Sprintf("lower(d.metadata ->> %s::text) = ANY(%s)", key, b.arg(field.Values)))
+			continue
+		}
+		for _, value := range field.Values {
+			b.where(fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM jsonb_array_elements_text(
+					CASE WHEN jsonb_typeof(d.metadata -> %[1]s::text) = 'array' THEN d.metadata -> %[1]s::text ELSE '[]'::jsonb END
+				) AS item(value)
+				WHERE lower(item.value) = %[2]s
+			)`, key, b.arg(value)))
+		}
+	}
+}
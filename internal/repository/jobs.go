@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"document-embeddings/internal/models"
+)
+
+const jobColumns = `id, document_id, status, attempts, max_attempts, last_error, run_after,
+			  locked_by, lease_expires_at, created_at, updated_at`
+
+func scanJob(row pgx.Row) (*models.ProcessingJob, error) {
+	var job models.ProcessingJob
+	err := row.Scan(
+		&job.ID, &job.DocumentID, &job.Status, &job.Attempts, &job.MaxAttempts, &job.LastError, &job.RunAfter,
+		&job.LockedBy, &job.LeaseExpiresAt, &job.CreatedAt, &job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// EnqueueJob inserts a queued job. A document can have at most one queued or
+// running job; enqueueing a second one fails with ErrActiveJob.
+func (r *Repository) EnqueueJob(ctx context.Context, job *models.ProcessingJob) error {
+	query := `INSERT INTO "ProcessingJob"
+			  (id, document_id, status, attempts, max_attempts, run_after, created_at, updated_at)
+			  VALUES ($1, $2, 'queued', 0, $3, NOW(), NOW(), NOW())
+			  ON CONFLICT DO NOTHING`
+
+	tag, err := r.db.Exec(ctx, query, job.ID, job.DocumentID, job.MaxAttempts)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrActiveJob
+	}
+	return nil
+}
+
+// ClaimJob leases the next runnable job to workerID. Concurrent workers skip
+// rows locked by each other, so every job is handed to exactly one worker.
+// It returns nil when no job is ready.
+func (r *Repository) ClaimJob(ctx context.Context, workerID string, lease time.Duration) (*models.ProcessingJob, error) {
+	query := `UPDATE "ProcessingJob"
+			  SET status = 'running', attempts = attempts + 1, locked_by = $1,
+			      lease_expires_at = NOW() + make_interval(secs => $2), updated_at = NOW()
+			  WHERE id = (
+			      SELECT id FROM "ProcessingJob"
+			      WHERE status = 'queued' AND run_after <= NOW()
+			      ORDER BY run_after, created_at
+			      LIMIT 1
+			      FOR UPDATE SKIP LOCKED
+			  )
+			  RETURNING ` + jobColumns
+
+	job, err := scanJob(r.db.QueryRow(ctx, query, workerID, lease.Seconds()))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// HeartbeatJob extends the lease of a running job. It reports false when the
+// worker no longer owns the job, e.g. because the lease expired and the job
+// was handed to someone else.
+func (r *Repository) HeartbeatJob(ctx context.Context, id, workerID string, lease time.Duration) (bool, error) {
+	query := `UPDATE "ProcessingJob"
+			  SET lease_expires_at = NOW() + make_interval(secs => $3), updated_at = NOW()
+			  WHERE id = $1 AND locked_by = $2 AND status = 'running'`
+
+	tag, err := r.db.Exec(ctx, query, id, workerID, lease.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *Repository) CompleteJob(ctx context.Context, id, workerID string) error {
+	query := `UPDATE "ProcessingJob"
+			  SET status = 'completed', last_error = NULL, locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+			  WHERE id = $1 AND locked_by = $2`
+	_, err := r.db.Exec(ctx, query, id, workerID)
+	return err
+}
+
+// FailJob records a failed attempt. With a retry time the job is queued again;
+// without one it moves to the dead-letter state.
+func (r *Repository) FailJob(ctx context.Context, id, workerID, lastError string, retryAt *time.Time) error {
+	query := `UPDATE "ProcessingJob"
+			  SET status = CASE WHEN $4::timestamptz IS NULL THEN 'dead' ELSE 'queued' END,
+			      run_after = COALESCE($4::timestamptz, run_after),
+			      last_error = $3, locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+			  WHERE id = $1 AND locked_by = $2`
+	_, err := r.db.Exec(ctx, query, id, workerID, lastError, retryAt)
+	return err
+}
+
+// ReleaseJob hands a job back to the queue without counting the attempt, used
+// when a worker shuts down mid-job.
+func (r *Repository) ReleaseJob(ctx context.Context, id, workerID string) error {
+	query := `UPDATE "ProcessingJob"
+			  SET status = 'queued', attempts = GREATEST(attempts - 1, 0),
+			      locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+			  WHERE id = $1 AND locked_by = $2 AND status = 'running'`
+	_, err := r.db.Exec(ctx, query, id, workerID)
+	return err
+}
+
+// RequeueExpiredJobs returns running jobs whose lease has lapsed (their worker
+// crashed or was killed) to the queue, or dead-letters them when they have no
+// attempts left. Documents of dead-lettered jobs are marked failed.
+func (r *Repository) RequeueExpiredJobs(ctx context.Context) (int64, error) {
+	query := `WITH expired AS (
+			      UPDATE "ProcessingJob"
+			      SET status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'queued' END,
+			          last_error = COALESCE(last_error, 'lease expired'),
+			          locked_by = NULL, lease_expires_at = NULL, run_after = NOW(), updated_at = NOW()
+			      WHERE status = 'running' AND lease_expires_at < NOW()
+			      RETURNING document_id, status
+			  ), documents AS (
+			      UPDATE "Document" d
+			      SET status = CASE WHEN e.status = 'dead' THEN 'failed' ELSE 'pending' END, updated_at = NOW()
+			      FROM expired e WHERE d.id = e.document_id
+			  )
+			  SELECT COUNT(*) FROM expired`
+
+	var count int64
+	err := r.db.QueryRow(ctx, query).Scan(&count)
+	return count, err
+}
+
+// RetryJob moves a dead-lettered job back to the queue with a fresh attempt
+// budget. It fails with ErrActiveJob while the document has another queued or
+// running job.
+func (r *Repository) RetryJob(ctx context.Context, id string) (*models.ProcessingJob, error) {
+	query := `UPDATE "ProcessingJob" j
+			  SET status = 'queued', attempts = 0, run_after = NOW(), updated_at = NOW()
+			  WHERE j.id = $1 AND j.status = 'dead'
+			    AND NOT EXISTS (
+			        SELECT 1 FROM "ProcessingJob" a
+			        WHERE a.document_id = j.document_id AND a.status IN ('queued', 'running')
+			    )
+			  RETURNING ` + jobColumns
+
+	job, err := scanJob(r.db.QueryRow(ctx, query, id))
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		// A job was queued for the document meanwhile
+		return nil, ErrActiveJob
+	case err == pgx.ErrNoRows:
+		var dead bool
+		err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM "ProcessingJob" WHERE id = $1 AND status = 'dead')`, id).Scan(&dead)
+		if err != nil {
+			return nil, err
+		}
+		if dead {
+			return nil, ErrActiveJob
+		}
+		return nil, fmt.Errorf("dead job %w", ErrNotFound)
+	}
+	return job, err
+}
+
+func (r *Repository) GetLatestJobForDocument(ctx context.Context, documentID string) (*models.ProcessingJob, error) {
+	query := `SELECT ` + jobColumns + `
+			  FROM "ProcessingJob" WHERE document_id = $1
+			  ORDER BY created_at DESC LIMIT 1`
+
+	job, err := scanJob(r.db.QueryRow(ctx, query, documentID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+func (r *Repository) ListJobs(ctx context.Context, status string, limit int) ([]models.ProcessingJob, error) {
+	query := `SELECT ` + jobColumns + `
+			  FROM "ProcessingJob"
+			  WHERE $1 = '' OR status = $1
+			  ORDER BY updated_at DESC
+			  LIMIT $2`
+
+	rows, err := r.db.Query(ctx, query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.ProcessingJob{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
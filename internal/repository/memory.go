@@ -0,0 +1,1026 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"document-embeddings/internal/models"
+)
+
+// MemoryStore is an in-memory DocumentStore for tests and local experiments.
+// It follows the semantics of the SQL in Repository: the same filters,
+// orderings, status transitions and not-found errors. Keyword search matches
+// whole analyzed terms instead of using PostgreSQL's text search.
+type MemoryStore struct {
+	mu        sync.Mutex
+	seq       int64
+	documents map[string]*memoryDocument
+	chunks    map[string][]models.DocumentChunk
+	pages     map[string]map[int]models.DocumentPage
+	jobs      []*memoryJob
+	events    []models.ProcessingEvent
+}
+
+type memoryDocument struct {
+	doc        models.Document
+	searchText string
+	seq        int64
+}
+
+type memoryJob struct {
+	job models.ProcessingJob
+	seq int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		documents: make(map[string]*memoryDocument),
+		chunks:    make(map[string][]models.DocumentChunk),
+		pages:     make(map[string]map[int]models.DocumentPage),
+	}
+}
+
+var _ DocumentStore = (*MemoryStore)(nil)
+
+// next returns an increasing sequence number that breaks ties between rows
+// created within the same clock tick.
+func (m *MemoryStore) next() int64 {
+	m.seq++
+	return m.seq
+}
+
+func copyDocument(doc models.Document) *models.Document {
+	doc.Metadata = maps.Clone(doc.Metadata)
+	return &doc
+}
+
+// update applies fn to a document if it exists, like an UPDATE that may
+// match no row.
+func (m *MemoryStore) update(id string, fn func(d *memoryDocument)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if d, ok := m.documents[id]; ok {
+		fn(d)
+		d.doc.UpdatedAt = time.Now()
+	}
+}
+
+func (m *MemoryStore) CreateDocument(ctx context.Context, doc *models.Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.documents[doc.ID]; ok {
+		return fmt.Errorf("document %s %w", doc.ID, ErrAlreadyExists)
+	}
+
+	now := time.Now()
+	stored := *copyDocument(*doc)
+	stored.DeletedAt = nil
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	m.documents[doc.ID] = &memoryDocument{doc: stored, seq: m.next()}
+	return nil
+}
+
+func (m *MemoryStore) GetDocumentByID(ctx context.Context, id string) (*models.Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.documents[id]
+	if !ok {
+		return nil, fmt.Errorf("document %w", ErrNotFound)
+	}
+	return copyDocument(d.doc), nil
+}
+
+// sortedDocuments returns the documents matching keep in creation order.
+func (m *MemoryStore) sortedDocuments(keep func(d *memoryDocument) bool) []*memoryDocument {
+	var documents []*memoryDocument
+	for _, d := range m.documents {
+		if keep(d) {
+			documents = append(documents, d)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].seq < documents[j].seq })
+	return documents
+}
+
+func (m *MemoryStore) GetDocumentsByIDs(ctx context.Context, ids []string) ([]models.Document, error) {
+	if len(ids) == 0 {
+		return []models.Document{}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var documents []models.Document
+	for _, d := range m.sortedDocuments(func(d *memoryDocument) bool {
+		return wanted[d.doc.ID] && d.doc.DeletedAt == nil
+	}) {
+		documents = append(documents, *copyDocument(d.doc))
+	}
+	return documents, nil
+}
+
+func (m *MemoryStore) ListDocuments(ctx context.Context, limit, offset string, filters *models.SearchFilters) ([]models.DocumentListItem, error) {
+	n, err := strconv.Atoi(limit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid limit %q", limit)
+	}
+	skip, err := strconv.Atoi(offset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offset %q", offset)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matching := m.sortedDocuments(func(d *memoryDocument) bool {
+		return d.doc.Status == "processed" && matchesFilters(&d.doc, filters)
+	})
+
+	var documents []models.DocumentListItem
+	for i := len(matching) - 1 - skip; i >= 0 && len(documents) < n; i-- {
+		doc := matching[i].doc
+		documents = append(documents, models.DocumentListItem{ID: doc.ID, Filename: doc.Filename, Summary: doc.Summary})
+	}
+	return documents, nil
+}
+
+func (m *MemoryStore) UpdateDocumentStatus(ctx context.Context, id, status string) error {
+	m.update(id, func(d *memoryDocument) { d.doc.Status = status })
+	return nil
+}
+
+func (m *MemoryStore) UpdateDocumentContent(ctx context.Context, id, content, searchText string) error {
+	m.update(id, func(d *memoryDocument) {
+		d.doc.Content = &content
+		d.searchText = searchText
+	})
+	return nil
+}
+
+func (m *MemoryStore) UpdateDocumentSummary(ctx context.Context, id, summary string) error {
+	m.update(id, func(d *memoryDocument) { d.doc.Summary = &summary })
+	return nil
+}
+
+func (m *MemoryStore) UpdateDocumentMetadata(ctx context.Context, id string, metadata map[string]interface{}) error {
+	m.update(id, func(d *memoryDocument) { d.doc.Metadata = maps.Clone(metadata) })
+	return nil
+}
+
+func (m *MemoryStore) FindProcessedDocumentByHash(ctx context.Context, contentHash string) (*models.Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matching := m.sortedDocuments(func(d *memoryDocument) bool {
+		return d.doc.ContentHash != nil && *d.doc.ContentHash == contentHash &&
+			d.doc.Status == "processed" && d.doc.DeletedAt == nil
+	})
+	if len(matching) == 0 {
+		return nil, nil
+	}
+	return copyDocument(matching[0].doc), nil
+}
+
+func (m *MemoryStore) LinkDocumentResults(ctx context.Context, id, sourceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target, ok := m.documents[id]
+	source, found := m.documents[sourceID]
+	if !ok || !found || source.doc.Status != "processed" {
+		return fmt.Errorf("processed document %w", ErrNotFound)
+	}
+
+	now := time.Now()
+	sourceDocID := source.doc.ID
+	target.doc.Content = source.doc.Content
+	target.doc.Summary = source.doc.Summary
+	target.doc.Metadata = maps.Clone(source.doc.Metadata)
+	target.doc.Status = "processed"
+	target.doc.DuplicateOf = &sourceDocID
+	target.doc.UpdatedAt = now
+	target.searchText = source.searchText
+
+	for _, chunk := range m.chunks[sourceID] {
+		chunk.ID = uuid.New().String()
+		chunk.DocumentID = id
+		chunk.Metadata = maps.Clone(chunk.Metadata)
+		chunk.CreatedAt, chunk.UpdatedAt = now, now
+		m.chunks[id] = append(m.chunks[id], chunk)
+	}
+
+	for number, page := range m.pages[sourceID] {
+		if m.pages[id] == nil {
+			m.pages[id] = make(map[int]models.DocumentPage)
+		}
+		page.DocumentID = id
+		page.Metadata = maps.Clone(page.Metadata)
+		page.CreatedAt, page.UpdatedAt = now, now
+		m.pages[id][number] = page
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) DeleteDocument(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.documents[id]; !ok {
+		return fmt.Errorf("document %w", ErrNotFound)
+	}
+
+	delete(m.chunks, id)
+	delete(m.pages, id)
+
+	jobs := m.jobs[:0]
+	for _, j := range m.jobs {
+		if j.job.DocumentID != id {
+			jobs = append(jobs, j)
+		}
+	}
+	m.jobs = jobs
+
+	events := m.events[:0]
+	for _, event := range m.events {
+		if event.DocumentID != id {
+			events = append(events, event)
+		}
+	}
+	m.events = events
+
+	delete(m.documents, id)
+	return nil
+}
+
+func (m *MemoryStore) TrashDocument(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.documents[id]
+	if !ok || d.doc.DeletedAt != nil {
+		return fmt.Errorf("document %w", ErrNotFound)
+	}
+
+	now := time.Now()
+	d.doc.DeletedAt = &now
+	d.doc.UpdatedAt = now
+	return nil
+}
+
+func (m *MemoryStore) RestoreDocument(ctx context.Context, id string) (*models.Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.documents[id]
+	if !ok || d.doc.DeletedAt == nil {
+		return nil, fmt.Errorf("trashed document %w", ErrNotFound)
+	}
+
+	d.doc.DeletedAt = nil
+	d.doc.UpdatedAt = time.Now()
+	return copyDocument(d.doc), nil
+}
+
+// trashed returns trashed documents matching keep, oldest deletion first.
+func (m *MemoryStore) trashed(keep func(doc *models.Document) bool) []*memoryDocument {
+	documents := m.sortedDocuments(func(d *memoryDocument) bool {
+		return d.doc.DeletedAt != nil && keep(&d.doc)
+	})
+	sort.SliceStable(documents, func(i, j int) bool {
+		return documents[i].doc.DeletedAt.Before(*documents[j].doc.DeletedAt)
+	})
+	return documents
+}
+
+func (m *MemoryStore) ListTrashedDocuments(ctx context.Context, limit, offset int) ([]models.TrashedDocument, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matching := m.trashed(func(*models.Document) bool { return true })
+
+	documents := []models.TrashedDocument{}
+	for i := len(matching) - 1 - offset; i >= 0 && len(documents) < limit; i-- {
+		doc := matching[i].doc
+		documents = append(documents, models.TrashedDocument{
+			ID:        doc.ID,
+			Filename:  doc.Filename,
+			FileType:  doc.FileType,
+			Status:    doc.Status,
+			DeletedAt: *doc.DeletedAt,
+		})
+	}
+	return documents, nil
+}
+
+func (m *MemoryStore) ListTrashedBefore(ctx context.Context, cutoff time.Time, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for _, d := range m.trashed(func(doc *models.Document) bool { return doc.DeletedAt.Before(cutoff) }) {
+		if len(ids) == limit {
+			break
+		}
+		ids = append(ids, d.doc.ID)
+	}
+	return ids, nil
+}
+
+func (m *MemoryStore) ReferencedFilePaths(ctx context.Context, paths []string) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		wanted[path] = true
+	}
+
+	referenced := map[string]bool{}
+	for _, d := range m.documents {
+		if wanted[d.doc.FilePath] {
+			referenced[d.doc.FilePath] = true
+		}
+	}
+	return referenced, nil
+}
+
+func (m *MemoryStore) ExistingDocumentIDs(ctx context.Context, ids []string) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing := map[string]bool{}
+	for _, id := range ids {
+		if _, ok := m.documents[id]; ok {
+			existing[id] = true
+		}
+	}
+	return existing, nil
+}
+
+func (m *MemoryStore) ReplaceDocumentChunks(ctx context.Context, documentID string, chunks []models.DocumentChunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	stored := make([]models.DocumentChunk, len(chunks))
+	for i, chunk := range chunks {
+		chunk.DocumentID = documentID
+		chunk.Embedding = append([]float32(nil), chunk.Embedding...)
+		chunk.Metadata = maps.Clone(chunk.Metadata)
+		chunk.CreatedAt, chunk.UpdatedAt = now, now
+		stored[i] = chunk
+	}
+	m.chunks[documentID] = stored
+	return nil
+}
+
+// GetDocumentChunks leaves out embeddings and search text, which the SQL
+// query does not select either.
+func (m *MemoryStore) GetDocumentChunks(ctx context.Context, documentID string) ([]models.DocumentChunk, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chunks := []models.DocumentChunk{}
+	for _, chunk := range m.chunks[documentID] {
+		chunk.Embedding = nil
+		chunk.SearchText = ""
+		chunk.Metadata = maps.Clone(chunk.Metadata)
+		chunks = append(chunks, chunk)
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].ChunkIndex < chunks[j].ChunkIndex })
+	return chunks, nil
+}
+
+func (m *MemoryStore) GetDocumentChunkCount(ctx context.Context, documentID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.chunks[documentID]), nil
+}
+
+// searchable calls fn for every chunk of a processed document that passes
+// filters.
+func (m *MemoryStore) searchable(filters *models.SearchFilters, fn func(doc *models.Document, chunk models.DocumentChunk)) {
+	for _, d := range m.documents {
+		if d.doc.Status != "processed" || !matchesFilters(&d.doc, filters) {
+			continue
+		}
+		for _, chunk := range m.chunks[d.doc.ID] {
+			fn(&d.doc, chunk)
+		}
+	}
+}
+
+func searchResult(doc *models.Document, chunk models.DocumentChunk, score float64) models.SearchResult {
+	return models.SearchResult{
+		ChunkID:    chunk.ID,
+		DocumentID: doc.ID,
+		Filename:   doc.Filename,
+		FileType:   doc.FileType,
+		ChunkIndex: chunk.ChunkIndex,
+		Content:    chunk.Content,
+		Metadata:   maps.Clone(chunk.Metadata),
+		Score:      score,
+	}
+}
+
+func (m *MemoryStore) SearchSimilarChunks(ctx context.Context, embedding []float32, limit int, minScore float64, filters *models.SearchFilters) ([]models.SearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := []models.SearchResult{}
+	m.searchable(filters, func(doc *models.Document, chunk models.DocumentChunk) {
+		if len(chunk.Embedding) == 0 {
+			return
+		}
+		if similarity := cosineSimilarity(embedding, chunk.Embedding); similarity >= minScore {
+			results = append(results, searchResult(doc, chunk, similarity))
+		}
+	})
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results[:min(len(results), limit)], nil
+}
+
+// SearchKeywordChunks requires every term to occur in the chunk's search
+// text, like plainto_tsquery, and ranks by term frequency divided by one plus
+// the log of the chunk length, like ts_rank with normalization 1.
+func (m *MemoryStore) SearchKeywordChunks(ctx context.Context, terms string, limit int, filters *models.SearchFilters) ([]models.SearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query := strings.Fields(terms)
+
+	results := []models.SearchResult{}
+	if len(query) == 0 {
+		return results, nil
+	}
+
+	m.searchable(filters, func(doc *models.Document, chunk models.DocumentChunk) {
+		words := strings.Fields(chunk.SearchText)
+		counts := make(map[string]int, len(words))
+		for _, word := range words {
+			counts[word]++
+		}
+
+		matches := 0
+		for _, term := range query {
+			if counts[term] == 0 {
+				return
+			}
+			matches += counts[term]
+		}
+
+		rank := float64(matches) / (1 + math.Log(float64(len(words))))
+		results = append(results, searchResult(doc, chunk, rank))
+	})
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.DocumentID != b.DocumentID {
+			return a.DocumentID < b.DocumentID
+		}
+		return a.ChunkIndex < b.ChunkIndex
+	})
+	return results[:min(len(results), limit)], nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// matchesFilters mirrors queryBuilder.applyDocumentFilters.
+func matchesFilters(doc *models.Document, filters *models.SearchFilters) bool {
+	if doc.DeletedAt != nil {
+		return false
+	}
+	if filters == nil {
+		return true
+	}
+
+	if len(filters.DocumentIDs) > 0 && !contains(filters.DocumentIDs, doc.ID) {
+		return false
+	}
+	if len(filters.FileTypes) > 0 && !contains(filters.FileTypes, doc.FileType) {
+		return false
+	}
+	if filters.CreatedAfter != nil && doc.CreatedAt.Before(*filters.CreatedAfter) {
+		return false
+	}
+	if filters.CreatedBefore != nil && !doc.CreatedAt.Before(*filters.CreatedBefore) {
+		return false
+	}
+	if len(filters.Metadata) > 0 && !jsonContains(normalizeJSON(doc.Metadata), normalizeJSON(filters.Metadata)) {
+		return false
+	}
+	for _, field := range filters.MetadataFields {
+		if !matchesField(doc.Metadata[field.Field], field) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesField mirrors the SQL conditions of a MetadataFieldFilter.
+func matchesField(value interface{}, field models.MetadataFieldFilter) bool {
+	if !field.List {
+		str, ok := value.(string)
+		return ok && contains(field.Values, strings.ToLower(str))
+	}
+
+	var items []string
+	list, _ := normalizeJSON(value).([]interface{})
+	for _, item := range list {
+		if str, ok := item.(string); ok {
+			items = append(items, strings.ToLower(str))
+		}
+	}
+	for _, want := range field.Values {
+		if !contains(items, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeJSON round-trips a value through JSON so that it compares the way
+// jsonb does, e.g. with all numbers as float64.
+func normalizeJSON(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil
+	}
+	return normalized
+}
+
+// jsonContains implements jsonb's @> operator on normalized values.
+func jsonContains(have, want interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		have, ok := have.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if !jsonContains(have[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		have, ok := have.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range want {
+			found := false
+			for _, element := range have {
+				if jsonContains(element, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return have == want
+	}
+}
+
+func (m *MemoryStore) InitDocumentPages(ctx context.Context, documentID string, total int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pages := m.pages[documentID]
+	if pages == nil {
+		pages = make(map[int]models.DocumentPage)
+		m.pages[documentID] = pages
+	}
+
+	for number := range pages {
+		if number > total {
+			delete(pages, number)
+		}
+	}
+
+	now := time.Now()
+	for number := 1; number <= total; number++ {
+		if _, ok := pages[number]; !ok {
+			pages[number] = models.DocumentPage{
+				DocumentID: documentID,
+				PageNumber: number,
+				Status:     models.PageStatusPending,
+				CreatedAt:  now,
+				UpdatedAt:  now,
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateDocumentPage(ctx context.Context, page *models.DocumentPage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.pages[page.DocumentID][page.PageNumber]
+	if !ok {
+		return nil
+	}
+	stored.Status = page.Status
+	stored.Content = page.Content
+	stored.Error = page.Error
+	stored.Metadata = maps.Clone(page.Metadata)
+	stored.Summary = nil
+	stored.UpdatedAt = time.Now()
+	m.pages[page.DocumentID][page.PageNumber] = stored
+	return nil
+}
+
+func (m *MemoryStore) UpdateDocumentPageSummary(ctx context.Context, documentID string, pageNumber int, summary string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.pages[documentID][pageNumber]
+	if !ok {
+		return nil
+	}
+	stored.Summary = &summary
+	stored.UpdatedAt = time.Now()
+	m.pages[documentID][pageNumber] = stored
+	return nil
+}
+
+func (m *MemoryStore) GetDocumentPages(ctx context.Context, documentID string) ([]models.DocumentPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pages := []models.DocumentPage{}
+	for _, page := range m.pages[documentID] {
+		page.Metadata = maps.Clone(page.Metadata)
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].PageNumber < pages[j].PageNumber })
+	return pages, nil
+}
+
+func (m *MemoryStore) GetDocumentPageCounts(ctx context.Context, documentID string) (*models.PageCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := &models.PageCounts{}
+	for _, page := range m.pages[documentID] {
+		counts.Total++
+		switch page.Status {
+		case models.PageStatusProcessed:
+			counts.Done++
+		case models.PageStatusFailed:
+			counts.Failed++
+		}
+	}
+	return counts, nil
+}
+
+func (m *MemoryStore) DeleteDocumentPages(ctx context.Context, documentID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pages, documentID)
+	return nil
+}
+
+func (m *MemoryStore) EnqueueJob(ctx context.Context, job *models.ProcessingJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, j := range m.jobs {
+		active := j.job.Status == models.JobStatusQueued || j.job.Status == models.JobStatusRunning
+		if j.job.ID == job.ID || (j.job.DocumentID == job.DocumentID && active) {
+			return ErrActiveJob
+		}
+	}
+
+	now := time.Now()
+	m.jobs = append(m.jobs, &memoryJob{
+		job: models.ProcessingJob{
+			ID:          job.ID,
+			DocumentID:  job.DocumentID,
+			Status:      models.JobStatusQueued,
+			MaxAttempts: job.MaxAttempts,
+			RunAfter:    now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		seq: m.next(),
+	})
+	return nil
+}
+
+// findJob returns the job with the given ID if it matches keep.
+func (m *MemoryStore) findJob(id string, keep func(job *models.ProcessingJob) bool) *models.ProcessingJob {
+	for _, j := range m.jobs {
+		if j.job.ID == id && keep(&j.job) {
+			return &j.job
+		}
+	}
+	return nil
+}
+
+func lockedBy(job *models.ProcessingJob, workerID string) bool {
+	return job.LockedBy != nil && *job.LockedBy == workerID
+}
+
+func (m *MemoryStore) ClaimJob(ctx context.Context, workerID string, lease time.Duration) (*models.ProcessingJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var next *memoryJob
+	for _, j := range m.jobs {
+		if j.job.Status != models.JobStatusQueued || j.job.RunAfter.After(now) {
+			continue
+		}
+		if next == nil || j.job.RunAfter.Before(next.job.RunAfter) ||
+			(j.job.RunAfter.Equal(next.job.RunAfter) && j.seq < next.seq) {
+			next = j
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	expires := now.Add(lease)
+	worker := workerID
+	next.job.Status = models.JobStatusRunning
+	next.job.Attempts++
+	next.job.LockedBy = &worker
+	next.job.LeaseExpiresAt = &expires
+	next.job.UpdatedAt = now
+
+	job := next.job
+	return &job, nil
+}
+
+func (m *MemoryStore) HeartbeatJob(ctx context.Context, id, workerID string, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.findJob(id, func(job *models.ProcessingJob) bool {
+		return lockedBy(job, workerID) && job.Status == models.JobStatusRunning
+	})
+	if job == nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	expires := now.Add(lease)
+	job.LeaseExpiresAt = &expires
+	job.UpdatedAt = now
+	return true, nil
+}
+
+func (m *MemoryStore) CompleteJob(ctx context.Context, id, workerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job := m.findJob(id, func(job *models.ProcessingJob) bool { return lockedBy(job, workerID) }); job != nil {
+		job.Status = models.JobStatusCompleted
+		job.LastError = nil
+		job.LockedBy = nil
+		job.LeaseExpiresAt = nil
+		job.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (m *MemoryStore) FailJob(ctx context.Context, id, workerID, lastError string, retryAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job := m.findJob(id, func(job *models.ProcessingJob) bool { return lockedBy(job, workerID) }); job != nil {
+		if retryAt == nil {
+			job.Status = models.JobStatusDead
+		} else {
+			job.Status = models.JobStatusQueued
+			job.RunAfter = *retryAt
+		}
+		job.LastError = &lastError
+		job.LockedBy = nil
+		job.LeaseExpiresAt = nil
+		job.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (m *MemoryStore) ReleaseJob(ctx context.Context, id, workerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.findJob(id, func(job *models.ProcessingJob) bool {
+		return lockedBy(job, workerID) && job.Status == models.JobStatusRunning
+	})
+	if job != nil {
+		job.Status = models.JobStatusQueued
+		job.Attempts = max(job.Attempts-1, 0)
+		job.LockedBy = nil
+		job.LeaseExpiresAt = nil
+		job.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (m *MemoryStore) RequeueExpiredJobs(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var count int64
+	for _, j := range m.jobs {
+		job := &j.job
+		if job.Status != models.JobStatusRunning || job.LeaseExpiresAt == nil || !job.LeaseExpiresAt.Before(now) {
+			continue
+		}
+
+		documentStatus := "pending"
+		job.Status = models.JobStatusQueued
+		if job.Attempts >= job.MaxAttempts {
+			job.Status = models.JobStatusDead
+			documentStatus = "failed"
+		}
+		if job.LastError == nil {
+			expired := "lease expired"
+			job.LastError = &expired
+		}
+		job.LockedBy = nil
+		job.LeaseExpiresAt = nil
+		job.RunAfter = now
+		job.UpdatedAt = now
+
+		if d, ok := m.documents[job.DocumentID]; ok {
+			d.doc.Status = documentStatus
+			d.doc.UpdatedAt = now
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) RetryJob(ctx context.Context, id string) (*models.ProcessingJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.findJob(id, func(job *models.ProcessingJob) bool { return job.Status == models.JobStatusDead })
+	if job == nil {
+		return nil, fmt.Errorf("dead job %w", ErrNotFound)
+	}
+	for _, j := range m.jobs {
+		active := j.job.Status == models.JobStatusQueued || j.job.Status == models.JobStatusRunning
+		if j.job.DocumentID == job.DocumentID && active {
+			return nil, ErrActiveJob
+		}
+	}
+
+	now := time.Now()
+	job.Status = models.JobStatusQueued
+	job.Attempts = 0
+	job.RunAfter = now
+	job.UpdatedAt = now
+
+	retried := *job
+	return &retried, nil
+}
+
+func (m *MemoryStore) GetLatestJobForDocument(ctx context.Context, documentID string) (*models.ProcessingJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.jobs) - 1; i >= 0; i-- {
+		if m.jobs[i].job.DocumentID == documentID {
+			job := m.jobs[i].job
+			return &job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) ListJobs(ctx context.Context, status string, limit int) ([]models.ProcessingJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := []models.ProcessingJob{}
+	for _, j := range m.jobs {
+		if status == "" || j.job.Status == status {
+			jobs = append(jobs, j.job)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].UpdatedAt.After(jobs[j].UpdatedAt) })
+	return jobs[:min(len(jobs), limit)], nil
+}
+
+func (m *MemoryStore) AppendEvent(ctx context.Context, event *models.ProcessingEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = m.next()
+	event.CreatedAt = time.Now()
+	stored := *event
+	stored.Data = maps.Clone(event.Data)
+	m.events = append(m.events, stored)
+	return nil
+}
+
+func (m *MemoryStore) ListEventsAfter(ctx context.Context, afterID int64, documentIDs []string, limit int) ([]models.ProcessingEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []models.ProcessingEvent
+	for _, event := range m.events {
+		if len(events) == limit {
+			break
+		}
+		if event.ID <= afterID || (len(documentIDs) > 0 && !slices.Contains(documentIDs, event.DocumentID)) {
+			continue
+		}
+		event.Data = maps.Clone(event.Data)
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (m *MemoryStore) LatestEventID(ctx context.Context, documentID, eventType string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.events) - 1; i >= 0; i-- {
+		event := m.events[i]
+		if (documentID == "" || event.DocumentID == documentID) && (eventType == "" || event.Type == eventType) {
+			return event.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) DeleteEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := m.events[:0]
+	for _, event := range m.events {
+		if !event.CreatedAt.Before(before) {
+			events = append(events, event)
+		}
+	}
+	deleted := int64(len(m.events) - len(events))
+	m.events = events
+	return deleted, nil
+}
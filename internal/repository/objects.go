@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+)
+
+// ReferencedFilePaths returns which of the given object keys are the stored
+// file of some document.
+func (r *Repository) ReferencedFilePaths(ctx context.Context, paths []string) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	if len(paths) == 0 {
+		return referenced, nil
+	}
+
+	rows, err := r.db.Query(ctx, `SELECT DISTINCT file_path FROM "Document" WHERE file_path = ANY($1)`, paths)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		referenced[path] = true
+	}
+
+	return referenced, rows.Err()
+}
+
+// ExistingDocumentIDs returns which of the given document IDs exist.
+func (r *Repository) ExistingDocumentIDs(ctx context.Context, ids []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	rows, err := r.db.Query(ctx, `SELECT id FROM "Document" WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		existing[id] = true
+	}
+
+	return existing, rows.Err()
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+
+	"document-embeddings/internal/models"
+)
+
+// InitDocumentPages makes sure pages 1..total exist for a document, keeping
+// the results of pages that were already processed, and drops pages beyond
+// total left over from an earlier version of the file.
+func (r *Repository) InitDocumentPages(ctx context.Context, documentID string, total int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM "DocumentPage" WHERE document_id = $1 AND page_number > $2`, documentID, total)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO "DocumentPage" (document_id, page_number, status, created_at, updated_at)
+			  SELECT $1, n, 'pending', NOW(), NOW() FROM generate_series(1, $2::int) AS n
+			  ON CONFLICT (document_id, page_number) DO NOTHING`
+	if _, err := tx.Exec(ctx, query, documentID, total); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UpdateDocumentPage records a page's extraction outcome. The page's summary
+// is cleared, since it described the previous content.
+func (r *Repository) UpdateDocumentPage(ctx context.Context, page *models.DocumentPage) error {
+	query := `UPDATE "DocumentPage" 
+			  SET status = $3, content = $4, error = $5, metadata = $6, summary = NULL, updated_at = NOW() 
+			  WHERE document_id = $1 AND page_number = $2`
+	_, err := r.db.Exec(ctx, query,
+		page.DocumentID, page.PageNumber, page.Status, page.Content, page.Error, page.Metadata,
+	)
+	return err
+}
+
+func (r *Repository) UpdateDocumentPageSummary(ctx context.Context, documentID string, pageNumber int, summary string) error {
+	query := `UPDATE "DocumentPage" SET summary = $3, updated_at = NOW() 
+			  WHERE document_id = $1 AND page_number = $2`
+	_, err := r.db.Exec(ctx, query, documentID, pageNumber, summary)
+	return err
+}
+
+func (r *Repository) GetDocumentPages(ctx context.Context, documentID string) ([]models.DocumentPage, error) {
+	query := `SELECT document_id, page_number, status, content, summary, error, metadata, created_at, updated_at 
+			  FROM "DocumentPage" WHERE document_id = $1 ORDER BY page_number`
+
+	rows, err := r.db.Query(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []models.DocumentPage{}
+	for rows.Next() {
+		var page models.DocumentPage
+		err := rows.Scan(
+			&page.DocumentID, &page.PageNumber, &page.Status, &page.Content, &page.Summary, &page.Error, &page.Metadata,
+			&page.CreatedAt, &page.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	return pages, rows.Err()
+}
+
+func (r *Repository) GetDocumentPageCounts(ctx context.Context, documentID string) (*models.PageCounts, error) {
+	query := `SELECT COUNT(*), 
+				 COUNT(*) FILTER (WHERE status = 'processed'), 
+				 COUNT(*) FILTER (WHERE status = 'failed') 
+			  FROM "DocumentPage" WHERE document_id = $1`
+
+	var counts models.PageCounts
+	err := r.db.QueryRow(ctx, query, documentID).Scan(&counts.Total, &counts.Done, &counts.Failed)
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+func (r *Repository) DeleteDocumentPages(ctx context.Context, documentID string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM "DocumentPage" WHERE document_id = $1`, documentID)
+	return err
+}
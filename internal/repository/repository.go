@@ -3,16 +3,28 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 
 	"document-embeddings/internal/models"
 	"document-embeddings/pkg/database"
 	"document-embeddings/pkg/logger"
 )
 
+// ErrNotFound is wrapped by lookups that match no row.
+var ErrNotFound = errors.New("not found")
+
+// ErrAlreadyExists is wrapped by inserts of an ID that is already taken.
+var ErrAlreadyExists = errors.New("already exists")
+
+// ErrActiveJob is returned when a job is queued for a document that already
+// has a queued or running one.
+var ErrActiveJob = errors.New("document already has an active job")
+
 type Repository struct {
 	db     *database.DB
 	logger *logger.Logger
@@ -25,24 +37,37 @@ func New(db *database.DB, logger *logger.Logger) *Repository {
 	}
 }
 
-func (r *Repository) GetDocumentByID(ctx context.Context, id string) (*models.Document, error) {
-	query := `SELECT id, filename, file_type, file_path, content, summary, metadata, status, created_at, updated_at 
-			  FROM "Document" WHERE id = $1`
+const documentColumns = `id, filename, file_type, file_path, content, summary, metadata, status,
+			  declared_content_type, detected_content_type, content_hash, file_size, duplicate_of,
+			  deleted_at, created_at, updated_at`
 
+func scanDocument(row pgx.Row) (*models.Document, error) {
 	var doc models.Document
-	err := r.db.QueryRow(ctx, query, id).Scan(
+	err := row.Scan(
 		&doc.ID, &doc.Filename, &doc.FileType, &doc.FilePath,
 		&doc.Content, &doc.Summary, &doc.Metadata, &doc.Status,
-		&doc.CreatedAt, &doc.UpdatedAt,
+		&doc.DeclaredContentType, &doc.DetectedContentType, &doc.ContentHash, &doc.FileSize, &doc.DuplicateOf,
+		&doc.DeletedAt, &doc.CreatedAt, &doc.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (r *Repository) GetDocumentByID(ctx context.Context, id string) (*models.Document, error) {
+	query := `SELECT ` + documentColumns + `
+			  FROM "Document" WHERE id = $1`
+
+	doc, err := scanDocument(r.db.QueryRow(ctx, query, id))
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("document not found")
+			return nil, fmt.Errorf("document %w", ErrNotFound)
 		}
 		return nil, err
 	}
 
-	return &doc, nil
+	return doc, nil
 }
 
 func (r *Repository) UpdateDocumentStatus(ctx context.Context, id, status string) error {
@@ -51,9 +76,9 @@ func (r *Repository) UpdateDocumentStatus(ctx context.Context, id, status string
 	return err
 }
 
-func (r *Repository) UpdateDocumentContent(ctx context.Context, id, content string) error {
-	query := `UPDATE "Document" SET content = $1, updated_at = NOW() WHERE id = $2`
-	_, err := r.db.Exec(ctx, query, content, id)
+func (r *Repository) UpdateDocumentContent(ctx context.Context, id, content, searchText string) error {
+	query := `UPDATE "Document" SET content = $1, search_text = $2, updated_at = NOW() WHERE id = $3`
+	_, err := r.db.Exec(ctx, query, content, searchText, id)
 	return err
 }
 
@@ -69,87 +94,151 @@ func (r *Repository) UpdateDocumentMetadata(ctx context.Context, id string, meta
 	return err
 }
 
-// func (r *Repository) CreateDocumentChunk(ctx context.Context, chunk *models.DocumentChunk) error {
-// 	query := `INSERT INTO "DocumentChunk"
-// 			  (id, document_id, chunk_index, content, token_count, embedding, metadata, created_at, updated_at)
-// 			  VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())`
-
-// 	_, err := r.db.Exec(ctx, query,
-// 		chunk.ID, chunk.DocumentID, chunk.ChunkIndex, chunk.Content,
-// 		chunk.TokenCount, chunk.Embedding, chunk.Metadata,
-// 	)
-// 	return err
-// }
-
-// func (r *Repository) GetDocumentChunks(ctx context.Context, documentID string) ([]models.DocumentChunk, error) {
-// 	query := `SELECT id, document_id, chunk_index, content, token_count, embedding, metadata, created_at, updated_at
-// 			  FROM "DocumentChunk" WHERE document_id = $1 ORDER BY chunk_index`
-
-// 	rows, err := r.db.Query(ctx, query, documentID)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	defer rows.Close()
-
-// 	var chunks []models.DocumentChunk
-// 	for rows.Next() {
-// 		var chunk models.DocumentChunk
-// 		err := rows.Scan(
-// 			&chunk.ID, &chunk.DocumentID, &chunk.ChunkIndex, &chunk.Content,
-// 			&chunk.TokenCount, &chunk.Embedding, &chunk.Metadata,
-// 			&chunk.CreatedAt, &chunk.UpdatedAt,
-// 		)
-// 		if err != nil {
-// 			return nil, err
-// 		}
-// 		chunks = append(chunks, chunk)
-// 	}
-
-// 	return chunks, nil
-// }
-
-// func (r *Repository) SearchSimilarChunks(ctx context.Context, embedding []float32, limit int, userID string) ([]models.DocumentChunk, error) {
-// 	query := `SELECT c.id, c.document_id, c.chunk_index, c.content, c.token_count,
-// 				 c.embedding, c.metadata, c.created_at, c.updated_at,
-// 				 d.filename, d.file_type, d.user_id,
-// 				 1 - (c.embedding <=> $1::vector) as similarity
-// 			  FROM "DocumentChunk" c
-// 			  JOIN "Document" d ON c.document_id = d.id
-// 			  WHERE d.user_id = $2 AND d.status = 'processed'
-// 			  ORDER BY c.embedding <=> $1::vector
-// 			  LIMIT $3`
-
-// 	rows, err := r.db.Query(ctx, query, embedding, userID, limit)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	defer rows.Close()
-
-// 	var chunks []models.DocumentChunk
-// 	for rows.Next() {
-// 		var chunk models.DocumentChunk
-// 		var doc models.Document
-// 		var similarity float64
-
-// 		err := rows.Scan(
-// 			&chunk.ID, &chunk.DocumentID, &chunk.ChunkIndex, &chunk.Content,
-// 			&chunk.TokenCount, &chunk.Embedding, &chunk.Metadata,
-// 			&chunk.CreatedAt, &chunk.UpdatedAt,
-// 			&doc.Filename, &doc.FileType, &doc.UserID,
-// 			&similarity,
-// 		)
-// 		if err != nil {
-// 			return nil, err
-// 		}
-
-// 		chunk.Document = &doc
-// 		chunk.Similarity = &similarity
-// 		chunks = append(chunks, chunk)
-// 	}
-
-// 	return chunks, nil
-// }
+// ReplaceDocumentChunks atomically swaps the chunk set of a document, so
+// reprocessing never leaves a mix of old and new chunks behind.
+func (r *Repository) ReplaceDocumentChunks(ctx context.Context, documentID string, chunks []models.DocumentChunk) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM "DocumentChunk" WHERE document_id = $1`, documentID); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO "DocumentChunk" 
+			  (id, document_id, chunk_index, content, search_text, token_count, embedding, metadata, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7::vector, $8, NOW(), NOW())`
+
+	if len(chunks) > 0 {
+		batch := &pgx.Batch{}
+		for _, chunk := range chunks {
+			batch.Queue(query,
+				chunk.ID, documentID, chunk.ChunkIndex, chunk.Content, chunk.SearchText,
+				chunk.TokenCount, vectorLiteral(chunk.Embedding), chunk.Metadata,
+			)
+		}
+		if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *Repository) GetDocumentChunks(ctx context.Context, documentID string) ([]models.DocumentChunk, error) {
+	query := `SELECT id, document_id, chunk_index, content, token_count, metadata, created_at, updated_at 
+			  FROM "DocumentChunk" WHERE document_id = $1 ORDER BY chunk_index`
+
+	rows, err := r.db.Query(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []models.DocumentChunk{}
+	for rows.Next() {
+		var chunk models.DocumentChunk
+		err := rows.Scan(
+			&chunk.ID, &chunk.DocumentID, &chunk.ChunkIndex, &chunk.Content,
+			&chunk.TokenCount, &chunk.Metadata,
+			&chunk.CreatedAt, &chunk.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, rows.Err()
+}
+
+func (r *Repository) SearchSimilarChunks(ctx context.Context, embedding []float32, limit int, minScore float64, filters *models.SearchFilters) ([]models.SearchResult, error) {
+	b := &queryBuilder{}
+	vector := b.arg(vectorLiteral(embedding))
+	b.where("d.status = 'processed'")
+	b.where("c.embedding IS NOT NULL")
+	b.where(fmt.Sprintf("1 - (c.embedding <=> %s::vector) >= %s", vector, b.arg(minScore)))
+	b.applyDocumentFilters(filters)
+
+	query := fmt.Sprintf(`SELECT c.id, c.document_id, c.chunk_index, c.content, c.metadata, 
+				 d.filename, d.file_type, 
+				 1 - (c.embedding <=> %[1]s::vector) AS similarity 
+			  FROM "DocumentChunk" c 
+			  JOIN "Document" d ON c.document_id = d.id 
+			  %[2]s 
+			  ORDER BY c.embedding <=> %[1]s::vector 
+			  LIMIT %[3]s`, vector, b.clause(), b.arg(limit))
+
+	rows, err := r.db.Query(ctx, query, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var result models.SearchResult
+		err := rows.Scan(
+			&result.ChunkID, &result.DocumentID, &result.ChunkIndex, &result.Content, &result.Metadata,
+			&result.Filename, &result.FileType,
+			&result.Score,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// SearchKeywordChunks ranks chunks by full-text relevance. terms must already
+// be analyzed (see persian.SearchText) the same way search_text was. ts_rank
+// with normalization 1 divides by the log of the chunk length, which keeps
+// long chunks from dominating much like BM25's length normalization.
+func (r *Repository) SearchKeywordChunks(ctx context.Context, terms string, limit int, filters *models.SearchFilters) ([]models.SearchResult, error) {
+	b := &queryBuilder{}
+	tsquery := fmt.Sprintf("plainto_tsquery('persian', %s)", b.arg(terms))
+	b.where("d.status = 'processed'")
+	b.where(fmt.Sprintf("c.content_tsv @@ %s", tsquery))
+	b.applyDocumentFilters(filters)
+
+	query := fmt.Sprintf(`SELECT c.id, c.document_id, c.chunk_index, c.content, c.metadata, 
+				 d.filename, d.file_type, 
+				 ts_rank(c.content_tsv, %[1]s, 1) AS rank 
+			  FROM "DocumentChunk" c 
+			  JOIN "Document" d ON c.document_id = d.id 
+			  %[2]s 
+			  ORDER BY rank DESC, c.document_id, c.chunk_index 
+			  LIMIT %[3]s`, tsquery, b.clause(), b.arg(limit))
+
+	rows, err := r.db.Query(ctx, query, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var result models.SearchResult
+		err := rows.Scan(
+			&result.ChunkID, &result.DocumentID, &result.ChunkIndex, &result.Content, &result.Metadata,
+			&result.Filename, &result.FileType,
+			&result.Score,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// DeleteDocument deletes a document with its chunks, pages and jobs in one
+// transaction. Stored objects are left to the caller.
 func (r *Repository) DeleteDocument(ctx context.Context, id string) error {
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
@@ -157,36 +246,45 @@ func (r *Repository) DeleteDocument(ctx context.Context, id string) error {
 	}
 	defer tx.Rollback(ctx)
 
-	// Delete chunks first (foreign key constraint)
-	// _, err = tx.Exec(ctx, `DELETE FROM "DocumentChunk" WHERE document_id = $1`, id)
-	// if err != nil {
-	// 	return err
-	// }
+	// Delete dependent rows first (foreign key constraints)
+	for _, table := range []string{"DocumentChunk", "DocumentPage", "ProcessingJob", "ProcessingEvent"} {
+		if _, err := tx.Exec(ctx, `DELETE FROM "`+table+`" WHERE document_id = $1`, id); err != nil {
+			return err
+		}
+	}
 
 	// Delete document
-	_, err = tx.Exec(ctx, `DELETE FROM "Document" WHERE id = $1`, id)
+	tag, err := tx.Exec(ctx, `DELETE FROM "Document" WHERE id = $1`, id)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("document %w", ErrNotFound)
+	}
 
 	return tx.Commit(ctx)
 }
 
-// func (r *Repository) GetDocumentChunkCount(ctx context.Context, documentID string) (int, error) {
-// 	var count int
-// 	query := `SELECT COUNT(*) FROM "DocumentChunk" WHERE document_id = $1`
-// 	err := r.db.QueryRow(ctx, query, documentID).Scan(&count)
-// 	return count, err
-// }
+func (r *Repository) GetDocumentChunkCount(ctx context.Context, documentID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM "DocumentChunk" WHERE document_id = $1`
+	err := r.db.QueryRow(ctx, query, documentID).Scan(&count)
+	return count, err
+}
 
-func (r *Repository) ListDocuments(ctx context.Context, limit, offset string) ([]models.DocumentListItem, error) {
-	query := `SELECT id, filename, summary 
-			  FROM "Document" 
-			  WHERE status = 'processed' 
-			  ORDER BY created_at DESC 
-			  LIMIT $1 OFFSET $2`
+// ListDocuments returns processed documents matching filters, newest first.
+func (r *Repository) ListDocuments(ctx context.Context, limit, offset string, filters *models.SearchFilters) ([]models.DocumentListItem, error) {
+	b := &queryBuilder{}
+	b.where("d.status = 'processed'")
+	b.applyDocumentFilters(filters)
 
-	rows, err := r.db.Query(ctx, query, limit, offset)
+	query := fmt.Sprintf(`SELECT d.id, d.filename, d.summary
+			  FROM "Document" d
+			  %s
+			  ORDER BY d.created_at DESC
+			  LIMIT %s OFFSET %s`, b.clause(), b.arg(limit), b.arg(offset))
+
+	rows, err := r.db.Query(ctx, query, b.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -221,9 +319,9 @@ func (r *Repository) GetDocumentsByIDs(ctx context.Context, ids []string) ([]mod
 		args[i] = id
 	}
 
-	query := fmt.Sprintf(`SELECT id, filename, file_type, file_path, content, summary, metadata, status, created_at, updated_at 
+	query := fmt.Sprintf(`SELECT `+documentColumns+`
 			  FROM "Document" 
-			  WHERE id IN (%s)`, strings.Join(placeholders, ","))
+			  WHERE id IN (%s) AND deleted_at IS NULL`, strings.Join(placeholders, ","))
 
 	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
@@ -233,16 +331,11 @@ func (r *Repository) GetDocumentsByIDs(ctx context.Context, ids []string) ([]mod
 
 	var documents []models.Document
 	for rows.Next() {
-		var doc models.Document
-		err := rows.Scan(
-			&doc.ID, &doc.Filename, &doc.FileType, &doc.FilePath,
-			&doc.Content, &doc.Summary, &doc.Metadata, &doc.Status,
-			&doc.CreatedAt, &doc.UpdatedAt,
-		)
+		doc, err := scanDocument(rows)
 		if err != nil {
 			return nil, err
 		}
-		documents = append(documents, doc)
+		documents = append(documents, *doc)
 	}
 
 	return documents, nil
@@ -250,12 +343,77 @@ func (r *Repository) GetDocumentsByIDs(ctx context.Context, ids []string) ([]mod
 
 func (r *Repository) CreateDocument(ctx context.Context, doc *models.Document) error {
 	query := `INSERT INTO "Document" 
-			  (id, filename, file_type, file_path, content, summary, metadata, status, created_at, updated_at)
-			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())`
+			  (id, filename, file_type, file_path, content, summary, metadata, status,
+			   declared_content_type, detected_content_type, content_hash, file_size, duplicate_of,
+			   created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NOW(), NOW())`
 
 	_, err := r.db.Exec(ctx, query,
 		doc.ID, doc.Filename, doc.FileType, doc.FilePath,
 		doc.Content, doc.Summary, doc.Metadata, doc.Status,
+		doc.DeclaredContentType, doc.DetectedContentType, doc.ContentHash, doc.FileSize, doc.DuplicateOf,
 	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return fmt.Errorf("document %s %w", doc.ID, ErrAlreadyExists)
+	}
 	return err
 }
+
+// FindProcessedDocumentByHash returns the oldest processed document whose
+// file has the given SHA-256, or nil if there is none.
+func (r *Repository) FindProcessedDocumentByHash(ctx context.Context, contentHash string) (*models.Document, error) {
+	query := `SELECT ` + documentColumns + `
+			  FROM "Document"
+			  WHERE content_hash = $1 AND status = 'processed' AND deleted_at IS NULL
+			  ORDER BY created_at
+			  LIMIT 1`
+
+	doc, err := scanDocument(r.db.QueryRow(ctx, query, contentHash))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return doc, err
+}
+
+// LinkDocumentResults copies the processing results of a processed document
+// (content, summary, metadata, chunks with their embeddings, and pages) to
+// another document and marks it processed, so an identical file is not
+// processed twice.
+func (r *Repository) LinkDocumentResults(ctx context.Context, id, sourceID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE "Document" d
+			  SET content = s.content, search_text = s.search_text, summary = s.summary, metadata = s.metadata,
+			      status = 'processed', duplicate_of = s.id, updated_at = NOW()
+			  FROM "Document" s
+			  WHERE d.id = $1 AND s.id = $2 AND s.status = 'processed'`, id, sourceID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("processed document %w", ErrNotFound)
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO "DocumentChunk"
+			  (id, document_id, chunk_index, content, search_text, token_count, embedding, metadata, created_at, updated_at)
+			  SELECT gen_random_uuid()::text, $1, chunk_index, content, search_text, token_count, embedding, metadata, NOW(), NOW()
+			  FROM "DocumentChunk" WHERE document_id = $2`, id, sourceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO "DocumentPage"
+			  (document_id, page_number, status, content, summary, error, metadata, created_at, updated_at)
+			  SELECT $1, page_number, status, content, summary, error, metadata, NOW(), NOW()
+			  FROM "DocumentPage" WHERE document_id = $2`, id, sourceID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"document-embeddings/internal/models"
+)
+
+// DocumentStore is the persistence the services depend on. Repository
+// implements it on PostgreSQL; MemoryStore implements it in memory for tests.
+// Implementations wrap ErrNotFound where Repository does.
+type DocumentStore interface {
+	// Documents
+	CreateDocument(ctx context.Context, doc *models.Document) error
+	GetDocumentByID(ctx context.Context, id string) (*models.Document, error)
+	GetDocumentsByIDs(ctx context.Context, ids []string) ([]models.Document, error)
+	ListDocuments(ctx context.Context, limit, offset string, filters *models.SearchFilters) ([]models.DocumentListItem, error)
+	UpdateDocumentStatus(ctx context.Context, id, status string) error
+	UpdateDocumentContent(ctx context.Context, id, content, searchText string) error
+	UpdateDocumentSummary(ctx context.Context, id, summary string) error
+	UpdateDocumentMetadata(ctx context.Context, id string, metadata map[string]interface{}) error
+	FindProcessedDocumentByHash(ctx context.Context, contentHash string) (*models.Document, error)
+	LinkDocumentResults(ctx context.Context, id, sourceID string) error
+	DeleteDocument(ctx context.Context, id string) error
+
+	// Trash
+	TrashDocument(ctx context.Context, id string) error
+	RestoreDocument(ctx context.Context, id string) (*models.Document, error)
+	ListTrashedDocuments(ctx context.Context, limit, offset int) ([]models.TrashedDocument, error)
+	ListTrashedBefore(ctx context.Context, cutoff time.Time, limit int) ([]string, error)
+
+	// Stored objects
+	ReferencedFilePaths(ctx context.Context, paths []string) (map[string]bool, error)
+	ExistingDocumentIDs(ctx context.Context, ids []string) (map[string]bool, error)
+
+	// Chunks and search
+	ReplaceDocumentChunks(ctx context.Context, documentID string, chunks []models.DocumentChunk) error
+	GetDocumentChunks(ctx context.Context, documentID string) ([]models.DocumentChunk, error)
+	GetDocumentChunkCount(ctx context.Context, documentID string) (int, error)
+	SearchSimilarChunks(ctx context.Context, embedding []float32, limit int, minScore float64, filters *models.SearchFilters) ([]models.SearchResult, error)
+	SearchKeywordChunks(ctx context.Context, terms string, limit int, filters *models.SearchFilters) ([]models.SearchResult, error)
+
+	// Pages
+	InitDocumentPages(ctx context.Context, documentID string, total int) error
+	UpdateDocumentPage(ctx context.Context, page *models.DocumentPage) error
+	UpdateDocumentPageSummary(ctx context.Context, documentID string, pageNumber int, summary string) error
+	GetDocumentPages(ctx context.Context, documentID string) ([]models.DocumentPage, error)
+	GetDocumentPageCounts(ctx context.Context, documentID string) (*models.PageCounts, error)
+	DeleteDocumentPages(ctx context.Context, documentID string) error
+
+	// Processing jobs
+	EnqueueJob(ctx context.Context, job *models.ProcessingJob) error
+	ClaimJob(ctx context.Context, workerID string, lease time.Duration) (*models.ProcessingJob, error)
+	HeartbeatJob(ctx context.Context, id, workerID string, lease time.Duration) (bool, error)
+	CompleteJob(ctx context.Context, id, workerID string) error
+	FailJob(ctx context.Context, id, workerID, lastError string, retryAt *time.Time) error
+	ReleaseJob(ctx context.Context, id, workerID string) error
+	RequeueExpiredJobs(ctx context.Context) (int64, error)
+	RetryJob(ctx context.Context, id string) (*models.ProcessingJob, error)
+	GetLatestJobForDocument(ctx context.Context, documentID string) (*models.ProcessingJob, error)
+	ListJobs(ctx context.Context, status string, limit int) ([]models.ProcessingJob, error)
+
+	// Processing events
+	AppendEvent(ctx context.Context, event *models.ProcessingEvent) error
+	ListEventsAfter(ctx context.Context, afterID int64, documentIDs []string, limit int) ([]models.ProcessingEvent, error)
+	LatestEventID(ctx context.Context, documentID, eventType string) (int64, error)
+	DeleteEventsBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+var _ DocumentStore = (*Repository)(nil)
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"document-embeddings/internal/models"
+)
+
+// TrashDocument soft-deletes a document. Trashed documents are left out of
+// listings and search until they are restored or purged.
+func (r *Repository) TrashDocument(ctx context.Context, id string) error {
+	query := `UPDATE "Document" SET deleted_at = NOW(), updated_at = NOW()
+			  WHERE id = $1 AND deleted_at IS NULL`
+
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("document %w", ErrNotFound)
+	}
+	return nil
+}
+
+// RestoreDocument takes a document out of the trash.
+func (r *Repository) RestoreDocument(ctx context.Context, id string) (*models.Document, error) {
+	query := `UPDATE "Document" SET deleted_at = NULL, updated_at = NOW()
+			  WHERE id = $1 AND deleted_at IS NOT NULL
+			  RETURNING ` + documentColumns
+
+	doc, err := scanDocument(r.db.QueryRow(ctx, query, id))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("trashed document %w", ErrNotFound)
+	}
+	return doc, err
+}
+
+// ListTrashedDocuments lists trashed documents, most recently deleted first.
+// PurgeAt is left for the caller to fill in.
+func (r *Repository) ListTrashedDocuments(ctx context.Context, limit, offset int) ([]models.TrashedDocument, error) {
+	query := `SELECT id, filename, file_type, status, deleted_at
+			  FROM "Document"
+			  WHERE deleted_at IS NOT NULL
+			  ORDER BY deleted_at DESC
+			  LIMIT $1 OFFSET $2`
+
+	rows, err := r.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := []models.TrashedDocument{}
+	for rows.Next() {
+		var doc models.TrashedDocument
+		if err := rows.Scan(&doc.ID, &doc.Filename, &doc.FileType, &doc.Status, &doc.DeletedAt); err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+
+	return documents, rows.Err()
+}
+
+// ListTrashedBefore returns up to limit IDs of documents trashed before
+// cutoff.
+func (r *Repository) ListTrashedBefore(ctx context.Context, cutoff time.Time, limit int) ([]string, error) {
+	query := `SELECT id FROM "Document"
+			  WHERE deleted_at < $1
+			  ORDER BY deleted_at
+			  LIMIT $2`
+
+	rows, err := r.db.Query(ctx, query, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// vectorLiteral renders an embedding in pgvector's text format ("[1,2,3]") so
+// it can be bound as a plain parameter and cast with ::vector, without
+// registering a custom pgx type for the extension.
+func vectorLiteral(embedding []float32) *string {
+	if len(embedding) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range embedding {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(v), 'f', -1, 32))
+	}
+	b.WriteByte(']')
+
+	literal := b.String()
+	return &literal
+}
+
+// EmbeddingDimensions returns the dimension of the DocumentChunk.embedding
+// column, or 0 if the column does not fix one.
+func (r *Repository) EmbeddingDimensions(ctx context.Context) (int, error) {
+	// pgvector stores the dimension of vector(n) as the column's type modifier
+	query := `SELECT atttypmod FROM pg_attribute
+			  WHERE attrelid = '"DocumentChunk"'::regclass AND attname = 'embedding'`
+
+	var typmod int
+	if err := r.db.QueryRow(ctx, query).Scan(&typmod); err != nil {
+		return 0, err
+	}
+	return max(typmod, 0), nil
+}
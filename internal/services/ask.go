@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"document-embeddings/internal/config"
+	"document-embeddings/internal/models"
+	"document-embeddings/pkg/logger"
+	"document-embeddings/pkg/provider"
+)
+
+// ErrInvalidAskRequest marks ask errors caused by the caller's input.
+var ErrInvalidAskRequest = errors.New("invalid ask request")
+
+// noContextAnswer is the answer to questions no chunk matches.
+const noContextAnswer = "No documents matching the question were found."
+
+type AskService struct {
+	search *SearchService
+	chat   provider.ChatProvider
+	cfg    *config.Config
+	logger *logger.Logger
+}
+
+func NewAskService(search *SearchService, chat provider.ChatProvider, cfg *config.Config, logger *logger.Logger) *AskService {
+	return &AskService{
+		search: search,
+		chat:   chat,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// Ask answers a question from the chunks that best match it. The chat model
+// only sees those chunks, numbered as sources, and must cite the ones its
+// answer relies on. The question is refused without asking the model when no
+// chunk matches, and refused as unsupported when the model finds no answer
+// in the sources or cites none of them.
+func (s *AskService) Ask(ctx context.Context, req *models.AskRequest) (*models.AskResponse, error) {
+	results, err := s.retrieve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return noContextResponse(), nil
+	}
+
+	answer, err := s.chat.Answer(ctx, provider.AnswerRequest{Question: req.Question, Sources: answerSources(results)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to answer question: %w", err)
+	}
+
+	resp := &models.AskResponse{Answer: answer.Text, Citations: citations(answer.Sources, results)}
+	switch {
+	case !answer.Answered:
+		resp.RefusalReason = models.AskRefusalUnsupported
+		resp.Citations = []models.Citation{}
+	case len(resp.Citations) == 0:
+		s.logger.Warn("Discarding answer without citations", "questionLength", len([]rune(req.Question)), "sources", len(results))
+		resp.RefusalReason = models.AskRefusalUnsupported
+	default:
+		resp.Answered = true
+	}
+
+	return resp, nil
+}
+
+// sourceMarker matches the [n] source citations of a streamed answer.
+var sourceMarker = regexp.MustCompile(`\[(\d+)\]`)
+
+// AskStream answers like Ask, passing the answer to onDelta as the model
+// writes it. Streamed answers are plain text, so the sources are those
+// cited as [n] in the text; an answer citing none is refused as unsupported
+// once it is complete. Nothing is passed to onDelta for questions refused
+// without asking the model.
+func (s *AskService) AskStream(ctx context.Context, req *models.AskRequest, onDelta func(delta string) error) (*models.AskResponse, error) {
+	results, err := s.retrieve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return noContextResponse(), nil
+	}
+
+	text, err := s.chat.StreamAnswer(ctx, provider.AnswerRequest{Question: req.Question, Sources: answerSources(results)}, onDelta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to answer question: %w", err)
+	}
+
+	var numbers []int
+	for _, match := range sourceMarker.FindAllStringSubmatch(text, -1) {
+		number, _ := strconv.Atoi(match[1])
+		numbers = append(numbers, number)
+	}
+
+	resp := &models.AskResponse{Answer: text, Citations: citations(numbers, results)}
+	if len(resp.Citations) == 0 {
+		resp.RefusalReason = models.AskRefusalUnsupported
+	} else {
+		resp.Answered = true
+	}
+	return resp, nil
+}
+
+func noContextResponse() *models.AskResponse {
+	return &models.AskResponse{
+		Answer:        noContextAnswer,
+		RefusalReason: models.AskRefusalNoContext,
+		Citations:     []models.Citation{},
+	}
+}
+
+// answerSources numbers the chunks as sources for the model, from 1.
+func answerSources(results []models.SearchResult) []provider.Source {
+	sources := make([]provider.Source, len(results))
+	for i, result := range results {
+		sources[i] = provider.Source{Title: sourceTitle(result), Text: result.Content}
+	}
+	return sources
+}
+
+// citations returns the citations of the given source numbers, in order,
+// ignoring numbers that were not given to the model and repeats.
+func citations(numbers []int, results []models.SearchResult) []models.Citation {
+	cited := make(map[int]bool)
+	list := []models.Citation{}
+	for _, number := range numbers {
+		if number < 1 || number > len(results) || cited[number] {
+			continue
+		}
+		cited[number] = true
+		list = append(list, citation(number, results[number-1]))
+	}
+	return list
+}
+
+// retrieve returns the chunks to answer from, best first, within the
+// source and context limits.
+func (s *AskService) retrieve(ctx context.Context, req *models.AskRequest) ([]models.SearchResult, error) {
+	if strings.TrimSpace(req.Question) == "" {
+		return nil, fmt.Errorf("%w: question is empty", ErrInvalidAskRequest)
+	}
+
+	limit := s.cfg.Ask.MaxSources
+	if limit <= 0 {
+		limit = 8
+	}
+	if req.Limit < 0 {
+		return nil, fmt.Errorf("%w: limit must not be negative", ErrInvalidAskRequest)
+	}
+	if req.Limit > 0 {
+		limit = min(req.Limit, limit)
+	}
+
+	search := &models.SearchRequest{
+		Query:    req.Question,
+		Mode:     req.Mode,
+		Limit:    limit,
+		MinScore: s.cfg.Ask.MinScore,
+		Filters:  req.Filters,
+	}
+	if search.Mode == "" {
+		search.Mode = s.cfg.Ask.Mode
+	}
+	if req.MinScore != nil {
+		search.MinScore = *req.MinScore
+	}
+
+	// Invalid modes and filters are reported as ErrInvalidSearchRequest
+	resp, err := s.search.Search(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+
+	// The best chunk is always kept, however long
+	results := resp.Results
+	var size int
+	for i, result := range results {
+		size += len([]rune(result.Content))
+		if i > 0 && s.cfg.Ask.MaxContextChars > 0 && size > s.cfg.Ask.MaxContextChars {
+			results = results[:i]
+			break
+		}
+	}
+	return results, nil
+}
+
+// sourceTitle names a chunk for the model: its file and, for multi-page
+// documents, its pages.
+func sourceTitle(result models.SearchResult) string {
+	pages := chunkPageNumbers(result.Metadata)
+	switch len(pages) {
+	case 0:
+		return result.Filename
+	case 1:
+		return fmt.Sprintf("%s, page %d", result.Filename, pages[0])
+	default:
+		return fmt.Sprintf("%s, pages %d-%d", result.Filename, pages[0], pages[len(pages)-1])
+	}
+}
+
+func citation(number int, result models.SearchResult) models.Citation {
+	return models.Citation{
+		Source:      number,
+		DocumentID:  result.DocumentID,
+		Filename:    result.Filename,
+		ChunkID:     result.ChunkID,
+		ChunkIndex:  result.ChunkIndex,
+		PageNumbers: chunkPageNumbers(result.Metadata),
+		StartOffset: metadataInt(result.Metadata, "start_offset"),
+		EndOffset:   metadataInt(result.Metadata, "end_offset"),
+		Excerpt:     result.Content,
+		Score:       result.Score,
+	}
+}
+
+// chunkPageNumbers returns the page_numbers of a chunk's metadata, which
+// read back from JSONB as float64s.
+func chunkPageNumbers(metadata map[string]interface{}) []int {
+	switch v := metadata["page_numbers"].(type) {
+	case []int:
+		return v
+	case []interface{}:
+		numbers := make([]int, 0, len(v))
+		for _, item := range v {
+			if number, ok := item.(float64); ok {
+				numbers = append(numbers, int(number))
+			}
+		}
+		return numbers
+	}
+	return nil
+}
+
+func metadataInt(metadata map[string]interface{}, key string) int {
+	switch v := metadata[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
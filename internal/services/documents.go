@@ -0,0 +1,364 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"document-embeddings/internal/config"
+	"document-embeddings/internal/models"
+	"document-embeddings/internal/repository"
+	"document-embeddings/pkg/logger"
+	"document-embeddings/pkg/storage"
+)
+
+// documentsPrefix is where all uploads and their artifacts are stored.
+const documentsPrefix = "documents/"
+
+// sweepBatchSize is how many objects are checked against the database at a
+// time.
+const sweepBatchSize = 500
+
+// trashPurgeBatchSize is how many expired documents are purged per query.
+const trashPurgeBatchSize = 100
+
+// DocumentService owns the lifecycle of stored documents. Deleting a document
+// moves it to the trash, from which it can be restored until it is purged;
+// a permanent delete removes its database rows and its objects together.
+// Background loops purge expired trash and reconcile the bucket against the
+// Document table to catch objects left behind by failed deletes or uploads.
+type DocumentService struct {
+	repo       repository.DocumentStore
+	store      storage.ObjectStore
+	processing *ProcessingService
+	cfg        config.StorageConfig
+	logger     *logger.Logger
+	wg         sync.WaitGroup
+}
+
+func NewDocumentService(repo repository.DocumentStore, store storage.ObjectStore, processing *ProcessingService, cfg *config.Config, logger *logger.Logger) *DocumentService {
+	return &DocumentService{
+		repo:       repo,
+		store:      store,
+		processing: processing,
+		cfg:        cfg.Storage,
+		logger:     logger,
+	}
+}
+
+// TrashDocument moves a document to the trash. It disappears from listings
+// and search but keeps its rows and objects until restored or purged.
+func (s *DocumentService) TrashDocument(ctx context.Context, documentID string) error {
+	if err := s.repo.TrashDocument(ctx, documentID); err != nil {
+		return fmt.Errorf("failed to trash document: %w", err)
+	}
+
+	s.logger.Info("Document moved to trash", "documentId", documentID)
+	return nil
+}
+
+// RestoreDocument takes a document out of the trash. A document that was
+// still waiting to be processed when it was trashed is queued again, since
+// workers skip trashed documents.
+func (s *DocumentService) RestoreDocument(ctx context.Context, documentID string) (*models.Document, error) {
+	doc, err := s.repo.RestoreDocument(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore document: %w", err)
+	}
+
+	if doc.Status == "pending" || doc.Status == "processing" {
+		job, err := s.repo.GetLatestJobForDocument(ctx, documentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get processing job: %w", err)
+		}
+		if job == nil || (job.Status != models.JobStatusQueued && job.Status != models.JobStatusRunning) {
+			if err := s.processing.enqueueOrFail(ctx, documentID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	s.logger.Info("Document restored from trash", "documentId", documentID)
+	return doc, nil
+}
+
+// ListTrash lists trashed documents, most recently deleted first.
+func (s *DocumentService) ListTrash(ctx context.Context, limit, offset int) ([]models.TrashedDocument, error) {
+	documents, err := s.repo.ListTrashedDocuments(ctx, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+
+	for i := range documents {
+		documents[i].PurgeAt = documents[i].DeletedAt.Add(s.cfg.TrashRetention)
+	}
+	return documents, nil
+}
+
+// PurgeTrash permanently deletes documents that have been in the trash for
+// longer than Storage.TrashRetention and returns how many were purged. A
+// document that cannot be deleted is logged and skipped, so it does not hold
+// up the rest; the errors are returned together.
+func (s *DocumentService) PurgeTrash(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-s.cfg.TrashRetention)
+
+	purged := 0
+	var errs []error
+	failed := make(map[string]bool)
+	for {
+		// Failed documents are listed again; ask for enough to get past them
+		limit := trashPurgeBatchSize + len(failed)
+		ids, err := s.repo.ListTrashedBefore(ctx, cutoff, limit)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list expired trash: %w", err))
+			break
+		}
+
+		for _, id := range ids {
+			if failed[id] {
+				continue
+			}
+			if err := s.DeleteDocument(ctx, id); err != nil {
+				if ctx.Err() != nil {
+					return purged, err
+				}
+				s.logger.Error("Failed to purge trashed document", "documentId", id, "error", err)
+				failed[id] = true
+				errs = append(errs, fmt.Errorf("document %s: %w", id, err))
+				continue
+			}
+			purged++
+		}
+
+		if len(ids) < limit {
+			break
+		}
+	}
+
+	if purged > 0 {
+		s.logger.Info("Purged expired trash", "documents", purged)
+	}
+	return purged, errors.Join(errs...)
+}
+
+// DeleteDocument permanently deletes a document, trashed or not: its rows
+// (chunks, pages, jobs and the document itself) and then its stored objects.
+// Objects are removed after the rows so a failure never leaves a document
+// pointing at a missing file; an object that cannot be removed is logged and
+// left for the sweeper.
+func (s *DocumentService) DeleteDocument(ctx context.Context, documentID string) error {
+	doc, err := s.repo.GetDocumentByID(ctx, documentID)
+	if err != nil {
+		return fmt.Errorf("failed to get document: %w", err)
+	}
+
+	if err := s.repo.DeleteDocument(ctx, documentID); err != nil {
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+
+	keys, err := s.documentObjects(ctx, documentID, doc.FilePath)
+	if err != nil {
+		s.logger.Warn("Failed to list document objects", "documentId", documentID, "error", err)
+		return nil
+	}
+
+	// Linked duplicates share the original's file; keep it while in use
+	referenced, err := s.repo.ReferencedFilePaths(ctx, keys)
+	if err != nil {
+		s.logger.Warn("Failed to check object references", "documentId", documentID, "error", err)
+		return nil
+	}
+
+	for _, key := range keys {
+		if referenced[key] {
+			continue
+		}
+		if err := s.store.Delete(ctx, key); err != nil {
+			s.logger.Warn("Failed to remove document object", "documentId", documentID, "object", key, "error", err)
+		}
+	}
+
+	s.logger.Info("Document deleted", "documentId", documentID, "objects", len(keys))
+	return nil
+}
+
+// documentObjects lists the objects stored for a document: everything under
+// its prefix plus its file, which older uploads kept elsewhere.
+func (s *DocumentService) documentObjects(ctx context.Context, documentID, filePath string) ([]string, error) {
+	prefix := documentsPrefix + documentID + "/"
+
+	var keys []string
+	err := s.store.List(ctx, prefix, func(object storage.ObjectInfo) error {
+		keys = append(keys, object.Key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if filePath != "" && !strings.HasPrefix(filePath, prefix) {
+		keys = append(keys, filePath)
+	}
+	return keys, nil
+}
+
+// Start runs the trash purger every Storage.TrashPurgeInterval and the orphan
+// sweeper every Storage.OrphanSweepInterval until ctx is cancelled. A zero
+// interval disables the corresponding loop.
+func (s *DocumentService) Start(ctx context.Context) {
+	s.every(ctx, s.cfg.TrashPurgeInterval, func() {
+		if _, err := s.PurgeTrash(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("Trash purge failed", "error", err)
+		}
+	})
+
+	s.every(ctx, s.cfg.OrphanSweepInterval, func() {
+		if _, err := s.SweepOrphans(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("Orphan sweep failed", "error", err)
+		}
+	})
+}
+
+// every runs fn now and then once per interval until ctx is cancelled.
+func (s *DocumentService) every(ctx context.Context, interval time.Duration, fn func()) {
+	if interval <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			fn()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the background loops have stopped.
+func (s *DocumentService) Wait() {
+	s.wg.Wait()
+}
+
+// SweepReport summarizes one orphan sweep.
+type SweepReport struct {
+	Scanned int
+	Orphans []string
+	Removed int
+}
+
+// SweepOrphans finds objects under documents/ that belong to no document:
+// they are neither some document's file nor stored under the prefix of an
+// existing document. Objects younger than Storage.OrphanMinAge are skipped,
+// since an upload is stored before its document row is written. Orphans are
+// removed when Storage.OrphanDelete is set and only reported otherwise.
+func (s *DocumentService) SweepOrphans(ctx context.Context) (*SweepReport, error) {
+	report := &SweepReport{}
+	cutoff := time.Now().Add(-s.cfg.OrphanMinAge)
+
+	var batch []string
+	flush := func() error {
+		orphans, err := s.findOrphans(ctx, batch)
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+
+		for _, key := range orphans {
+			report.Orphans = append(report.Orphans, key)
+			if !s.cfg.OrphanDelete {
+				continue
+			}
+			if err := s.store.Delete(ctx, key); err != nil {
+				s.logger.Warn("Failed to remove orphaned object", "object", key, "error", err)
+				continue
+			}
+			report.Removed++
+		}
+		return nil
+	}
+
+	err := s.store.List(ctx, documentsPrefix, func(object storage.ObjectInfo) error {
+		report.Scanned++
+		if object.LastModified.After(cutoff) {
+			return nil
+		}
+
+		batch = append(batch, object.Key)
+		if len(batch) == sweepBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sweep objects: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if len(report.Orphans) > 0 {
+		s.logger.Warn("Found orphaned objects",
+			"scanned", report.Scanned, "orphans", len(report.Orphans), "removed", report.Removed,
+			"sample", report.Orphans[:min(len(report.Orphans), 10)],
+		)
+	} else {
+		s.logger.Info("Orphan sweep finished", "scanned", report.Scanned)
+	}
+
+	return report, nil
+}
+
+func (s *DocumentService) findOrphans(ctx context.Context, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	referenced, err := s.repo.ReferencedFilePaths(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check object references: %w", err)
+	}
+
+	var ids []string
+	for _, key := range keys {
+		if id, ok := objectDocumentID(key); ok {
+			ids = append(ids, id)
+		}
+	}
+	existing, err := s.repo.ExistingDocumentIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check documents: %w", err)
+	}
+
+	var orphans []string
+	for _, key := range keys {
+		if referenced[key] {
+			continue
+		}
+		if id, ok := objectDocumentID(key); ok && existing[id] {
+			continue
+		}
+		orphans = append(orphans, key)
+	}
+	return orphans, nil
+}
+
+// objectDocumentID extracts the document ID from a key of the form
+// documents/<id>/<name>.
+func objectDocumentID(key string) (string, bool) {
+	rest := strings.TrimPrefix(key, documentsPrefix)
+	id, _, found := strings.Cut(rest, "/")
+	return id, found && id != ""
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"document-embeddings/internal/config"
+	"document-embeddings/internal/models"
+	"document-embeddings/internal/repository"
+	"document-embeddings/pkg/logger"
+)
+
+// eventBatchSize is how many events a stream reads per query.
+const eventBatchSize = 100
+
+// EventService records processing events in the ProcessingEvent table and
+// streams them to subscribers. Because the log is in the database, a stream
+// sees events recorded by workers of every instance, and a client that
+// reconnects resumes after the last event it received.
+type EventService struct {
+	repo   repository.DocumentStore
+	cfg    config.EventsConfig
+	logger *logger.Logger
+	wg     sync.WaitGroup
+
+	// closed ends all streams, see Close
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// streams are woken whenever this instance records an event of a
+	// document they follow, so they need not wait for the next poll
+	streams map[*eventStream]struct{}
+}
+
+// eventStream is an open Subscribe call.
+type eventStream struct {
+	documentIDs []string
+	// wake holds at most one pending wake-up, so a burst of events costs a
+	// stream a single query
+	wake chan struct{}
+}
+
+func (e *eventStream) follows(documentID string) bool {
+	return len(e.documentIDs) == 0 || slices.Contains(e.documentIDs, documentID)
+}
+
+func NewEventService(repo repository.DocumentStore, cfg *config.Config, logger *logger.Logger) *EventService {
+	return &EventService{
+		repo:    repo,
+		cfg:     cfg.Events,
+		logger:  logger,
+		closed:  make(chan struct{}),
+		streams: make(map[*eventStream]struct{}),
+	}
+}
+
+// Publish records an event. Events only report progress, so a failure to
+// record one is logged rather than failing the caller.
+func (s *EventService) Publish(ctx context.Context, documentID, eventType string, data map[string]interface{}) {
+	event := &models.ProcessingEvent{DocumentID: documentID, Type: eventType, Data: data}
+	if err := s.repo.AppendEvent(ctx, event); err != nil {
+		s.logger.Warn("Failed to record processing event", "documentId", documentID, "type", eventType, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stream := range s.streams {
+		if stream.follows(documentID) {
+			select {
+			case stream.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// StartID returns the ID a new stream starts after. A client reconnecting
+// with the ID of the last event it received resumes after it. Otherwise a
+// stream of one document replays the document's latest processing run, from
+// the event that queued it, and a stream of several or all documents only
+// sees new events. A document that does not exist is repository.ErrNotFound.
+func (s *EventService) StartID(ctx context.Context, documentID string, lastEventID *int64) (int64, error) {
+	if documentID != "" {
+		if _, err := s.repo.GetDocumentByID(ctx, documentID); err != nil {
+			return 0, err
+		}
+	}
+	if lastEventID != nil {
+		return *lastEventID, nil
+	}
+
+	if documentID == "" {
+		id, err := s.repo.LatestEventID(ctx, "", "")
+		if err != nil {
+			return 0, fmt.Errorf("failed to get latest event: %w", err)
+		}
+		return id, nil
+	}
+
+	id, err := s.repo.LatestEventID(ctx, documentID, models.EventQueued)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest event: %w", err)
+	}
+	return max(id-1, 0), nil
+}
+
+// Subscribe calls send with every event after afterID, for the given
+// documents or for all documents if none are given, until ctx is cancelled,
+// the service is closed, or send returns an error, which is then returned.
+// While no events arrive, send is called with nil every
+// Events.HeartbeatInterval so the caller can keep its connection alive.
+func (s *EventService) Subscribe(ctx context.Context, afterID int64, documentIDs []string, send func(*models.ProcessingEvent) error) error {
+	poll := s.cfg.PollInterval
+	if poll <= 0 {
+		poll = time.Second
+	}
+	lastSent := time.Now()
+
+	// Register before the first query so an event recorded meanwhile is not
+	// missed
+	stream := &eventStream{documentIDs: documentIDs, wake: make(chan struct{}, 1)}
+	s.mu.Lock()
+	s.streams[stream] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, stream)
+		s.mu.Unlock()
+	}()
+
+	for {
+		events, err := s.repo.ListEventsAfter(ctx, afterID, documentIDs, eventBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to list events: %w", err)
+		}
+
+		for i := range events {
+			if err := send(&events[i]); err != nil {
+				return err
+			}
+			afterID = events[i].ID
+			lastSent = time.Now()
+		}
+		if len(events) == eventBatchSize {
+			continue
+		}
+
+		if s.cfg.HeartbeatInterval > 0 && time.Since(lastSent) >= s.cfg.HeartbeatInterval {
+			if err := send(nil); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		}
+
+		timer := time.NewTimer(poll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-s.closed:
+			timer.Stop()
+			return nil
+		case <-stream.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Close ends all streams, so that a server shutting down does not wait for
+// clients that would stay connected indefinitely.
+func (s *EventService) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// Start prunes events older than Events.Retention every Events.PruneInterval
+// until ctx is cancelled. A zero interval or retention disables pruning.
+func (s *EventService) Start(ctx context.Context) {
+	if s.cfg.PruneInterval <= 0 || s.cfg.Retention <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.cfg.PruneInterval)
+		defer ticker.Stop()
+
+		for {
+			deleted, err := s.repo.DeleteEventsBefore(ctx, time.Now().Add(-s.cfg.Retention))
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("Failed to prune processing events", "error", err)
+			}
+			if deleted > 0 {
+				s.logger.Info("Pruned processing events", "events", deleted)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the pruning loop has stopped.
+func (s *EventService) Wait() {
+	s.wg.Wait()
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"document-embeddings/internal/config"
+	"document-embeddings/internal/models"
+	"document-embeddings/internal/repository"
+	"document-embeddings/pkg/logger"
+)
+
+// JobService runs the durable processing queue: a bounded pool of workers
+// claims jobs from the ProcessingJob table, keeps their lease alive while
+// working, and retries failures with exponential backoff until they are
+// dead-lettered.
+type JobService struct {
+	repo       repository.DocumentStore
+	processing *ProcessingService
+	events     *EventService
+	cfg        config.QueueConfig
+	logger     *logger.Logger
+	wg         sync.WaitGroup
+}
+
+func NewJobService(repo repository.DocumentStore, processing *ProcessingService, events *EventService, cfg *config.Config, logger *logger.Logger) *JobService {
+	return &JobService{
+		repo:       repo,
+		processing: processing,
+		events:     events,
+		cfg:        cfg.Queue,
+		logger:     logger,
+	}
+}
+
+// Start requeues jobs orphaned by a previous crash and launches the workers.
+// Workers stop when ctx is cancelled; use Wait to block until they have.
+func (s *JobService) Start(ctx context.Context) {
+	s.requeueExpired(ctx)
+
+	hostname, _ := os.Hostname()
+	workers := max(s.cfg.Workers, 1)
+	for i := 0; i < workers; i++ {
+		workerID := fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), i)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.work(ctx, workerID)
+		}()
+	}
+
+	s.logger.Info("Job workers started", "workers", workers)
+}
+
+// Wait blocks until all workers have returned.
+func (s *JobService) Wait() {
+	s.wg.Wait()
+}
+
+func (s *JobService) ListJobs(ctx context.Context, status string, limit int) ([]models.ProcessingJob, error) {
+	return s.repo.ListJobs(ctx, status, limit)
+}
+
+// RetryJob puts a dead-lettered job back in the queue.
+func (s *JobService) RetryJob(ctx context.Context, jobID string) (*models.ProcessingJob, error) {
+	job, err := s.repo.RetryJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateDocumentStatus(ctx, job.DocumentID, "pending"); err != nil {
+		return nil, fmt.Errorf("failed to update document status: %w", err)
+	}
+
+	return job, nil
+}
+
+func (s *JobService) requeueExpired(ctx context.Context) {
+	count, err := s.repo.RequeueExpiredJobs(ctx)
+	if err != nil {
+		s.logger.Error("Failed to requeue expired jobs", "error", err)
+		return
+	}
+	if count > 0 {
+		s.logger.Warn("Requeued jobs with expired leases", "count", count)
+	}
+}
+
+func (s *JobService) work(ctx context.Context, workerID string) {
+	for {
+		job, err := s.repo.ClaimJob(ctx, workerID, s.cfg.LeaseDuration)
+		if err != nil && ctx.Err() == nil {
+			s.logger.Error("Failed to claim job", "worker", workerID, "error", err)
+		}
+
+		if job != nil {
+			s.run(ctx, workerID, job)
+			continue
+		}
+
+		// Idle: pick up leases abandoned by crashed workers, then wait
+		s.requeueExpired(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.cfg.PollInterval):
+		}
+	}
+}
+
+func (s *JobService) run(ctx context.Context, workerID string, job *models.ProcessingJob) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var leaseLost bool
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		leaseLost = s.heartbeat(jobCtx, cancel, workerID, job)
+	}()
+
+	err := s.processing.runJob(jobCtx, job.DocumentID)
+	cancel()
+	<-heartbeatDone
+
+	// Use a fresh context: the job outcome must be recorded even when the
+	// worker is shutting down.
+	recordCtx, recordCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer recordCancel()
+
+	switch {
+	case leaseLost:
+		// Another worker owns the job now; it records the outcome.
+		return
+
+	case err == nil:
+		if err := s.repo.CompleteJob(recordCtx, job.ID, workerID); err != nil {
+			s.logger.Error("Failed to complete job", "jobId", job.ID, "error", err)
+		}
+		s.events.Publish(recordCtx, job.DocumentID, models.EventProcessed, map[string]interface{}{"jobId": job.ID})
+
+	case ctx.Err() != nil:
+		s.logger.Info("Releasing job on shutdown", "jobId", job.ID, "documentId", job.DocumentID)
+		if err := s.repo.ReleaseJob(recordCtx, job.ID, workerID); err != nil {
+			s.logger.Error("Failed to release job", "jobId", job.ID, "error", err)
+		}
+		if err := s.repo.UpdateDocumentStatus(recordCtx, job.DocumentID, "pending"); err != nil {
+			s.logger.Error("Failed to update document status", "documentId", job.DocumentID, "error", err)
+		}
+
+	default:
+		s.fail(recordCtx, workerID, job, err)
+	}
+}
+
+func (s *JobService) fail(ctx context.Context, workerID string, job *models.ProcessingJob, jobErr error) {
+	var retryAt *time.Time
+	status := "failed"
+	if job.Attempts < job.MaxAttempts {
+		next := time.Now().Add(s.backoff(job.Attempts))
+		retryAt = &next
+		status = "pending"
+	}
+
+	s.logger.Error("Failed to process document",
+		"documentId", job.DocumentID, "jobId", job.ID,
+		"attempt", job.Attempts, "maxAttempts", job.MaxAttempts,
+		"retryAt", retryAt, "error", jobErr,
+	)
+
+	if err := s.repo.FailJob(ctx, job.ID, workerID, jobErr.Error(), retryAt); err != nil {
+		s.logger.Error("Failed to record job failure", "jobId", job.ID, "error", err)
+	}
+	if err := s.repo.UpdateDocumentStatus(ctx, job.DocumentID, status); err != nil {
+		s.logger.Error("Failed to update document status", "documentId", job.DocumentID, "error", err)
+	}
+
+	data := map[string]interface{}{
+		"jobId":       job.ID,
+		"error":       jobErr.Error(),
+		"attempt":     job.Attempts,
+		"maxAttempts": job.MaxAttempts,
+		"willRetry":   retryAt != nil,
+	}
+	if retryAt != nil {
+		data["retryAt"] = *retryAt
+	}
+	s.events.Publish(ctx, job.DocumentID, models.EventFailed, data)
+}
+
+// heartbeat extends the job lease until ctx ends. If the lease is lost the job
+// context is cancelled so the worker stops duplicating someone else's work,
+// and true is returned.
+func (s *JobService) heartbeat(ctx context.Context, cancel context.CancelFunc, workerID string, job *models.ProcessingJob) bool {
+	ticker := time.NewTicker(max(s.cfg.LeaseDuration/3, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			owned, err := s.repo.HeartbeatJob(ctx, job.ID, workerID, s.cfg.LeaseDuration)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Warn("Failed to extend job lease", "jobId", job.ID, "error", err)
+				}
+				continue
+			}
+			if !owned {
+				s.logger.Warn("Lost job lease", "jobId", job.ID, "worker", workerID)
+				cancel()
+				return true
+			}
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt: base * 2^(attempt-1),
+// capped at the configured maximum, with up to 20% jitter so jobs that failed
+// together do not retry together.
+func (s *JobService) backoff(attempt int) time.Duration {
+	delay := s.cfg.RetryBaseDelay
+	for i := 1; i < attempt && delay < s.cfg.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, s.cfg.RetryMaxDelay)
+
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	}
+	return delay
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"document-embeddings/pkg/ocr"
+	"document-embeddings/pkg/persian"
+	"document-embeddings/pkg/provider"
+)
+
+const extractionMethodTesseract = "tesseract_ocr"
+
+// Why a page read by Tesseract was sent to the vision model.
+const (
+	escalationLowConfidence  = "low_confidence"
+	escalationNoText         = "no_text"
+	escalationTesseractError = "tesseract_failed"
+)
+
+// pageResult is the text of a page or image and how it was obtained. Metadata
+// holds OCR details such as Tesseract's confidence and is stored with the
+// page next to the extraction method.
+type pageResult struct {
+	Text     string
+	Method   string
+	Metadata map[string]interface{}
+}
+
+// tesseractMode returns the configured OCR.TesseractMode, or ocr.ModeOff
+// when no engine is available.
+func (s *ProcessingService) tesseractMode() string {
+	if s.ocr == nil {
+		return ocr.ModeOff
+	}
+	return strings.ToLower(s.cfg.OCR.TesseractMode)
+}
+
+// recognize reads the text of an image, combining Tesseract and the vision
+// model as OCR.TesseractMode says. readWithVision sends the image to the
+// vision model; it is only called when the mode needs it.
+func (s *ProcessingService) recognize(ctx context.Context, imageData []byte, readWithVision func() (string, error)) (*pageResult, error) {
+	switch s.tesseractMode() {
+	case ocr.ModePrimary:
+		return s.recognizeTesseractFirst(ctx, imageData, readWithVision)
+
+	case ocr.ModeFallback:
+		text, err := readWithVision()
+		if err == nil || ctx.Err() != nil {
+			return &pageResult{Text: text, Method: extractionMethodVision}, err
+		}
+		result, ocrErr := s.runTesseract(ctx, imageData)
+		if ocrErr != nil {
+			return nil, fmt.Errorf("%w (Tesseract fallback failed too: %v)", err, ocrErr)
+		}
+		result.Metadata["fallback_reason"] = err.Error()
+		return result, nil
+
+	case ocr.ModeCompare:
+		text, err := readWithVision()
+		if err != nil {
+			return nil, err
+		}
+		result := &pageResult{Text: text, Method: extractionMethodVision, Metadata: map[string]interface{}{}}
+		compared, ocrErr := s.ocr.Recognize(ctx, imageData)
+		if ocrErr != nil {
+			result.Metadata["tesseract_error"] = ocrErr.Error()
+		} else {
+			result.Metadata["tesseract_confidence"] = round3(compared.Confidence)
+			result.Metadata["tesseract_agreement"] = round3(textAgreement(text, compared.Text))
+		}
+		return result, nil
+
+	default:
+		text, err := readWithVision()
+		if err != nil {
+			return nil, err
+		}
+		return &pageResult{Text: text, Method: extractionMethodVision}, nil
+	}
+}
+
+// recognizeTesseractFirst reads an image with Tesseract and escalates it to
+// the vision model when OCR.EscalationPolicy says so. If the vision model
+// fails, Tesseract's text is kept rather than losing the page.
+func (s *ProcessingService) recognizeTesseractFirst(ctx context.Context, imageData []byte, readWithVision func() (string, error)) (*pageResult, error) {
+	result, err := s.runTesseract(ctx, imageData)
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+
+	reason := escalationTesseractError
+	if err == nil {
+		reason = s.escalationReason(result)
+	}
+	if reason == "" || strings.ToLower(s.cfg.OCR.EscalationPolicy) == ocr.EscalateNever {
+		return result, err
+	}
+
+	text, visionErr := readWithVision()
+	if visionErr != nil {
+		if ctx.Err() != nil {
+			return nil, visionErr
+		}
+		if result == nil {
+			return nil, fmt.Errorf("vision model failed after Tesseract failed (%v): %w", err, visionErr)
+		}
+		result.Metadata["escalation_error"] = visionErr.Error()
+		return result, nil
+	}
+
+	metadata := map[string]interface{}{"escalation_reason": reason}
+	if result != nil {
+		metadata["ocr_confidence"] = result.Metadata["ocr_confidence"]
+	}
+	return &pageResult{Text: text, Method: extractionMethodVision, Metadata: metadata}, nil
+}
+
+// escalationReason returns why a Tesseract result should go to the vision
+// model, or "" if it is good enough.
+func (s *ProcessingService) escalationReason(result *pageResult) string {
+	if strings.TrimSpace(result.Text) == "" {
+		return escalationNoText
+	}
+
+	confidence, _ := result.Metadata["ocr_confidence"].(float64)
+	switch strings.ToLower(s.cfg.OCR.EscalationPolicy) {
+	case ocr.EscalateEmpty, ocr.EscalateNever:
+		return ""
+	default:
+		if confidence < s.cfg.OCR.MinConfidence {
+			return escalationLowConfidence
+		}
+		return ""
+	}
+}
+
+func (s *ProcessingService) runTesseract(ctx context.Context, imageData []byte) (*pageResult, error) {
+	result, err := s.ocr.Recognize(ctx, imageData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pageResult{
+		Text:     result.Text,
+		Method:   extractionMethodTesseract,
+		Metadata: map[string]interface{}{"ocr_confidence": round3(result.Confidence)},
+	}, nil
+}
+
+// readImage analyzes an uploaded image and returns the analysis with the
+// document metadata to store for it. The vision model's analysis is used
+// whenever it was consulted; an image read by Tesseract alone gets its text
+// as the summary, like other documents without an analysis.
+func (s *ProcessingService) readImage(ctx context.Context, imageData []byte, fileType string) (*provider.ImageAnalysis, map[string]interface{}, error) {
+	var analysis *provider.ImageAnalysis
+	result, err := s.recognize(ctx, imageData, func() (string, error) {
+		var err error
+		analysis, err = s.analyzeImage(ctx, imageData, fileType)
+		if err != nil {
+			return "", err
+		}
+		return analysis.Text(), nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata := make(map[string]interface{})
+	if result.Method != extractionMethodVision || analysis == nil {
+		analysis = &provider.ImageAnalysis{Summary: result.Text, RawTextContent: result.Text}
+	} else {
+		if err := imageMetadata(analysis, metadata); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	metadata["extraction_method"] = result.Method
+	for key, value := range result.Metadata {
+		metadata[key] = value
+	}
+	return analysis, metadata, nil
+}
+
+// imageMetadata adds the fields of an analysis's metadata to metadata with
+// their JSON types, leaving out the ones the model left empty. A reply that
+// did not match the schema is recorded as response_error instead.
+func imageMetadata(analysis *provider.ImageAnalysis, metadata map[string]interface{}) error {
+	if analysis.ResponseError != "" {
+		metadata["response_error"] = analysis.ResponseError
+		return nil
+	}
+
+	data, err := json.Marshal(analysis.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image metadata: %w", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("failed to unmarshal image metadata: %w", err)
+	}
+
+	for key, value := range fields {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				continue
+			}
+		}
+		metadata[key] = value
+	}
+	return nil
+}
+
+// textAgreement is the share of distinct words two texts have in common
+// (Jaccard similarity), after Persian normalization.
+func textAgreement(a, b string) float64 {
+	words := func(text string) map[string]bool {
+		set := make(map[string]bool)
+		for _, word := range strings.Fields(strings.ToLower(persian.Normalize(text))) {
+			set[word] = true
+		}
+		return set
+	}
+
+	wa, wb := words(a), words(b)
+	if len(wa) == 0 && len(wb) == 0 {
+		return 1
+	}
+
+	var shared int
+	for word := range wa {
+		if wb[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(wa)+len(wb)-shared)
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
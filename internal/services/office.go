@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"document-embeddings/pkg/office"
+	"document-embeddings/pkg/provider"
+)
+
+// extractTextFromOffice renders an OOXML or ODF document as Markdown.
+// Embedded images large enough to carry content are sent to image analysis,
+// at most OCR.PageConcurrency at a time, and their descriptions are appended
+// as sections of their own. A failed image is logged and skipped.
+func (s *ProcessingService) extractTextFromOffice(ctx context.Context, documentID, path, format string) (string, map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	doc, err := office.Extract(f, info.Size(), format, int64(s.cfg.Office.MaxEntrySizeMB)<<20)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to extract %s: %w", format, err)
+	}
+
+	var images []office.Image
+	if s.cfg.Office.AnalyzeImages {
+		for _, image := range doc.Images {
+			// Skip icons, bullets and other decoration
+			if image.Size < int64(s.cfg.Office.MinImageBytes) {
+				continue
+			}
+			if len(images) == s.cfg.Office.MaxImages {
+				break
+			}
+			images = append(images, image)
+		}
+	}
+
+	descriptions := make([]string, len(images))
+	sem := make(chan struct{}, max(s.cfg.OCR.PageConcurrency, 1))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+
+	for i, image := range images {
+		wg.Add(1)
+		go func(index int, image office.Image) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			analysis, err := s.analyzeEmbeddedImage(ctx, image, format)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				s.logger.Warn("Failed to analyze embedded image", "documentId", documentID, "image", image.Name, "error", err)
+				return
+			}
+
+			parts := []string{"### Image: " + image.Name}
+			if analysis.Summary != "" {
+				parts = append(parts, analysis.Summary)
+			}
+			if analysis.RawTextContent != "" {
+				parts = append(parts, analysis.RawTextContent)
+			}
+			descriptions[index] = strings.Join(parts, "\n\n")
+		}(i, image)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+
+	sections := []string{doc.Text}
+	for _, description := range descriptions {
+		if description != "" {
+			sections = append(sections, description)
+		}
+	}
+
+	text := strings.TrimSpace(strings.Join(sections, "\n\n"))
+	if text == "" {
+		return "", nil, fmt.Errorf("no text found in %s document", format)
+	}
+
+	metadata := map[string]interface{}{
+		"embedded_images": len(doc.Images),
+		"analyzed_images": len(images) - failed,
+		"failed_images":   failed,
+	}
+
+	return text, metadata, nil
+}
+
+func (s *ProcessingService) analyzeEmbeddedImage(ctx context.Context, image office.Image, format string) (*provider.ImageAnalysis, error) {
+	data, err := image.Data()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return s.vision.AnalyzeImage(ctx, provider.ImageInput{Data: data, MimeType: image.MimeType, DocumentType: format})
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"document-embeddings/internal/models"
+	"document-embeddings/internal/repository"
+	"document-embeddings/pkg/ocr"
+	"document-embeddings/pkg/provider"
+)
+
+const (
+	extractionMethodTextLayer = "text_layer"
+	extractionMethodVision    = "vision_ocr"
+)
+
+// ErrCannotRetryPages marks documents whose failed pages cannot be retried
+// in their current state.
+var ErrCannotRetryPages = errors.New("cannot retry failed pages")
+
+// pageSource is one page of a multi-page document: either text taken from the
+// PDF text layer, or a rendered image that still needs OCR.
+type pageSource struct {
+	Text      string
+	ImagePath string
+}
+
+// extractPages turns page sources into text. Text-layer pages are recorded as
+// they are; image pages are OCRed concurrently, at most OCR.PageConcurrency at
+// a time. Each page's outcome, extraction method and OCR details (see
+// recognize) are recorded in DocumentPage as it finishes. Pages already
+// processed by an earlier run are reused instead of being sent to the model
+// again, so retrying a document only pays for the pages that failed.
+//
+// A failed page does not fail the document; it is recorded with its error and
+// left out of the text. Only when no page succeeds is an error returned. The
+// returned metadata counts the pages extracted with each method.
+func (s *ProcessingService) extractPages(ctx context.Context, documentID string, pages []pageSource) (string, map[string]interface{}, error) {
+	existing, err := s.repo.GetDocumentPages(ctx, documentID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get document pages: %w", err)
+	}
+
+	if err := s.repo.InitDocumentPages(ctx, documentID, len(pages)); err != nil {
+		return "", nil, fmt.Errorf("failed to initialize document pages: %w", err)
+	}
+
+	texts := make([]string, len(pages))
+	methods := make([]string, len(pages))
+	for _, page := range existing {
+		if page.Status == models.PageStatusProcessed && page.Content != nil && page.PageNumber <= len(pages) {
+			texts[page.PageNumber-1] = *page.Content
+			methods[page.PageNumber-1], _ = page.Metadata["extraction_method"].(string)
+			if methods[page.PageNumber-1] == "" {
+				methods[page.PageNumber-1] = extractionMethodVision
+			}
+		}
+	}
+
+	concurrency := max(s.cfg.OCR.PageConcurrency, 1)
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+
+	for i, source := range pages {
+		if methods[i] != "" {
+			continue
+		}
+
+		if source.Text != "" {
+			texts[i] = source.Text
+			methods[i] = extractionMethodTextLayer
+			s.recordPage(ctx, documentID, i+1, &pageResult{Text: source.Text, Method: extractionMethodTextLayer}, nil)
+			continue
+		}
+
+		if source.ImagePath == "" {
+			// Pages started earlier may be counting failures already
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			s.recordPage(ctx, documentID, i+1, &pageResult{}, fmt.Errorf("page was not rendered"))
+			continue
+		}
+
+		wg.Add(1)
+		go func(index int, imagePath string) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			result, err := s.ocrPage(ctx, imagePath)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				s.logger.Warn("Failed to extract text from PDF page", "documentId", documentID, "page", index+1, "error", err)
+				s.recordPage(ctx, documentID, index+1, &pageResult{Method: s.ocrMethod()}, err)
+				return
+			}
+
+			texts[index] = result.Text
+			methods[index] = result.Method
+			s.recordPage(ctx, documentID, index+1, result, nil)
+		}(i, source.ImagePath)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+
+	if len(pages) > 0 && failed == len(pages) {
+		return "", nil, fmt.Errorf("text extraction failed for all %d pages", len(pages))
+	}
+	if failed > 0 {
+		s.logger.Warn("Some PDF pages failed", "documentId", documentID, "failed", failed, "total", len(pages))
+	}
+
+	var extractedTexts []string
+	methodCounts := map[string]interface{}{}
+	for i, text := range texts {
+		if methods[i] != "" {
+			count, _ := methodCounts[methods[i]].(int)
+			methodCounts[methods[i]] = count + 1
+		}
+		if text != "" {
+			extractedTexts = append(extractedTexts, text)
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"page_count":         len(pages),
+		"extraction_methods": methodCounts,
+	}
+
+	return strings.Join(extractedTexts, "\n\n"), metadata, nil
+}
+
+func (s *ProcessingService) ocrPage(ctx context.Context, imagePath string) (*pageResult, error) {
+	imageData, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.recognize(ctx, imageData, func() (string, error) {
+		return s.vision.ExtractTextFromImage(ctx, provider.ImageInput{
+			Data:         imageData,
+			MimeType:     s.rasterizer.MimeType(),
+			DocumentType: "pdf",
+		})
+	})
+}
+
+// ocrMethod is the extraction method pages are OCRed with first.
+func (s *ProcessingService) ocrMethod() string {
+	if s.tesseractMode() == ocr.ModePrimary {
+		return extractionMethodTesseract
+	}
+	return extractionMethodVision
+}
+
+func (s *ProcessingService) recordPage(ctx context.Context, documentID string, pageNumber int, result *pageResult, pageErr error) {
+	page := &models.DocumentPage{
+		DocumentID: documentID,
+		PageNumber: pageNumber,
+		Status:     models.PageStatusProcessed,
+		Content:    &result.Text,
+	}
+	if result.Method != "" {
+		page.Metadata = map[string]interface{}{"extraction_method": result.Method}
+		for key, value := range result.Metadata {
+			page.Metadata[key] = value
+		}
+	}
+	if pageErr != nil {
+		errMsg := pageErr.Error()
+		page.Status = models.PageStatusFailed
+		page.Content = nil
+		page.Error = &errMsg
+	}
+
+	if err := s.repo.UpdateDocumentPage(ctx, page); err != nil {
+		s.logger.Error("Failed to record page result", "documentId", documentID, "page", pageNumber, "error", err)
+	}
+
+	data := map[string]interface{}{"pageNumber": pageNumber, "status": page.Status}
+	if page.Error != nil {
+		data["error"] = *page.Error
+	}
+	s.events.Publish(ctx, documentID, models.EventPage, data)
+}
+
+// RetryFailedPages queues a document again so that only its failed pages are
+// sent back to OCR; processed pages are reused.
+func (s *ProcessingService) RetryFailedPages(ctx context.Context, documentID string) error {
+	doc, err := s.repo.GetDocumentByID(ctx, documentID)
+	if err != nil {
+		return fmt.Errorf("failed to get document: %w", err)
+	}
+
+	if doc.DeletedAt != nil {
+		return fmt.Errorf("%w: document is in the trash", ErrCannotRetryPages)
+	}
+
+	if doc.Status == "processing" || doc.Status == "pending" {
+		return fmt.Errorf("%w: document is already being processed", ErrCannotRetryPages)
+	}
+
+	counts, err := s.repo.GetDocumentPageCounts(ctx, documentID)
+	if err != nil {
+		return fmt.Errorf("failed to get page counts: %w", err)
+	}
+	if counts.Failed == 0 {
+		return fmt.Errorf("%w: document has no failed pages", ErrCannotRetryPages)
+	}
+
+	if err := s.enqueue(ctx, documentID); err != nil {
+		if errors.Is(err, repository.ErrActiveJob) {
+			return fmt.Errorf("%w: document is already being processed", ErrCannotRetryPages)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ProcessingService) GetDocumentPages(ctx context.Context, documentID string) ([]models.DocumentPage, error) {
+	return s.repo.GetDocumentPages(ctx, documentID)
+}
@@ -4,40 +4,76 @@ package services
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/minio/minio-go/v7"
+	"github.com/google/uuid"
 
 	"document-embeddings/internal/config"
+	"document-embeddings/internal/filetypes"
 	"document-embeddings/internal/models"
 	"document-embeddings/internal/repository"
 	"document-embeddings/pkg/logger"
-	minioClient "document-embeddings/pkg/minio"
-	"document-embeddings/pkg/openai"
+	"document-embeddings/pkg/ocr"
+	"document-embeddings/pkg/pdf"
+	"document-embeddings/pkg/persian"
+	"document-embeddings/pkg/provider"
+	"document-embeddings/pkg/storage"
+	"document-embeddings/pkg/textdoc"
 )
 
+// ErrInvalidUpload marks uploads rejected because of their content, such as
+// an unsupported file type or content that contradicts the declared type.
+var ErrInvalidUpload = errors.New("invalid upload")
+
+// DuplicateError is returned when an uploaded file is identical to one that
+// was already processed and the caller did not say how to handle it.
+type DuplicateError struct {
+	DocumentID string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("an identical file was already processed as document %s", e.DocumentID)
+}
+
 type ProcessingService struct {
-	repo   *repository.Repository
-	minio  *minioClient.Client
-	openai *openai.Client
-	cfg    *config.Config
-	logger *logger.Logger
+	repo       repository.DocumentStore
+	store      storage.ObjectStore
+	embedder   provider.EmbeddingProvider
+	vision     provider.VisionProvider
+	chat       provider.ChatProvider
+	ocr        ocr.Engine
+	rasterizer pdf.Rasterizer
+	events     *EventService
+	cfg        *config.Config
+	logger     *logger.Logger
 }
 
-func NewProcessingService(repo *repository.Repository, minio *minioClient.Client, openai *openai.Client, cfg *config.Config, logger *logger.Logger) *ProcessingService {
+// NewProcessingService creates the processing service. ocrEngine may be nil,
+// in which case every image is read by the vision model.
+func NewProcessingService(repo repository.DocumentStore, store storage.ObjectStore, embedder provider.EmbeddingProvider, vision provider.VisionProvider, chat provider.ChatProvider, ocrEngine ocr.Engine, rasterizer pdf.Rasterizer, events *EventService, cfg *config.Config, logger *logger.Logger) *ProcessingService {
 	return &ProcessingService{
-		repo:   repo,
-		minio:  minio,
-		openai: openai,
-		cfg:    cfg,
-		logger: logger,
+		repo:       repo,
+		store:      store,
+		embedder:   embedder,
+		vision:     vision,
+		chat:       chat,
+		ocr:        ocrEngine,
+		rasterizer: rasterizer,
+		events:     events,
+		cfg:        cfg,
+		logger:     logger,
 	}
 }
 
@@ -48,92 +84,235 @@ func (s *ProcessingService) ProcessDocument(ctx context.Context, documentID stri
 		return fmt.Errorf("failed to get document: %w", err)
 	}
 
-	if doc.Status == "processing" {
-		return fmt.Errorf("document is already being processed")
+	if doc.DeletedAt != nil {
+		return fmt.Errorf("document is in the trash")
 	}
 
-	// Update status to processing
-	if err := s.repo.UpdateDocumentStatus(ctx, documentID, "processing"); err != nil {
-		return fmt.Errorf("failed to update document status: %w", err)
+	if doc.Status == "processing" || doc.Status == "pending" {
+		return fmt.Errorf("document is already being processed")
 	}
 
-	// Process document in background
-	go func() {
-		if err := s.processDocumentAsync(context.Background(), doc); err != nil {
-			s.logger.Error("Failed to process document", "documentId", documentID, "error", err)
-			s.repo.UpdateDocumentStatus(context.Background(), documentID, "failed")
-		}
-	}()
+	// A full reprocess starts over instead of reusing page results
+	if err := s.repo.DeleteDocumentPages(ctx, documentID); err != nil {
+		return fmt.Errorf("failed to reset document pages: %w", err)
+	}
 
-	return nil
+	// Queue document for processing
+	return s.enqueue(ctx, documentID)
 }
 
-func (s *ProcessingService) ProcessDocumentWithFile(ctx context.Context, documentID string, file *multipart.FileHeader) error {
-	// Check if document already exists and is processing
+// ProcessDocumentWithFile stores an upload and queues it for processing.
+// When an identical file was already processed, onDuplicate decides whether
+// the upload is rejected with a DuplicateError, linked to the existing
+// results, or processed again (see the models.DuplicatePolicy constants).
+func (s *ProcessingService) ProcessDocumentWithFile(ctx context.Context, documentID string, file *multipart.FileHeader, onDuplicate string) (*models.Document, error) {
+	// An upload creates a new document, so a taken ID is rejected before
+	// anything is stored
 	existingDoc, err := s.repo.GetDocumentByID(ctx, documentID)
-	if err == nil && existingDoc.Status == "processing" {
-		return fmt.Errorf("document is already being processed")
+	switch {
+	case err == nil && existingDoc.DeletedAt != nil:
+		return nil, fmt.Errorf("document %s %w in the trash; restore it or delete it permanently first", documentID, repository.ErrAlreadyExists)
+	case err == nil:
+		return nil, fmt.Errorf("document %s %w", documentID, repository.ErrAlreadyExists)
+	case !errors.Is(err, repository.ErrNotFound):
+		return nil, fmt.Errorf("failed to get document: %w", err)
 	}
 
 	// Open uploaded file
 	src, err := file.Open()
 	if err != nil {
-		return fmt.Errorf("failed to open uploaded file: %w", err)
+		return nil, fmt.Errorf("failed to open uploaded file: %w", err)
 	}
 	defer src.Close()
 
-	// Read file data
-	fileData, err := io.ReadAll(src)
-	if err != nil {
-		return fmt.Errorf("failed to read uploaded file: %w", err)
+	// Only the first bytes are read up front; the rest is streamed
+	head := make([]byte, filetypes.SniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("failed to read uploaded file: %w", err)
 	}
+	head = head[:n]
 
-	// Determine file type from content type
-	fileType := s.getFileTypeFromContentType(file.Header.Get("Content-Type"))
-	if fileType == "" {
-		return fmt.Errorf("unsupported file type")
+	// Determine the file type from the content, checked against the
+	// extension and the declared Content-Type
+	declaredType := file.Header.Get("Content-Type")
+	fileType, detectedType, err := filetypes.Detect(head, file.Filename, declaredType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUpload, err)
 	}
 
-	// Upload file to MinIO
-	filePath := fmt.Sprintf("documents/%s", file.Filename)
-	if err := s.uploadFileToMinIO(ctx, filePath, fileData, file.Header.Get("Content-Type")); err != nil {
-		return fmt.Errorf("failed to upload file to MinIO: %w", err)
+	// Hash the file first: both the object key and the duplicate check need it
+	hash := sha256.New()
+	size, err := io.Copy(hash, io.MultiReader(bytes.NewReader(head), src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded file: %w", err)
 	}
+	contentHash := hex.EncodeToString(hash.Sum(nil))
 
 	// Create document record
 	doc := &models.Document{
-		ID:       documentID,
-		Filename: file.Filename,
-		FileType: fileType,
-		FilePath: filePath,
-		Status:   "pending",
+		ID:                  documentID,
+		Filename:            file.Filename,
+		FileType:            fileType.Name,
+		Status:              "pending",
+		DetectedContentType: &detectedType,
+		ContentHash:         &contentHash,
+		FileSize:            &size,
+	}
+	if declaredType != "" {
+		doc.DeclaredContentType = &declaredType
+	}
+
+	if onDuplicate != models.DuplicatePolicyProcess {
+		original, err := s.repo.FindProcessedDocumentByHash(ctx, contentHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up duplicate documents: %w", err)
+		}
+		if original != nil && original.ID != documentID {
+			if onDuplicate != models.DuplicatePolicyLink {
+				return nil, &DuplicateError{DocumentID: original.ID}
+			}
+			return doc, s.linkDuplicate(ctx, doc, original)
+		}
+	}
+
+	// Store the file under a key no other upload can collide with
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind uploaded file: %w", err)
+	}
+	doc.FilePath = objectKey(documentID, contentHash)
+	upload, err := s.uploadFile(ctx, doc.FilePath, src, size, fileType.MimeType)
+	if err != nil {
+		s.discardUpload(doc)
+		return nil, err
+	}
+	if upload.SHA256 != contentHash {
+		s.discardUpload(doc)
+		return nil, fmt.Errorf("uploaded file changed while it was being stored")
 	}
 
 	// Insert document into database
+	if err := s.repo.CreateDocument(ctx, doc); err != nil {
+		s.discardUpload(doc)
+		return nil, fmt.Errorf("failed to create document record: %w", err)
+	}
+
+	// Queue document for processing
+	return doc, s.enqueueOrFail(ctx, documentID)
+}
+
+// discardUpload deletes the stored file of an upload whose document was not
+// created, since the orphan sweeper skips the keys of documents that exist.
+// A concurrent upload of the same file under the same ID stores it under the
+// same key, so the file is kept if that upload created the document.
+func (s *ProcessingService) discardUpload(doc *models.Document) {
+	// The client may be gone; the file is removed all the same
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if existing, err := s.repo.GetDocumentByID(ctx, doc.ID); err == nil && existing.FilePath == doc.FilePath {
+		return
+	}
+	if err := s.store.Delete(ctx, doc.FilePath); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		s.logger.Warn("Failed to delete stored upload", "filePath", doc.FilePath, "error", err)
+	}
+}
+
+// objectKey is the storage key of an upload: the document ID keeps uploads
+// of different documents apart, the hash keeps a re-upload from overwriting
+// the file an earlier run was processed from.
+func objectKey(documentID, contentHash string) string {
+	return documentsPrefix + documentID + "/" + contentHash
+}
+
+// linkDuplicate creates doc as a copy of the processed results of original,
+// sharing its stored file. If copying fails the document is processed
+// normally instead.
+func (s *ProcessingService) linkDuplicate(ctx context.Context, doc, original *models.Document) error {
+	doc.FilePath = original.FilePath
 	if err := s.repo.CreateDocument(ctx, doc); err != nil {
 		return fmt.Errorf("failed to create document record: %w", err)
 	}
 
-	// Update status to processing
-	if err := s.repo.UpdateDocumentStatus(ctx, documentID, "processing"); err != nil {
+	if err := s.repo.LinkDocumentResults(ctx, doc.ID, original.ID); err != nil {
+		s.logger.Warn("Failed to link duplicate document, processing it instead",
+			"documentId", doc.ID, "duplicateOf", original.ID, "error", err)
+		return s.enqueueOrFail(ctx, doc.ID)
+	}
+
+	doc.Status = "processed"
+	doc.DuplicateOf = &original.ID
+	s.events.Publish(ctx, doc.ID, models.EventProcessed, map[string]interface{}{"duplicateOf": original.ID})
+	s.logger.Info("Linked duplicate document", "documentId", doc.ID, "duplicateOf", original.ID)
+	return nil
+}
+
+// enqueue creates a durable processing job; a JobService worker picks it up.
+func (s *ProcessingService) enqueue(ctx context.Context, documentID string) error {
+	job := &models.ProcessingJob{
+		ID:          uuid.New().String(),
+		DocumentID:  documentID,
+		MaxAttempts: max(s.cfg.Queue.MaxAttempts, 1),
+	}
+
+	if err := s.repo.EnqueueJob(ctx, job); err != nil {
+		return fmt.Errorf("failed to enqueue processing job: %w", err)
+	}
+
+	if err := s.repo.UpdateDocumentStatus(ctx, documentID, "pending"); err != nil {
 		return fmt.Errorf("failed to update document status: %w", err)
 	}
 
-	// Process document in background
-	go func() {
-		if err := s.processDocumentAsync(context.Background(), doc); err != nil {
-			s.logger.Error("Failed to process document", "documentId", documentID, "error", err)
-			s.repo.UpdateDocumentStatus(context.Background(), documentID, "failed")
+	s.events.Publish(ctx, documentID, models.EventQueued, map[string]interface{}{"jobId": job.ID})
+	return nil
+}
+
+// enqueueOrFail queues a pending document that has no job. If that fails the
+// document is marked failed: left pending it would wait for a job that does
+// not exist, and could not be processed again.
+func (s *ProcessingService) enqueueOrFail(ctx context.Context, documentID string) error {
+	err := s.enqueue(ctx, documentID)
+	if err != nil {
+		if err := s.repo.UpdateDocumentStatus(ctx, documentID, "failed"); err != nil {
+			s.logger.Error("Failed to update document status", "documentId", documentID, "error", err)
 		}
-	}()
+	}
+	return err
+}
 
-	return nil
+// runJob processes a document on behalf of a claimed job.
+func (s *ProcessingService) runJob(ctx context.Context, documentID string) error {
+	doc, err := s.repo.GetDocumentByID(ctx, documentID)
+	if err != nil {
+		return fmt.Errorf("failed to get document: %w", err)
+	}
+
+	// Trashed documents are not processed; restoring one queues it again
+	if doc.DeletedAt != nil {
+		s.logger.Info("Skipping trashed document", "documentId", documentID)
+		return nil
+	}
+
+	if err := s.repo.UpdateDocumentStatus(ctx, documentID, "processing"); err != nil {
+		return fmt.Errorf("failed to update document status: %w", err)
+	}
+
+	return s.processDocumentAsync(ctx, doc)
 }
 
 func (s *ProcessingService) processDocumentAsync(ctx context.Context, doc *models.Document) error {
-	// Download file from MinIO
-	fileData, err := s.downloadFile(ctx, doc.FilePath)
+	// Each job gets a private workspace so concurrent documents never see
+	// each other's files
+	workspace, err := os.MkdirTemp("", "document-*")
 	if err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+	defer os.RemoveAll(workspace)
+
+	// Download file from storage
+	s.stage(ctx, doc.ID, models.StageDownloading)
+	sourcePath := filepath.Join(workspace, "source")
+	if err := s.downloadFile(ctx, doc.FilePath, sourcePath); err != nil {
 		return fmt.Errorf("failed to download file: %w", err)
 	}
 
@@ -142,38 +321,67 @@ func (s *ProcessingService) processDocumentAsync(ctx context.Context, doc *model
 	var summary string
 	var metadata []byte
 
+	s.stage(ctx, doc.ID, models.StageExtracting)
 	if s.isImageFile(doc.FileType) {
-		// For images, use the new analyzeImage method
-		analysis, err := s.analyzeImage(ctx, fileData, doc.FileType)
+		// Images are sent to the model whole, so they are read into memory
+		imageData, err := os.ReadFile(sourcePath)
+		if err != nil {
+			return fmt.Errorf("failed to read image: %w", err)
+		}
+
+		// Read the image with the vision model and/or Tesseract
+		analysis, analysisMetadata, err := s.readImage(ctx, imageData, doc.FileType)
 		if err != nil {
 			return fmt.Errorf("failed to analyze image: %w", err)
 		}
 
 		// Extract text content from analysis
-		if textContent, exists := analysis.Metadata["raw_text_content"]; exists {
-			extractedText = textContent
-		} else {
-			extractedText = analysis.Summary
-		}
+		extractedText = analysis.Text()
 
 		summary = analysis.Summary
 
 		// Store only the metadata part, not the full analysis
-		metadata, err = json.Marshal(analysis.Metadata)
+		metadata, err = json.Marshal(analysisMetadata)
 		if err != nil {
 			return fmt.Errorf("failed to marshal analysis metadata: %w", err)
 		}
 	} else {
 		// For other files, use the existing extractText method
-		extractedText, err = s.extractText(ctx, fileData, doc.FileType, doc.Filename)
+		var extractionMetadata map[string]interface{}
+		extractedText, extractionMetadata, err = s.extractText(ctx, doc, sourcePath, workspace)
 		if err != nil {
 			return fmt.Errorf("failed to extract text: %w", err)
 		}
-		summary = extractedText // Use extracted text as summary for non-image files
+
+		if extractionMetadata != nil {
+			metadata, err = json.Marshal(extractionMetadata)
+			if err != nil {
+				return fmt.Errorf("failed to marshal extraction metadata: %w", err)
+			}
+		}
+	}
+
+	// Normalize Persian/Arabic character variants before anything is stored
+	extractedText = persian.Normalize(extractedText)
+	summary = persian.Normalize(summary)
+
+	// Images come with a summary from their analysis; other documents are
+	// summarized by the chat model. A document whose summary fails is still
+	// searchable, so it is stored without one rather than failing the job.
+	if !s.isImageFile(doc.FileType) {
+		s.stage(ctx, doc.ID, models.StageSummarizing)
+		summary, err = s.summarizeDocument(ctx, doc, extractedText)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("failed to summarize document: %w", err)
+			}
+			s.logger.Warn("Failed to summarize document, storing it without a summary", "documentId", doc.ID, "error", err)
+			summary = ""
+		}
 	}
 
 	// Update document content
-	if err := s.repo.UpdateDocumentContent(ctx, doc.ID, extractedText); err != nil {
+	if err := s.repo.UpdateDocumentContent(ctx, doc.ID, extractedText, persian.SearchText(extractedText)); err != nil {
 		return fmt.Errorf("failed to update document content: %w", err)
 	}
 
@@ -194,42 +402,11 @@ func (s *ProcessingService) processDocumentAsync(ctx context.Context, doc *model
 		}
 	}
 
-	// Chunk the text
-	// chunks := s.chunkText(extractedText, 1000, 200) // 1000 chars with 200 overlap
-
-	// Generate embeddings and store chunks
-	// for i, chunkContent := range chunks {
-	// 	chunkID := uuid.New().String()
-
-	// 	// Generate embedding
-	// 	embeddings, err := s.openai.GenerateEmbeddings(ctx, []string{chunkContent})
-	// 	if err != nil {
-	// 		return fmt.Errorf("failed to generate embedding for chunk %d: %w", i, err)
-	// 	}
-
-	// 	// Create chunk metadata
-	// 	metadata := map[string]interface{}{
-	// 		"chunk_index": i,
-	// 		"token_count": len(strings.Fields(chunkContent)),
-	// 		"created_at":  time.Now(),
-	// 	}
-	// 	metadataJSON, _ := json.Marshal(metadata)
-
-	// 	// Create document chunk
-	// 	chunk := &models.DocumentChunk{
-	// 		ID:         chunkID,
-	// 		DocumentID: doc.ID,
-	// 		ChunkIndex: i,
-	// 		Content:    chunkContent,
-	// 		TokenCount: &[]int{len(strings.Fields(chunkContent))}[0],
-	// 		Embedding:  embeddings[0],
-	// 		Metadata:   metadataJSON,
-	// 	}
-
-	// 	if err := s.repo.CreateDocumentChunk(ctx, chunk); err != nil {
-	// 		return fmt.Errorf("failed to create document chunk: %w", err)
-	// 	}
-	// }
+	// Chunk the text, generate embeddings and store chunks
+	s.stage(ctx, doc.ID, models.StageEmbedding)
+	if err := s.embedDocument(ctx, doc.ID, extractedText); err != nil {
+		return fmt.Errorf("failed to embed document: %w", err)
+	}
 
 	// Update document status to processed
 	if err := s.repo.UpdateDocumentStatus(ctx, doc.ID, "processed"); err != nil {
@@ -240,146 +417,337 @@ func (s *ProcessingService) processDocumentAsync(ctx context.Context, doc *model
 	return nil
 }
 
-func (s *ProcessingService) downloadFile(ctx context.Context, filePath string) ([]byte, error) {
-	reader, err := s.minio.GetObject(ctx, filePath)
+// stage reports that processing of a document moved on to another stage.
+func (s *ProcessingService) stage(ctx context.Context, documentID, stage string) {
+	s.events.Publish(ctx, documentID, models.EventStage, map[string]interface{}{"stage": stage})
+}
+
+// downloadFile streams a stored object into a local file.
+func (s *ProcessingService) downloadFile(ctx context.Context, objectPath, localPath string) error {
+	reader, err := s.store.Get(ctx, objectPath)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer reader.Close()
 
-	return io.ReadAll(reader)
+	f, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, reader); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func (s *ProcessingService) extractText(ctx context.Context, fileData []byte, fileType, filename string) (string, error) {
-	switch strings.ToLower(fileType) {
-	case "pdf":
-		return s.extractTextFromPDF(ctx, fileData, filename)
-	case "jpeg", "jpg", "png", "gif", "bmp", "webp", "tiff":
-		return s.extractTextFromImage(ctx, fileData, fileType)
+// extractText returns the text of the document stored at path plus optional
+// metadata about how it was extracted. Intermediate files go to workspace.
+func (s *ProcessingService) extractText(ctx context.Context, doc *models.Document, path, workspace string) (string, map[string]interface{}, error) {
+	ft, ok := filetypes.FromName(doc.FileType)
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported file type: %s", doc.FileType)
+	}
+
+	switch ft.Kind {
+	case filetypes.KindPDF:
+		return s.extractTextFromPDF(ctx, doc.ID, path, workspace)
+	case filetypes.KindImage:
+		imageData, err := os.ReadFile(path)
+		if err != nil {
+			return "", nil, err
+		}
+		result, err := s.recognize(ctx, imageData, func() (string, error) {
+			return s.extractTextFromImage(ctx, imageData, doc.FileType)
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		return result.Text, nil, nil
+	case filetypes.KindOffice:
+		return s.extractTextFromOffice(ctx, doc.ID, path, ft.Name)
+	case filetypes.KindText:
+		return s.extractTextFromTextFile(path, ft.Name)
 	default:
-		return "", fmt.Errorf("unsupported file type: %s", fileType)
+		return "", nil, fmt.Errorf("unsupported file type: %s", doc.FileType)
+	}
+}
+
+// extractTextFromPDF extracts the PDF at pdfPath; rendered pages are written
+// to the job's workspace.
+func (s *ProcessingService) extractTextFromPDF(ctx context.Context, documentID, pdfPath, workspace string) (string, map[string]interface{}, error) {
+	// Use the embedded text layer where it is good enough; digitally
+	// generated PDFs then never reach the vision model
+	var pages []pageSource
+	if s.cfg.PDF.TextLayer {
+		textLayer, err := pdf.ExtractTextLayer(ctx, pdfPath)
+		if err != nil {
+			s.logger.Warn("Failed to read PDF text layer, falling back to OCR", "documentId", documentID, "error", err)
+		}
+		for _, text := range textLayer {
+			var page pageSource
+			if s.textLayerSufficient(text) {
+				page.Text = text
+			}
+			pages = append(pages, page)
+		}
+	}
+
+	needsOCR := len(pages) == 0
+	for _, page := range pages {
+		if page.Text == "" {
+			needsOCR = true
+		}
+	}
+
+	if needsOCR {
+		// Render pages to images, in page order
+		images, err := s.rasterizer.Rasterize(ctx, pdfPath, workspace)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to convert PDF to images: %w", err)
+		}
+
+		for i, image := range images {
+			if i >= len(pages) {
+				pages = append(pages, pageSource{})
+			}
+			if pages[i].Text == "" {
+				pages[i].ImagePath = image
+			}
+		}
 	}
+
+	return s.extractPages(ctx, documentID, pages)
 }
 
-func (s *ProcessingService) extractTextFromPDF(ctx context.Context, pdfData []byte, filename string) (string, error) {
-	// Create temporary file
-	tmpFile, err := os.CreateTemp("", "pdf_*.pdf")
+func (s *ProcessingService) extractTextFromTextFile(path, format string) (string, map[string]interface{}, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	defer os.Remove(tmpFile.Name())
+	defer f.Close()
 
-	if _, err := tmpFile.Write(pdfData); err != nil {
-		return "", err
+	doc, err := textdoc.Extract(f, format)
+	if err != nil {
+		return "", nil, err
+	}
+	if doc.Text == "" {
+		return "", nil, fmt.Errorf("no text found in %s document", format)
 	}
-	tmpFile.Close()
 
-	// Convert PDF to images using ImageMagick
-	outputDir := filepath.Dir(tmpFile.Name())
-	outputPattern := filepath.Join(outputDir, "page_%03d.png")
+	metadata := map[string]interface{}{"charset": doc.Charset}
+	if doc.Title != "" {
+		metadata["title"] = doc.Title
+	}
 
-	cmd := exec.CommandContext(ctx, "convert", tmpFile.Name(), outputPattern)
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to convert PDF to images: %w", err)
+	return doc.Text, metadata, nil
+}
+
+// textLayerSufficient decides whether a page's embedded text can be used
+// instead of OCR: it must be long enough and not garbled by a broken font
+// encoding.
+func (s *ProcessingService) textLayerSufficient(text string) bool {
+	chars, quality := pdf.TextQuality(text)
+	return chars >= s.cfg.PDF.TextLayerMinChars && quality >= s.cfg.PDF.TextLayerMinQuality
+}
+
+func (s *ProcessingService) extractTextFromImage(ctx context.Context, imageData []byte, fileType string) (string, error) {
+	return s.vision.ExtractTextFromImage(ctx, imageInput(imageData, fileType))
+}
+
+func (s *ProcessingService) analyzeImage(ctx context.Context, imageData []byte, fileType string) (*provider.ImageAnalysis, error) {
+	return s.vision.AnalyzeImage(ctx, imageInput(imageData, fileType))
+}
+
+// imageInput describes an uploaded image for the vision provider.
+func imageInput(imageData []byte, fileType string) provider.ImageInput {
+	return provider.ImageInput{Data: imageData, MimeType: imageMimeType(fileType), DocumentType: fileType}
+}
+
+func imageMimeType(fileType string) string {
+	if ft, ok := filetypes.FromName(fileType); ok {
+		return ft.MimeType
 	}
+	return fmt.Sprintf("image/%s", strings.ToLower(fileType))
+}
 
-	// Find generated images
-	images, err := filepath.Glob(filepath.Join(outputDir, "page_*.png"))
+func (s *ProcessingService) isImageFile(fileType string) bool {
+	ft, ok := filetypes.FromName(fileType)
+	return ok && ft.Kind == filetypes.KindImage
+}
+
+func (s *ProcessingService) embedDocument(ctx context.Context, documentID, text string) error {
+	chunks := chunkText(text, s.cfg.Embedding.ChunkSize, s.cfg.Embedding.ChunkOverlap)
+
+	pages, err := s.repo.GetDocumentPages(ctx, documentID)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to get document pages: %w", err)
 	}
+	spans := pageSpans(text, pages)
 
-	// Clean up images after processing
-	defer func() {
-		for _, img := range images {
-			os.Remove(img)
-		}
-	}()
+	batchSize := s.cfg.Embedding.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(chunks)
+	}
 
-	var extractedTexts []string
+	documentChunks := make([]models.DocumentChunk, 0, len(chunks))
+	for start := 0; start < len(chunks); start += batchSize {
+		end := min(start+batchSize, len(chunks))
 
-	// Extract text from each image
-	for _, imagePath := range images {
-		imageData, err := os.ReadFile(imagePath)
-		if err != nil {
-			continue
+		inputs := make([]string, 0, end-start)
+		for _, chunk := range chunks[start:end] {
+			inputs = append(inputs, chunk.Content)
 		}
 
-		text, err := s.openai.ExtractTextFromImage(ctx, imageData, "image/png")
+		embeddings, err := s.embedder.GenerateEmbeddings(ctx, inputs)
 		if err != nil {
-			s.logger.Warn("Failed to extract text from PDF page", "error", err)
-			continue
+			return fmt.Errorf("failed to generate embeddings for chunks %d-%d: %w", start, end-1, err)
 		}
 
-		if text != "" {
-			extractedTexts = append(extractedTexts, text)
+		for i, embedding := range embeddings {
+			chunk := chunks[start+i]
+			tokenCount := len(strings.Fields(chunk.Content))
+
+			metadata := map[string]interface{}{
+				"start_offset": chunk.Start,
+				"end_offset":   chunk.End,
+			}
+			if numbers := chunkPages(spans, chunk); len(numbers) > 0 {
+				metadata["page_numbers"] = numbers
+			}
+
+			documentChunks = append(documentChunks, models.DocumentChunk{
+				ID:         uuid.New().String(),
+				DocumentID: documentID,
+				ChunkIndex: start + i,
+				Content:    chunk.Content,
+				SearchText: persian.SearchText(chunk.Content),
+				TokenCount: &tokenCount,
+				Embedding:  embedding,
+				Metadata:   metadata,
+			})
 		}
 	}
 
-	return strings.Join(extractedTexts, "\n\n"), nil
-}
-
-func (s *ProcessingService) extractTextFromImage(ctx context.Context, imageData []byte, fileType string) (string, error) {
-	mimeType := fmt.Sprintf("image/%s", strings.ToLower(fileType))
-	if fileType == "jpg" {
-		mimeType = "image/jpeg"
+	if err := s.repo.ReplaceDocumentChunks(ctx, documentID, documentChunks); err != nil {
+		return fmt.Errorf("failed to store document chunks: %w", err)
 	}
 
-	return s.openai.ExtractTextFromImage(ctx, imageData, mimeType)
+	s.logger.Info("Document chunks embedded", "documentId", documentID, "chunks", len(documentChunks))
+	return nil
 }
 
-func (s *ProcessingService) analyzeImage(ctx context.Context, imageData []byte, fileType string) (*openai.ImageAnalysis, error) {
-	mimeType := fmt.Sprintf("image/%s", strings.ToLower(fileType))
-	if fileType == "jpg" {
-		mimeType = "image/jpeg"
-	}
+// pageSpan is the part of a document's text that came from one page; Start
+// and End are rune offsets into the text.
+type pageSpan struct {
+	Number int
+	Start  int
+	End    int
+}
+
+// pageSpans locates the text of each processed page in the document text,
+// which joins the page texts in order. Pages that cannot be found, e.g.
+// because normalization changed the text at a page boundary, are left out.
+func pageSpans(text string, pages []models.DocumentPage) []pageSpan {
+	var spans []pageSpan
+	cursor, runeCursor := 0, 0
+	for _, page := range pages {
+		if page.Status != models.PageStatusProcessed || page.Content == nil {
+			continue
+		}
+		content := strings.TrimSpace(persian.Normalize(*page.Content))
+		if content == "" {
+			continue
+		}
 
-	return s.openai.AnalyzeImage(ctx, imageData, mimeType)
+		index := strings.Index(text[cursor:], content)
+		if index < 0 {
+			continue
+		}
+		start := runeCursor + utf8.RuneCountInString(text[cursor:cursor+index])
+		end := start + utf8.RuneCountInString(content)
+		spans = append(spans, pageSpan{Number: page.PageNumber, Start: start, End: end})
+		cursor += index + len(content)
+		runeCursor = end
+	}
+	return spans
 }
 
-func (s *ProcessingService) isImageFile(fileType string) bool {
-	imageTypes := []string{"jpeg", "jpg", "png", "gif", "bmp", "webp", "tiff"}
-	for _, imgType := range imageTypes {
-		if strings.ToLower(fileType) == imgType {
-			return true
+// chunkPages returns the numbers of the pages a chunk overlaps.
+func chunkPages(spans []pageSpan, chunk textChunk) []int {
+	var numbers []int
+	for _, span := range spans {
+		if span.Start < chunk.End && chunk.Start < span.End {
+			numbers = append(numbers, span.Number)
 		}
 	}
-	return false
+	return numbers
 }
 
-// func (s *ProcessingService) chunkText(text string, chunkSize, overlap int) []string {
-// 	if len(text) <= chunkSize {
-// 		return []string{text}
-// 	}
+// textChunk is a slice of a document's text; Start and End are rune offsets
+// into the original text.
+type textChunk struct {
+	Content string
+	Start   int
+	End     int
+}
+
+// chunkText splits text into chunks of at most chunkSize runes with overlap
+// runes shared between neighbours, preferring to break on whitespace or
+// sentence punctuation. It works on runes so multi-byte scripts such as
+// Persian are never cut mid-character.
+func chunkText(text string, chunkSize, overlap int) []textChunk {
+	if chunkSize <= 0 {
+		chunkSize = 1000
+	}
+	if overlap < 0 || overlap >= chunkSize {
+		overlap = 0
+	}
 
-// 	var chunks []string
-// 	start := 0
+	runes := []rune(text)
+	var chunks []textChunk
+	start := 0
 
-// 	for start < len(text) {
-// 		end := start + chunkSize
-// 		if end > len(text) {
-// 			end = len(text)
-// 		}
+	for start < len(runes) {
+		end := start + chunkSize
+		if end >= len(runes) {
+			end = len(runes)
+		} else {
+			// Find a good breaking point (space, newline or sentence end)
+			for i := end; i > start+chunkSize/2; i-- {
+				if isChunkBoundary(runes[i-1]) {
+					end = i
+					break
+				}
+			}
+		}
 
-// 		// Find a good breaking point (space or newline)
-// 		if end < len(text) {
-// 			for i := end; i > start+chunkSize/2 && i < len(text); i-- {
-// 				if text[i] == ' ' || text[i] == '\n' || text[i] == '.' {
-// 					end = i + 1
-// 					break
-// 				}
-// 			}
-// 		}
+		if content := strings.TrimSpace(string(runes[start:end])); content != "" {
+			chunks = append(chunks, textChunk{Content: content, Start: start, End: end})
+		}
+
+		if end == len(runes) {
+			break
+		}
+
+		next := end - overlap
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
 
-// 		chunks = append(chunks, strings.TrimSpace(text[start:end]))
-// 		start = end - overlap
-// 		if start < 0 {
-// 			start = 0
-// 		}
-// 	}
+	return chunks
+}
 
-// 	return chunks
-// }
+func isChunkBoundary(r rune) bool {
+	switch r {
+	case '.', '!', '?', '؟', '۔':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
 
 func (s *ProcessingService) GetProcessingStatus(ctx context.Context, documentID string) (*models.StatusResponse, error) {
 	doc, err := s.repo.GetDocumentByID(ctx, documentID)
@@ -387,48 +755,62 @@ func (s *ProcessingService) GetProcessingStatus(ctx context.Context, documentID
 		return nil, fmt.Errorf("failed to get document: %w", err)
 	}
 
-	// chunkCount, err := s.repo.GetDocumentChunkCount(ctx, documentID)
-	// if err != nil {
-	// 	return nil, fmt.Errorf("failed to get chunk count: %w", err)
-	// }
-
-	return &models.StatusResponse{
-		Status: doc.Status,
-		// ChunkCount: chunkCount,
-	}, nil
-}
+	chunkCount, err := s.repo.GetDocumentChunkCount(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chunk count: %w", err)
+	}
 
-func (s *ProcessingService) getFileTypeFromContentType(contentType string) string {
-	contentTypeMap := map[string]string{
-		"application/pdf": "pdf",
-		"image/jpeg":      "jpg",
-		"image/jpg":       "jpg",
-		"image/png":       "png",
-		"image/gif":       "gif",
-		"image/bmp":       "bmp",
-		"image/webp":      "webp",
-		"image/tiff":      "tiff",
+	pageCounts, err := s.repo.GetDocumentPageCounts(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get page counts: %w", err)
 	}
 
-	if fileType, exists := contentTypeMap[contentType]; exists {
-		return fileType
+	job, err := s.repo.GetLatestJobForDocument(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get processing job: %w", err)
 	}
-	return ""
+
+	return &models.StatusResponse{
+		Status:      doc.Status,
+		ChunkCount:  chunkCount,
+		PagesTotal:  pageCounts.Total,
+		PagesDone:   pageCounts.Done,
+		PagesFailed: pageCounts.Failed,
+		Job:         job,
+	}, nil
 }
 
-func (s *ProcessingService) uploadFileToMinIO(ctx context.Context, filePath string, fileData []byte, contentType string) error {
-	// Create a reader from the file data
-	reader := bytes.NewReader(fileData)
+// uploadResult describes a stored upload.
+type uploadResult struct {
+	Size   int64
+	SHA256 string
+}
 
-	// Upload file to MinIO
-	_, err := s.minio.PutObject(ctx, filePath, reader, int64(len(fileData)), minio.PutObjectOptions{
-		ContentType: contentType,
-	})
+// uploadFile streams an upload of the given size to the object store,
+// hashing it on the way.
+func (s *ProcessingService) uploadFile(ctx context.Context, filePath string, reader io.Reader, size int64, contentType string) (*uploadResult, error) {
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(reader, hash)}
 
-	if err != nil {
-		return fmt.Errorf("failed to upload file to MinIO: %w", err)
+	if err := s.store.Put(ctx, filePath, counter, size, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store uploaded file: %w", err)
+	}
+	if counter.n != size {
+		return nil, fmt.Errorf("uploaded %d bytes, expected %d", counter.n, size)
 	}
 
-	s.logger.Info("File uploaded to MinIO", "filePath", filePath, "size", len(fileData))
-	return nil
+	result := &uploadResult{Size: counter.n, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	s.logger.Info("File stored", "filePath", filePath, "size", result.Size, "sha256", result.SHA256)
+	return result, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -3,64 +3,356 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"document-embeddings/internal/config"
 	"document-embeddings/internal/models"
 	"document-embeddings/internal/repository"
+	"document-embeddings/pkg/jsonschema"
 	"document-embeddings/pkg/logger"
-	"document-embeddings/pkg/openai"
+	"document-embeddings/pkg/persian"
+	"document-embeddings/pkg/provider"
 )
 
 type SearchService struct {
-	repo   *repository.Repository
-	openai *openai.Client
-	logger *logger.Logger
+	repo     repository.DocumentStore
+	embedder provider.EmbeddingProvider
+	cfg      *config.Config
+	logger   *logger.Logger
 }
 
-func NewSearchService(repo *repository.Repository, openai *openai.Client, logger *logger.Logger) *SearchService {
+func NewSearchService(repo repository.DocumentStore, embedder provider.EmbeddingProvider, cfg *config.Config, logger *logger.Logger) *SearchService {
 	return &SearchService{
-		repo:   repo,
-		openai: openai,
-		logger: logger,
+		repo:     repo,
+		embedder: embedder,
+		cfg:      cfg,
+		logger:   logger,
 	}
 }
 
-// func (s *SearchService) SearchSimilarDocuments(ctx context.Context, req *models.SearchRequest) (*models.SearchResponse, error) {
-// 	// Generate embedding for query
-// 	embeddings, err := s.openai.GenerateEmbeddings(ctx, []string{req.Query})
-// 	if err != nil {
-// 		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
-// 	}
-
-// 	// Set default limit
-// 	limit := req.Limit
-// 	if limit <= 0 || limit > 100 {
-// 		limit = 20
-// 	}
-
-// 	// Search for similar chunks
-// 	chunks, err := s.repo.SearchSimilarChunks(ctx, embeddings[0], limit, req.UserID)
-// 	if err != nil {
-// 		return nil, fmt.Errorf("failed to search similar chunks: %w", err)
-// 	}
-
-// 	return &models.SearchResponse{
-// 		Results: chunks,
-// 		Total:   len(chunks),
-// 	}, nil
-// }
-
-// func (s *SearchService) GetDocumentChunks(ctx context.Context, documentID string) ([]models.DocumentChunk, error) {
-// 	return s.repo.GetDocumentChunks(ctx, documentID)
-// }
-
-func (s *SearchService) ListDocuments(ctx context.Context, limit, offset string) ([]models.DocumentListItem, error) {
-	return s.repo.ListDocuments(ctx, limit, offset)
+// ErrInvalidSearchRequest marks search errors caused by the caller's input.
+var ErrInvalidSearchRequest = errors.New("invalid search request")
+
+func (s *SearchService) Search(ctx context.Context, req *models.SearchRequest) (*models.SearchResponse, error) {
+	filters, err := parseSearchFilters(req.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.MinScore < -1 || req.MinScore > 1 {
+		return nil, fmt.Errorf("%w: minScore must be between -1 and 1", ErrInvalidSearchRequest)
+	}
+
+	req.Query = persian.Normalize(req.Query)
+	if req.Query == "" {
+		return nil, fmt.Errorf("%w: query is empty", ErrInvalidSearchRequest)
+	}
+
+	// Set default limit
+	limit := req.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = models.SearchModeVector
+	}
+
+	var results []models.SearchResult
+	switch mode {
+	case models.SearchModeVector:
+		results, err = s.vectorSearch(ctx, req.Query, limit, req.MinScore, filters)
+	case models.SearchModeKeyword:
+		results, err = s.keywordSearch(ctx, req.Query, limit, filters)
+	case models.SearchModeHybrid:
+		results, err = s.hybridSearch(ctx, req, limit, filters)
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidSearchRequest, req.Mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SearchResponse{
+		Mode:    mode,
+		Results: results,
+		Total:   len(results),
+	}, nil
 }
 
-func (s *SearchService) GetDocumentsByIDs(ctx context.Context, ids []string) ([]models.Document, error) {
-	return s.repo.GetDocumentsByIDs(ctx, ids)
+func (s *SearchService) vectorSearch(ctx context.Context, query string, limit int, minScore float64, filters *models.SearchFilters) ([]models.SearchResult, error) {
+	// Generate embedding for query
+	embeddings, err := s.embedder.GenerateEmbeddings(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+	}
+
+	// Search for similar chunks
+	results, err := s.repo.SearchSimilarChunks(ctx, embeddings[0], limit, minScore, filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search similar chunks: %w", err)
+	}
+
+	for i := range results {
+		similarity := results[i].Score
+		results[i].Similarity = &similarity
+		results[i].Retrievers = []string{models.SearchModeVector}
+	}
+
+	return results, nil
 }
 
-func (s *SearchService) DeleteDocument(ctx context.Context, documentID string) error {
-	return s.repo.DeleteDocument(ctx, documentID)
+func (s *SearchService) keywordSearch(ctx context.Context, query string, limit int, filters *models.SearchFilters) ([]models.SearchResult, error) {
+	terms := persian.SearchText(query)
+	if terms == "" {
+		return []models.SearchResult{}, nil
+	}
+
+	results, err := s.repo.SearchKeywordChunks(ctx, terms, limit, filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search keyword chunks: %w", err)
+	}
+
+	for i := range results {
+		rank := results[i].Score
+		results[i].KeywordRank = &rank
+		results[i].Retrievers = []string{models.SearchModeKeyword}
+	}
+
+	return results, nil
+}
+
+// hybridSearch runs the vector and keyword retrievers over a wider candidate
+// pool and merges them with weighted reciprocal rank fusion:
+// score = sum(weight / (k + rank)). Rank-based fusion sidesteps the fact that
+// cosine similarity and ts_rank live on unrelated scales.
+func (s *SearchService) hybridSearch(ctx context.Context, req *models.SearchRequest, limit int, filters *models.SearchFilters) ([]models.SearchResult, error) {
+	weights := models.SearchWeights{
+		Vector:  s.cfg.Search.VectorWeight,
+		Keyword: s.cfg.Search.KeywordWeight,
+	}
+	if req.Weights != nil {
+		weights = *req.Weights
+	}
+	if weights.Vector < 0 || weights.Keyword < 0 || weights.Vector+weights.Keyword == 0 {
+		return nil, fmt.Errorf("%w: weights must be non-negative and not both zero", ErrInvalidSearchRequest)
+	}
+
+	candidates := min(limit*4, 200)
+
+	var vectorResults, keywordResults []models.SearchResult
+	var err error
+	if weights.Vector > 0 {
+		vectorResults, err = s.vectorSearch(ctx, req.Query, candidates, req.MinScore, filters)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if weights.Keyword > 0 {
+		keywordResults, err = s.keywordSearch(ctx, req.Query, candidates, filters)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fuseResults(limit, s.cfg.Search.RRFK,
+		rankedList{results: vectorResults, weight: weights.Vector},
+		rankedList{results: keywordResults, weight: weights.Keyword},
+	), nil
+}
+
+type rankedList struct {
+	results []models.SearchResult
+	weight  float64
+}
+
+// fuseResults merges ranked lists with reciprocal rank fusion, keeping the
+// per-retriever scores and recording every retriever that produced a hit.
+func fuseResults(limit, k int, lists ...rankedList) []models.SearchResult {
+	if k <= 0 {
+		k = 60
+	}
+
+	fused := map[string]*models.SearchResult{}
+	var order []string
+
+	for _, list := range lists {
+		for rank, result := range list.results {
+			score := list.weight / float64(k+rank+1)
+
+			existing, ok := fused[result.ChunkID]
+			if !ok {
+				merged := result
+				merged.Score = score
+				merged.Retrievers = append([]string(nil), result.Retrievers...)
+				fused[result.ChunkID] = &merged
+				order = append(order, result.ChunkID)
+				continue
+			}
+
+			existing.Score += score
+			existing.Retrievers = append(existing.Retrievers, result.Retrievers...)
+			if result.Similarity != nil {
+				existing.Similarity = result.Similarity
+			}
+			if result.KeywordRank != nil {
+				existing.KeywordRank = result.KeywordRank
+			}
+		}
+	}
+
+	results := make([]models.SearchResult, 0, len(order))
+	for _, id := range order {
+		results = append(results, *fused[id])
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// parseSearchFilters validates the free-form filters of a search request.
+// Supported keys are documentIds, fileType (string or list), createdAfter,
+// createdBefore (RFC 3339), metadata (object matched with JSONB
+// containment) and image (fields of provider.ImageMetadata, see
+// parseImageFilters).
+func parseSearchFilters(raw map[string]interface{}) (*models.SearchFilters, error) {
+	filters := &models.SearchFilters{}
+
+	for key, value := range raw {
+		var err error
+		switch key {
+		case "documentIds":
+			filters.DocumentIDs, err = stringList(value)
+		case "fileType", "fileTypes":
+			filters.FileTypes, err = stringList(value)
+			for i, fileType := range filters.FileTypes {
+				filters.FileTypes[i] = strings.ToLower(fileType)
+			}
+		case "createdAfter":
+			filters.CreatedAfter, err = timestamp(value)
+		case "createdBefore":
+			filters.CreatedBefore, err = timestamp(value)
+		case "metadata":
+			metadata, ok := value.(map[string]interface{})
+			if !ok {
+				err = fmt.Errorf("expected an object")
+			}
+			filters.Metadata = metadata
+		case "image":
+			filters.MetadataFields, err = parseImageFilters(value)
+		default:
+			err = fmt.Errorf("unknown filter")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: filter %q: %v", ErrInvalidSearchRequest, key, err)
+		}
+	}
+
+	return filters, nil
+}
+
+// parseImageFilters validates filters on the image analysis stored in the
+// metadata of images. Each key must be a property of
+// provider.ImageMetadataSchema, with a string or a list of strings: a text
+// property matches any of them, a list property must contain all of them.
+// Values are compared case-insensitively.
+func parseImageFilters(value interface{}) ([]models.MetadataFieldFilter, error) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object")
+	}
+
+	fields := make([]string, 0, len(object))
+	for field := range object {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	filters := make([]models.MetadataFieldFilter, 0, len(fields))
+	for _, field := range fields {
+		property, ok := provider.ImageMetadataSchema.Properties[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(provider.ImageMetadataSchema.Required, ", "))
+		}
+
+		values, err := stringList(object[field])
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", field, err)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("field %q: expected at least one value", field)
+		}
+		for i, v := range values {
+			values[i] = strings.ToLower(v)
+		}
+
+		filters = append(filters, models.MetadataFieldFilter{
+			Field:  field,
+			List:   property.Type == jsonschema.TypeArray,
+			Values: values,
+		})
+	}
+	return filters, nil
+}
+
+func stringList(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of strings")
+			}
+			list = append(list, str)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("expected a string or a list of strings")
+	}
+}
+
+func timestamp(value interface{}) (*time.Time, error) {
+	str, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected an RFC 3339 timestamp")
+	}
+
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return nil, fmt.Errorf("expected an RFC 3339 timestamp")
+	}
+	return &t, nil
+}
+
+func (s *SearchService) GetDocumentChunks(ctx context.Context, documentID string) ([]models.DocumentChunk, error) {
+	return s.repo.GetDocumentChunks(ctx, documentID)
+}
+
+// ListDocuments lists processed documents. rawFilters takes the same filters
+// as a search request.
+func (s *SearchService) ListDocuments(ctx context.Context, limit, offset string, rawFilters map[string]interface{}) ([]models.DocumentListItem, error) {
+	filters, err := parseSearchFilters(rawFilters)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.ListDocuments(ctx, limit, offset, filters)
+}
+
+func (s *SearchService) GetDocumentsByIDs(ctx context.Context, ids []string) ([]models.Document, error) {
+	return s.repo.GetDocumentsByIDs(ctx, ids)
 }
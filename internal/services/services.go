@@ -5,18 +5,34 @@ import (
 	"document-embeddings/internal/config"
 	"document-embeddings/internal/repository"
 	"document-embeddings/pkg/logger"
-	minioClient "document-embeddings/pkg/minio"
-	"document-embeddings/pkg/openai"
+	"document-embeddings/pkg/ocr"
+	"document-embeddings/pkg/pdf"
+	"document-embeddings/pkg/provider"
+	"document-embeddings/pkg/storage"
 )
 
 type Services struct {
 	Processing *ProcessingService
 	Search     *SearchService
+	Ask        *AskService
+	Jobs       *JobService
+	Documents  *DocumentService
+	Events     *EventService
 }
 
-func New(repo *repository.Repository, minio *minioClient.Client, openai *openai.Client, cfg *config.Config, logger *logger.Logger) *Services {
+func New(repo repository.DocumentStore, store storage.ObjectStore, embedder provider.EmbeddingProvider, vision provider.VisionProvider, chat provider.ChatProvider, ocrEngine ocr.Engine, rasterizer pdf.Rasterizer, cfg *config.Config, logger *logger.Logger) *Services {
+	events := NewEventService(repo, cfg, logger)
+
+	processing := NewProcessingService(repo, store, embedder, vision, chat, ocrEngine, rasterizer, events, cfg, logger)
+
+	search := NewSearchService(repo, embedder, cfg, logger)
+
 	return &Services{
-		Processing: NewProcessingService(repo, minio, openai, cfg, logger),
-		Search:     NewSearchService(repo, openai, logger),
+		Processing: processing,
+		Search:     search,
+		Ask:        NewAskService(search, chat, cfg, logger),
+		Jobs:       NewJobService(repo, processing, events, cfg, logger),
+		Documents:  NewDocumentService(repo, store, processing, cfg, logger),
+		Events:     events,
 	}
 }
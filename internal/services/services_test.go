@@ -0,0 +1,1268 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"document-embeddings/internal/config"
+	"document-embeddings/internal/models"
+	"document-embeddings/internal/repository"
+	"document-embeddings/pkg/logger"
+	"document-embeddings/pkg/ocr"
+	"document-embeddings/pkg/openai"
+	"document-embeddings/pkg/openai/openaitest"
+	"document-embeddings/pkg/persian"
+	"document-embeddings/pkg/storage"
+)
+
+const embeddingDimensions = 16
+
+// testEnv wires the services to in-memory stores and a stand-in for the
+// OpenAI API.
+type testEnv struct {
+	svc    *Services
+	repo   *repository.MemoryStore
+	store  *storage.MemoryStore
+	openai *openaitest.Server
+	ocr    *fakeOCR
+	cfg    *config.Config
+}
+
+// fakeOCR stands in for Tesseract, reading every image as result.
+type fakeOCR struct {
+	mu     sync.Mutex
+	result ocr.Result
+	err    error
+}
+
+func (f *fakeOCR) Recognize(ctx context.Context, imageData []byte) (*ocr.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	result := f.result
+	return &result, nil
+}
+
+func newTestEnv(t *testing.T, configure ...func(cfg *config.Config)) *testEnv {
+	t.Helper()
+
+	env := &testEnv{
+		repo:   repository.NewMemoryStore(),
+		store:  storage.NewMemoryStore(),
+		openai: openaitest.NewServer(embeddingDimensions),
+		ocr:    &fakeOCR{},
+	}
+	t.Cleanup(env.openai.Close)
+
+	env.cfg = &config.Config{
+		OpenAI:    env.openai.Config(),
+		Embedding: config.EmbeddingConfig{ChunkSize: 200, ChunkOverlap: 20, BatchSize: 8},
+		Search:    config.SearchConfig{RRFK: 60, VectorWeight: 1, KeywordWeight: 1},
+		Queue: config.QueueConfig{
+			Workers:        1,
+			PollInterval:   10 * time.Millisecond,
+			LeaseDuration:  time.Minute,
+			MaxAttempts:    2,
+			RetryBaseDelay: 10 * time.Millisecond,
+			RetryMaxDelay:  20 * time.Millisecond,
+		},
+		OCR:     config.OCRConfig{PageConcurrency: 1},
+		Storage: config.StorageConfig{TrashRetention: time.Hour},
+	}
+	for _, fn := range configure {
+		fn(env.cfg)
+	}
+
+	client := openai.New(env.cfg.OpenAI, nil)
+	env.svc = New(env.repo, env.store, client, client, client, env.ocr, nil, env.cfg, logger.New("error"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	env.svc.Jobs.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		env.svc.Jobs.Wait()
+	})
+
+	return env
+}
+
+// fileHeader builds the multipart file header an upload of data arrives as.
+func fileHeader(t *testing.T, filename, contentType string, data []byte) *multipart.FileHeader {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["file"][0]
+}
+
+func (env *testEnv) upload(t *testing.T, documentID, filename, text, onDuplicate string) (*models.Document, error) {
+	t.Helper()
+	file := fileHeader(t, filename, "text/plain", []byte(text))
+	return env.svc.Processing.ProcessDocumentWithFile(context.Background(), documentID, file, onDuplicate)
+}
+
+// waitForStatus waits for the document to reach status, failing the test if
+// it does not within a few seconds.
+func (env *testEnv) waitForStatus(t *testing.T, documentID, status string) *models.StatusResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := env.svc.Processing.GetProcessingStatus(context.Background(), documentID)
+		if err != nil {
+			t.Fatalf("GetProcessingStatus: %v", err)
+		}
+		if resp.Status == status && resp.Job != nil && resp.Job.Status != models.JobStatusQueued && resp.Job.Status != models.JobStatusRunning {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("document %s is %q, want %q (job %+v)", documentID, resp.Status, status, resp.Job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const reportText = "Quarterly report. Revenue grew in every region and the invoice backlog was cleared before the audit."
+
+func TestDocumentLifecycle(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	doc, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if doc.Status != "pending" || doc.FileType != "txt" {
+		t.Fatalf("uploaded document is %q/%q, want pending/txt", doc.Status, doc.FileType)
+	}
+
+	sum := sha256.Sum256([]byte(reportText))
+	if want := objectKey("doc-1", hex.EncodeToString(sum[:])); doc.FilePath != want {
+		t.Fatalf("file path = %q, want %q", doc.FilePath, want)
+	}
+	if _, err := env.store.Stat(ctx, doc.FilePath); err != nil {
+		t.Fatalf("uploaded file not stored: %v", err)
+	}
+
+	status := env.waitForStatus(t, "doc-1", "processed")
+	if status.ChunkCount == 0 {
+		t.Fatal("processed document has no chunks")
+	}
+	if status.Job.Status != models.JobStatusCompleted {
+		t.Fatalf("job is %q, want completed", status.Job.Status)
+	}
+
+	stored, err := env.repo.GetDocumentByID(ctx, "doc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Content == nil || *stored.Content != reportText {
+		t.Fatalf("content = %v, want the uploaded text", stored.Content)
+	}
+
+	documents, err := env.svc.Search.ListDocuments(ctx, "10", "0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 1 || documents[0].ID != "doc-1" {
+		t.Fatalf("ListDocuments = %+v, want doc-1", documents)
+	}
+
+	for _, mode := range []string{models.SearchModeVector, models.SearchModeKeyword, models.SearchModeHybrid} {
+		resp, err := env.svc.Search.Search(ctx, &models.SearchRequest{Query: "invoice backlog", Mode: mode})
+		if err != nil {
+			t.Fatalf("%s search: %v", mode, err)
+		}
+		if resp.Total == 0 || resp.Results[0].DocumentID != "doc-1" {
+			t.Fatalf("%s search returned %+v, want doc-1", mode, resp.Results)
+		}
+	}
+
+	// Trashed documents disappear from listings and search until restored
+	if err := env.svc.Documents.TrashDocument(ctx, "doc-1"); err != nil {
+		t.Fatalf("TrashDocument: %v", err)
+	}
+	documents, _ = env.svc.Search.ListDocuments(ctx, "10", "0", nil)
+	if len(documents) != 0 {
+		t.Fatalf("trashed document still listed: %+v", documents)
+	}
+	resp, err := env.svc.Search.Search(ctx, &models.SearchRequest{Query: "invoice", Mode: models.SearchModeKeyword})
+	if err != nil || resp.Total != 0 {
+		t.Fatalf("search after trash = %+v, %v; want no results", resp, err)
+	}
+	if err := env.svc.Processing.ProcessDocument(ctx, "doc-1"); err == nil {
+		t.Fatal("reprocessing a trashed document succeeded")
+	}
+
+	trash, err := env.svc.Documents.ListTrash(ctx, 10, 0)
+	if err != nil || len(trash) != 1 {
+		t.Fatalf("ListTrash = %+v, %v; want doc-1", trash, err)
+	}
+	if got := trash[0].PurgeAt.Sub(trash[0].DeletedAt); got != time.Hour {
+		t.Fatalf("purge is %v after deletion, want the retention of 1h", got)
+	}
+
+	if _, err := env.svc.Documents.RestoreDocument(ctx, "doc-1"); err != nil {
+		t.Fatalf("RestoreDocument: %v", err)
+	}
+	documents, _ = env.svc.Search.ListDocuments(ctx, "10", "0", nil)
+	if len(documents) != 1 {
+		t.Fatalf("restored document not listed: %+v", documents)
+	}
+
+	// A permanent delete removes the rows and the stored file
+	if err := env.svc.Documents.DeleteDocument(ctx, "doc-1"); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if _, err := env.repo.GetDocumentByID(ctx, "doc-1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("GetDocumentByID after delete = %v, want ErrNotFound", err)
+	}
+	if count, _ := env.repo.GetDocumentChunkCount(ctx, "doc-1"); count != 0 {
+		t.Fatalf("%d chunks left after delete", count)
+	}
+	if _, err := env.store.Stat(ctx, doc.FilePath); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("stored file after delete: %v, want ErrNotFound", err)
+	}
+	if err := env.svc.Documents.DeleteDocument(ctx, "doc-1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("second delete = %v, want ErrNotFound", err)
+	}
+}
+
+// pngImage returns a small blank PNG.
+func pngImage(t *testing.T) []byte {
+	t.Helper()
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	return data.Bytes()
+}
+
+func TestImageUploadIsReadByVisionModel(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	env.openai.Provider.ImageText = func([]byte) string { return "Invoice 42 due in March" }
+
+	file := fileHeader(t, "scan.png", "image/png", pngImage(t))
+	if _, err := env.svc.Processing.ProcessDocumentWithFile(ctx, "scan", file, models.DuplicatePolicyAsk); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	env.waitForStatus(t, "scan", "processed")
+
+	doc, err := env.repo.GetDocumentByID(ctx, "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.FileType != "png" || doc.Content == nil || *doc.Content != "Invoice 42 due in March" {
+		t.Fatalf("document = %s with content %v, want png with the image text", doc.FileType, doc.Content)
+	}
+
+	if models := env.openai.Requests(openaitest.ChatPath); len(models) != 1 || models[0] != env.cfg.OpenAI.VisionModel {
+		t.Fatalf("vision requests used models %v, want one with %s", models, env.cfg.OpenAI.VisionModel)
+	}
+	for _, model := range env.openai.Requests(openaitest.EmbeddingsPath) {
+		if model != env.cfg.OpenAI.Model {
+			t.Fatalf("embedding request used model %q, want %q", model, env.cfg.OpenAI.Model)
+		}
+	}
+}
+
+func TestImageMetadataIsStoredTypedAndFilterable(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	env.openai.Reply(`{"summary": "A receipt", "raw_text_content": "Total 12", "metadata": {"image_type": "Receipt", "colors": ["White", "Beige"], "objects_detected": ["receipt", "pen"], "mood": "", "quality": "Sharp"}}`)
+
+	file := fileHeader(t, "receipt.png", "image/png", pngImage(t))
+	if _, err := env.svc.Processing.ProcessDocumentWithFile(ctx, "receipt", file, models.DuplicatePolicyAsk); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	env.waitForStatus(t, "receipt", "processed")
+
+	doc, err := env.repo.GetDocumentByID(ctx, "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"image_type":        "Receipt",
+		"colors":            []interface{}{"White", "Beige"},
+		"objects_detected":  []interface{}{"receipt", "pen"},
+		"quality":           "Sharp",
+		"extraction_method": "vision_ocr",
+	}
+	if got := doc.Metadata; !reflect.DeepEqual(got, want) {
+		t.Fatalf("metadata = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name  string
+		image map[string]interface{}
+		want  int
+	}{
+		{"text field, any value", map[string]interface{}{"image_type": []interface{}{"invoice", "RECEIPT"}}, 1},
+		{"list field, all values", map[string]interface{}{"colors": []interface{}{"white", "beige"}}, 1},
+		{"list field, missing value", map[string]interface{}{"colors": []interface{}{"white", "red"}}, 0},
+		{"several fields", map[string]interface{}{"objects_detected": "Pen", "quality": "sharp"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := map[string]interface{}{"image": tt.image}
+
+			documents, err := env.svc.Search.ListDocuments(ctx, "10", "0", filters)
+			if err != nil {
+				t.Fatalf("ListDocuments: %v", err)
+			}
+			if len(documents) != tt.want {
+				t.Fatalf("ListDocuments returned %d documents, want %d", len(documents), tt.want)
+			}
+
+			resp, err := env.svc.Search.Search(ctx, &models.SearchRequest{Query: "Total 12", Limit: 5, Filters: filters})
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if len(resp.Results) != tt.want {
+				t.Fatalf("Search returned %d results, want %d", len(resp.Results), tt.want)
+			}
+		})
+	}
+
+	_, err = env.svc.Search.ListDocuments(ctx, "10", "0", map[string]interface{}{"image": map[string]interface{}{"colour": "white"}})
+	if !errors.Is(err, ErrInvalidSearchRequest) {
+		t.Fatalf("filter on an unknown field = %v, want ErrInvalidSearchRequest", err)
+	}
+}
+
+func TestLongDocumentsAreSummarizedInParts(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Summary = config.SummaryConfig{Enabled: true, MaxWords: 10, PageMaxWords: 5, PartChars: 60, Concurrency: 2}
+	})
+	ctx := context.Background()
+
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+
+	// Two parts are summarized, then their summaries combined
+	requests := env.openai.Requests(openaitest.ChatPath)
+	if len(requests) != 3 {
+		t.Fatalf("%d chat requests, want 3", len(requests))
+	}
+	for _, model := range requests {
+		if model != env.cfg.OpenAI.ChatModel {
+			t.Fatalf("summary request used model %q, want %q", model, env.cfg.OpenAI.ChatModel)
+		}
+	}
+
+	doc, err := env.repo.GetDocumentByID(ctx, "doc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Summary == nil || *doc.Summary == "" || *doc.Summary == reportText {
+		t.Fatalf("summary = %v, want a summary of the text", doc.Summary)
+	}
+}
+
+func TestPageSummariesAreStoredAndReused(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Summary = config.SummaryConfig{Enabled: true, MaxWords: 10, PageMaxWords: 5, PartChars: 1000, Concurrency: 2}
+	})
+	ctx := context.Background()
+
+	doc := &models.Document{ID: "doc-1", Filename: "report.pdf", FileType: "pdf", Status: "processing"}
+	if err := env.repo.CreateDocument(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.repo.InitDocumentPages(ctx, doc.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	pageErr := "page was not rendered"
+	for i, text := range []string{"Revenue grew in every region.", "", "The invoice backlog was cleared."} {
+		page := &models.DocumentPage{DocumentID: doc.ID, PageNumber: i + 1, Status: models.PageStatusProcessed, Content: &text}
+		if text == "" {
+			page.Status, page.Content, page.Error = models.PageStatusFailed, nil, &pageErr
+		}
+		if err := env.repo.UpdateDocumentPage(ctx, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	processing := env.svc.Processing
+	summary, err := processing.summarizeDocument(ctx, doc, "Revenue grew in every region.\n\nThe invoice backlog was cleared.")
+	if err != nil || summary == "" {
+		t.Fatalf("summarizeDocument = %q, %v", summary, err)
+	}
+	if n := len(env.openai.Requests(openaitest.ChatPath)); n != 3 {
+		t.Fatalf("%d chat requests, want one per processed page and one to combine them", n)
+	}
+
+	pages, err := env.repo.GetDocumentPages(ctx, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range pages {
+		if hasSummary := page.Summary != nil; hasSummary != (page.Status == models.PageStatusProcessed) {
+			t.Fatalf("page %d (%s) has summary %v", page.PageNumber, page.Status, page.Summary)
+		}
+	}
+
+	// A second run only combines the stored page summaries
+	if _, err := processing.summarizeDocument(ctx, doc, "Revenue grew in every region."); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(env.openai.Requests(openaitest.ChatPath)); n != 4 {
+		t.Fatalf("%d chat requests after the second run, want 4", n)
+	}
+}
+
+func TestSinglePageSummariesAreWrittenToTheDocumentLength(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Summary = config.SummaryConfig{Enabled: true, MaxWords: 10, PageMaxWords: 5, PartChars: 1000, Concurrency: 1}
+	})
+	ctx := context.Background()
+
+	// Only one of the pages has text
+	doc := &models.Document{ID: "doc-1", Filename: "report.pdf", FileType: "pdf", Status: "processing"}
+	if err := env.repo.CreateDocument(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.repo.InitDocumentPages(ctx, doc.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	text := "Revenue grew in every region."
+	if err := env.repo.UpdateDocumentPage(ctx, &models.DocumentPage{DocumentID: doc.ID, PageNumber: 1, Status: models.PageStatusProcessed, Content: &text}); err != nil {
+		t.Fatal(err)
+	}
+
+	env.openai.Reply("Revenue grew.", "Revenue grew in every region this year.")
+	summary, err := env.svc.Processing.summarizeDocument(ctx, doc, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != "Revenue grew in every region this year." {
+		t.Fatalf("summary = %q, want the page summary rewritten to the document length", summary)
+	}
+
+	bodies := env.openai.Bodies(openaitest.ChatPath)
+	if len(bodies) != 2 {
+		t.Fatalf("%d chat requests, want one for the page and one for the document", len(bodies))
+	}
+	if body := string(bodies[1]); !strings.Contains(body, "Revenue grew.") || strings.Contains(body, "Page 1") {
+		t.Fatalf("document summary request = %s, want the unlabelled page summary", body)
+	}
+}
+
+// failingPageSummaries is a store that cannot store the summary of some
+// pages.
+type failingPageSummaries struct {
+	repository.DocumentStore
+	pages map[int]bool
+}
+
+func (f *failingPageSummaries) UpdateDocumentPageSummary(ctx context.Context, documentID string, pageNumber int, summary string) error {
+	if f.pages[pageNumber] {
+		return errors.New("update failed")
+	}
+	return f.DocumentStore.UpdateDocumentPageSummary(ctx, documentID, pageNumber, summary)
+}
+
+func TestPageSummariesAreStoredAsTheyAreWritten(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Summary = config.SummaryConfig{Enabled: true, MaxWords: 10, PageMaxWords: 5, PartChars: 1000, Concurrency: 1}
+	})
+	ctx := context.Background()
+
+	doc := &models.Document{ID: "doc-1", Filename: "report.pdf", FileType: "pdf", Status: "processing"}
+	if err := env.repo.CreateDocument(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.repo.InitDocumentPages(ctx, doc.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range []string{"Revenue grew in every region.", "Costs fell.", "The invoice backlog was cleared."} {
+		page := &models.DocumentPage{DocumentID: doc.ID, PageNumber: i + 1, Status: models.PageStatusProcessed, Content: &text}
+		if err := env.repo.UpdateDocumentPage(ctx, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first page fails, but the summaries of the others are kept for
+	// the next run
+	client := openai.New(env.cfg.OpenAI, nil)
+	repo := &failingPageSummaries{DocumentStore: env.repo, pages: map[int]bool{1: true}}
+	processing := NewProcessingService(repo, env.store, client, client, client, env.ocr, nil, env.svc.Events, env.cfg, logger.New("error"))
+	if _, err := processing.summarizeDocument(ctx, doc, "Revenue grew in every region."); err == nil {
+		t.Fatal("summarizeDocument succeeded, want the failure of page 1")
+	}
+
+	pages, err := env.repo.GetDocumentPages(ctx, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range pages {
+		if hasSummary := page.Summary != nil; hasSummary != (page.PageNumber != 1) {
+			t.Fatalf("page %d has summary %v", page.PageNumber, page.Summary)
+		}
+	}
+}
+
+func TestDocumentsAreProcessedWhenSummarizingFails(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Summary = config.SummaryConfig{Enabled: true, MaxWords: 10, PageMaxWords: 5, PartChars: 1000, Concurrency: 1}
+	})
+	ctx := context.Background()
+	env.openai.Fail(openaitest.ChatPath, http.StatusBadRequest)
+
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+
+	doc, err := env.repo.GetDocumentByID(ctx, "doc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Summary != nil && *doc.Summary != "" {
+		t.Fatalf("summary = %q, want none", *doc.Summary)
+	}
+	if doc.Content == nil || *doc.Content == "" {
+		t.Fatal("document was stored without its content")
+	}
+}
+
+func TestAskCitesTheChunksItAnswersFrom(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+	question := "When was the invoice backlog cleared?"
+
+	// Source numbers the answer did not get are ignored
+	env.openai.Reply(`{"answered": true, "answer": "Before the audit [1].", "sources": [1, 1, 7]}`)
+	resp, err := env.svc.Ask.Ask(ctx, &models.AskRequest{Question: question})
+	if err != nil {
+		t.Fatalf("Ask: %v", err)
+	}
+	if !resp.Answered || resp.Answer != "Before the audit [1]." || len(resp.Citations) != 1 {
+		t.Fatalf("Ask = %+v, want the answer with one citation", resp)
+	}
+	citation := resp.Citations[0]
+	if citation.Source != 1 || citation.DocumentID != "doc-1" || citation.Filename != "report.txt" ||
+		citation.EndOffset != len([]rune(reportText)) || citation.Excerpt == "" {
+		t.Fatalf("citation = %+v, want the chunk of doc-1", citation)
+	}
+
+	env.openai.Reply(`{"answered": false, "answer": "The documents do not say.", "sources": [1]}`)
+	resp, err = env.svc.Ask.Ask(ctx, &models.AskRequest{Question: question})
+	if err != nil || resp.Answered || resp.RefusalReason != models.AskRefusalUnsupported || len(resp.Citations) != 0 {
+		t.Fatalf("Ask = %+v, %v; want an unsupported refusal", resp, err)
+	}
+
+	env.openai.Reply(`{"answered": true, "answer": "In March.", "sources": []}`)
+	resp, err = env.svc.Ask.Ask(ctx, &models.AskRequest{Question: question})
+	if err != nil || resp.Answered || resp.RefusalReason != models.AskRefusalUnsupported {
+		t.Fatalf("Ask = %+v, %v; want an answer without citations refused", resp, err)
+	}
+
+	// Without matching chunks the model is not asked
+	requests := len(env.openai.Requests(openaitest.ChatPath))
+	resp, err = env.svc.Ask.Ask(ctx, &models.AskRequest{Question: question, Filters: map[string]interface{}{"documentIds": "doc-2"}})
+	if err != nil || resp.Answered || resp.RefusalReason != models.AskRefusalNoContext {
+		t.Fatalf("Ask = %+v, %v; want a no-context refusal", resp, err)
+	}
+	if n := len(env.openai.Requests(openaitest.ChatPath)); n != requests {
+		t.Fatalf("%d chat requests without context, want none", n-requests)
+	}
+
+	if _, err := env.svc.Ask.Ask(ctx, &models.AskRequest{Question: "  "}); !errors.Is(err, ErrInvalidAskRequest) {
+		t.Fatalf("empty question = %v, want ErrInvalidAskRequest", err)
+	}
+	if _, err := env.svc.Ask.Ask(ctx, &models.AskRequest{Question: question, Mode: "fuzzy"}); !errors.Is(err, ErrInvalidSearchRequest) {
+		t.Fatalf("unknown mode = %v, want ErrInvalidSearchRequest", err)
+	}
+}
+
+func TestAskStreamCitesTheSourcesItMarks(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+	req := &models.AskRequest{Question: "When was the invoice backlog cleared?"}
+
+	// Markers of sources the answer did not get are ignored
+	env.openai.Reply("Before the audit [1], not after it [3].")
+	var streamed strings.Builder
+	resp, err := env.svc.Ask.AskStream(ctx, req, func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AskStream: %v", err)
+	}
+	if !resp.Answered || resp.Answer != streamed.String() || len(resp.Citations) != 1 || resp.Citations[0].DocumentID != "doc-1" {
+		t.Fatalf("AskStream = %+v after %q, want the streamed answer citing doc-1", resp, streamed.String())
+	}
+
+	env.openai.Reply("The documents do not say.")
+	resp, err = env.svc.Ask.AskStream(ctx, req, func(string) error { return nil })
+	if err != nil || resp.Answered || resp.RefusalReason != models.AskRefusalUnsupported || len(resp.Citations) != 0 {
+		t.Fatalf("AskStream = %+v, %v; want an answer without citations refused", resp, err)
+	}
+}
+
+func TestProcessingEventsCanBeReplayed(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Events = config.EventsConfig{PollInterval: 10 * time.Millisecond}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new stream of a document starts with its latest processing run
+	afterID, err := env.svc.Events.StartID(ctx, "doc-1", nil)
+	if err != nil {
+		t.Fatalf("StartID: %v", err)
+	}
+	events := collectEvents(t, ctx, env.svc.Events, afterID)
+
+	var got []string
+	for _, event := range events {
+		name := event.Type
+		if stage, ok := event.Data["stage"].(string); ok {
+			name += ":" + stage
+		}
+		got = append(got, name)
+	}
+	want := []string{
+		models.EventQueued,
+		models.EventStage + ":" + models.StageDownloading,
+		models.EventStage + ":" + models.StageExtracting,
+		models.EventStage + ":" + models.StageSummarizing,
+		models.EventStage + ":" + models.StageEmbedding,
+		models.EventProcessed,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+
+	// A reconnecting client resumes after the last event it received
+	lastEventID := events[2].ID
+	afterID, err = env.svc.Events.StartID(ctx, "doc-1", &lastEventID)
+	if err != nil {
+		t.Fatalf("StartID: %v", err)
+	}
+	if resumed := collectEvents(t, ctx, env.svc.Events, afterID); len(resumed) != 3 || resumed[0].ID != events[3].ID {
+		t.Fatalf("resumed with %+v, want the last 3 events", resumed)
+	}
+
+	if _, err := env.svc.Events.StartID(ctx, "doc-2", nil); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("StartID of a missing document = %v, want ErrNotFound", err)
+	}
+}
+
+// countedEventLists is a store that counts the event queries of each set of
+// documents.
+type countedEventLists struct {
+	repository.DocumentStore
+	mu    sync.Mutex
+	lists map[string]int
+}
+
+func (c *countedEventLists) ListEventsAfter(ctx context.Context, afterID int64, documentIDs []string, limit int) ([]models.ProcessingEvent, error) {
+	c.mu.Lock()
+	c.lists[strings.Join(documentIDs, ",")]++
+	c.mu.Unlock()
+	return c.DocumentStore.ListEventsAfter(ctx, afterID, documentIDs, limit)
+}
+
+func (c *countedEventLists) count(documentIDs string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lists[documentIDs]
+}
+
+func TestEventsWakeOnlyTheStreamsThatFollowThem(t *testing.T) {
+	env := newTestEnv(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Streams never poll, so only recorded events wake them
+	cfg := *env.cfg
+	cfg.Events = config.EventsConfig{PollInterval: time.Hour}
+	repo := &countedEventLists{DocumentStore: env.repo, lists: make(map[string]int)}
+	events := NewEventService(repo, &cfg, logger.New("error"))
+
+	go events.Subscribe(ctx, 0, []string{"doc-2"}, func(*models.ProcessingEvent) error { return nil })
+	go func() {
+		// Publish once both streams have run their first query
+		for (repo.count("doc-1") == 0 || repo.count("doc-2") == 0) && ctx.Err() == nil {
+			time.Sleep(time.Millisecond)
+		}
+		events.Publish(ctx, "doc-1", models.EventQueued, nil)
+		events.Publish(ctx, "doc-1", models.EventProcessed, nil)
+	}()
+
+	if collected := collectEvents(t, ctx, events, 0); len(collected) != 2 {
+		t.Fatalf("streamed %+v, want both events", collected)
+	}
+
+	// Give a stream woken by mistake time to query
+	time.Sleep(50 * time.Millisecond)
+	if n := repo.count("doc-2"); n != 1 {
+		t.Fatalf("the stream of doc-2 queried %d times, want once", n)
+	}
+}
+
+// collectEvents returns the events of doc-1 after afterID, up to the one
+// reporting that it was processed.
+func collectEvents(t *testing.T, ctx context.Context, events *EventService, afterID int64) []models.ProcessingEvent {
+	t.Helper()
+
+	done := errors.New("done")
+	var collected []models.ProcessingEvent
+	err := events.Subscribe(ctx, afterID, []string{"doc-1"}, func(event *models.ProcessingEvent) error {
+		if event == nil {
+			return nil
+		}
+		collected = append(collected, *event)
+		if event.Type == models.EventProcessed {
+			return done
+		}
+		return nil
+	})
+	if !errors.Is(err, done) {
+		t.Fatalf("Subscribe = %v after %+v, want the events up to processed", err, collected)
+	}
+	return collected
+}
+
+func TestChunksRecordTheirPages(t *testing.T) {
+	pageText := func(text string) *string { return &text }
+	pages := []models.DocumentPage{
+		{PageNumber: 1, Status: models.PageStatusProcessed, Content: pageText("First  page.")},
+		{PageNumber: 2, Status: models.PageStatusFailed},
+		{PageNumber: 3, Status: models.PageStatusProcessed, Content: pageText("Third page, with ي.")},
+	}
+	text := persian.Normalize("First  page.\n\nThird page, with ي.")
+
+	spans := pageSpans(text, pages)
+	want := []pageSpan{{Number: 1, Start: 0, End: 11}, {Number: 3, Start: 13, End: 32}}
+	if !reflect.DeepEqual(spans, want) {
+		t.Fatalf("pageSpans = %+v, want %+v", spans, want)
+	}
+
+	tests := []struct {
+		chunk textChunk
+		want  []int
+	}{
+		{textChunk{Start: 0, End: 5}, []int{1}},
+		{textChunk{Start: 8, End: 20}, []int{1, 3}},
+		{textChunk{Start: 11, End: 13}, nil},
+		{textChunk{Start: 20, End: 32}, []int{3}},
+	}
+	for _, tt := range tests {
+		if got := chunkPages(spans, tt.chunk); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("chunkPages(%d-%d) = %v, want %v", tt.chunk.Start, tt.chunk.End, got, tt.want)
+		}
+	}
+}
+
+func TestUnrenderedPagesFailAlongsideOCRPages(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.OCR.PageConcurrency = 4
+		cfg.OCR.TesseractMode = ocr.ModePrimary
+		cfg.OCR.EscalationPolicy = ocr.EscalateNever
+	})
+	env.ocr.err = errors.New("tesseract crashed")
+	ctx := context.Background()
+
+	imagePath := filepath.Join(t.TempDir(), "page.png")
+	if err := os.WriteFile(imagePath, pngImage(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unrendered pages are counted while earlier pages fail OCR
+	pages := []pageSource{{Text: "Text layer page."}}
+	for i := 0; i < 8; i++ {
+		pages = append(pages, pageSource{ImagePath: imagePath}, pageSource{})
+	}
+
+	doc := &models.Document{ID: "doc-1", Filename: "scan.pdf", FileType: "pdf", Status: "processing"}
+	if err := env.repo.CreateDocument(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	text, _, err := env.svc.Processing.extractPages(ctx, doc.ID, pages)
+	if err != nil || text != "Text layer page." {
+		t.Fatalf("extractPages = %q, %v; want the text layer page", text, err)
+	}
+
+	status, err := env.svc.Processing.GetProcessingStatus(ctx, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PagesDone != 1 || status.PagesFailed != 16 {
+		t.Fatalf("status = %+v, want 1 page done and 16 failed", status)
+	}
+}
+
+func TestTesseractModes(t *testing.T) {
+	const (
+		visionText    = "Text read by the vision model"
+		tesseractText = "Text read by Tesseract"
+	)
+
+	tests := []struct {
+		name         string
+		mode, policy string
+		confidence   float64
+		ocrErr       error
+		visionDown   bool
+		wantText     string
+		wantMetadata map[string]interface{}
+	}{
+		{
+			name: "primary keeps a confident page", mode: ocr.ModePrimary, confidence: 0.92,
+			wantText:     tesseractText,
+			wantMetadata: map[string]interface{}{"extraction_method": "tesseract_ocr", "ocr_confidence": 0.92},
+		},
+		{
+			name: "primary escalates a low confidence page", mode: ocr.ModePrimary, confidence: 0.4,
+			wantText:     visionText,
+			wantMetadata: map[string]interface{}{"extraction_method": "vision_ocr", "escalation_reason": "low_confidence", "ocr_confidence": 0.4},
+		},
+		{
+			name: "escalating only empty pages", mode: ocr.ModePrimary, policy: ocr.EscalateEmpty, confidence: 0.4,
+			wantText:     tesseractText,
+			wantMetadata: map[string]interface{}{"extraction_method": "tesseract_ocr"},
+		},
+		{
+			name: "primary keeps Tesseract's text when the vision model is down", mode: ocr.ModePrimary, confidence: 0.4, visionDown: true,
+			wantText:     tesseractText,
+			wantMetadata: map[string]interface{}{"extraction_method": "tesseract_ocr", "escalation_error": "OpenAI API error: 503: injected failure"},
+		},
+		{
+			name: "primary escalates when Tesseract fails", mode: ocr.ModePrimary, ocrErr: errors.New("tesseract crashed"),
+			wantText:     visionText,
+			wantMetadata: map[string]interface{}{"extraction_method": "vision_ocr", "escalation_reason": "tesseract_failed"},
+		},
+		{
+			name: "fallback is not used while the vision model works", mode: ocr.ModeFallback, confidence: 0.9,
+			wantText:     visionText,
+			wantMetadata: map[string]interface{}{"extraction_method": "vision_ocr"},
+		},
+		{
+			name: "fallback reads with Tesseract when the vision model is down", mode: ocr.ModeFallback, confidence: 0.9, visionDown: true,
+			wantText:     tesseractText,
+			wantMetadata: map[string]interface{}{"extraction_method": "tesseract_ocr", "fallback_reason": "OpenAI API error: 503: injected failure"},
+		},
+		{
+			name: "compare records agreement", mode: ocr.ModeCompare, confidence: 0.9,
+			wantText:     visionText,
+			wantMetadata: map[string]interface{}{"extraction_method": "vision_ocr", "tesseract_confidence": 0.9, "tesseract_agreement": 0.429},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(cfg *config.Config) {
+				cfg.OCR.TesseractMode = tt.mode
+				cfg.OCR.EscalationPolicy = tt.policy
+				cfg.OCR.MinConfidence = 0.7
+			})
+			ctx := context.Background()
+
+			env.ocr.result = ocr.Result{Text: tesseractText, Confidence: tt.confidence}
+			env.ocr.err = tt.ocrErr
+			env.openai.Provider.ImageText = func([]byte) string { return visionText }
+			if tt.visionDown {
+				env.openai.Fail(openaitest.ChatPath, http.StatusServiceUnavailable)
+			}
+
+			file := fileHeader(t, "scan.png", "image/png", pngImage(t))
+			if _, err := env.svc.Processing.ProcessDocumentWithFile(ctx, "scan", file, models.DuplicatePolicyAsk); err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+			env.waitForStatus(t, "scan", "processed")
+
+			doc, err := env.repo.GetDocumentByID(ctx, "scan")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.Content == nil || *doc.Content != tt.wantText {
+				t.Fatalf("content = %v, want %q", doc.Content, tt.wantText)
+			}
+			for key, want := range tt.wantMetadata {
+				if got := doc.Metadata[key]; got != want {
+					t.Errorf("metadata[%s] = %v, want %v (metadata %v)", key, got, want, doc.Metadata)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadRejectsMismatchedContent(t *testing.T) {
+	env := newTestEnv(t)
+
+	file := fileHeader(t, "notes.txt", "text/plain", []byte("%PDF-1.4\n%âãÏÓ\n1 0 obj\n<<>>\nendobj\n"))
+	_, err := env.svc.Processing.ProcessDocumentWithFile(context.Background(), "doc-1", file, models.DuplicatePolicyAsk)
+	if !errors.Is(err, ErrInvalidUpload) {
+		t.Fatalf("upload of a PDF named .txt = %v, want ErrInvalidUpload", err)
+	}
+
+	if _, err := env.repo.GetDocumentByID(context.Background(), "doc-1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("rejected upload created a document: %v", err)
+	}
+}
+
+func TestDuplicateUploads(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	if _, err := env.upload(t, "original", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+	original := env.waitForStatus(t, "original", "processed")
+	calls := len(env.openai.Requests(openaitest.EmbeddingsPath))
+
+	_, err := env.upload(t, "copy", "copy.txt", reportText, models.DuplicatePolicyAsk)
+	var duplicate *DuplicateError
+	if !errors.As(err, &duplicate) || duplicate.DocumentID != "original" {
+		t.Fatalf("duplicate upload with ask = %v, want DuplicateError for original", err)
+	}
+
+	doc, err := env.upload(t, "copy", "copy.txt", reportText, models.DuplicatePolicyLink)
+	if err != nil {
+		t.Fatalf("duplicate upload with link: %v", err)
+	}
+	if doc.DuplicateOf == nil || *doc.DuplicateOf != "original" {
+		t.Fatalf("linked document DuplicateOf = %v, want original", doc.DuplicateOf)
+	}
+
+	linked, err := env.repo.GetDocumentByID(ctx, "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.Status != "processed" {
+		t.Fatalf("linked document is %q, want processed", linked.Status)
+	}
+	if count, _ := env.repo.GetDocumentChunkCount(ctx, "copy"); count != original.ChunkCount {
+		t.Fatalf("linked document has %d chunks, want %d", count, original.ChunkCount)
+	}
+	if len(env.openai.Requests(openaitest.EmbeddingsPath)) != calls {
+		t.Fatal("linking a duplicate generated embeddings")
+	}
+
+	// The shared file outlives the original while the copy still uses it
+	if err := env.svc.Documents.DeleteDocument(ctx, "original"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.store.Stat(ctx, linked.FilePath); err != nil {
+		t.Fatalf("shared file removed with the original: %v", err)
+	}
+}
+
+func TestFailedJobsAreRetriedThenDeadLettered(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	env.openai.Fail(openaitest.EmbeddingsPath, http.StatusServiceUnavailable)
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+
+	status := env.waitForStatus(t, "doc-1", "failed")
+	if status.Job.Status != models.JobStatusDead || status.Job.Attempts != env.cfg.Queue.MaxAttempts {
+		t.Fatalf("job = %+v, want dead after %d attempts", status.Job, env.cfg.Queue.MaxAttempts)
+	}
+	if status.Job.LastError == nil || !strings.Contains(*status.Job.LastError, "503") {
+		t.Fatalf("job error = %v, want the API failure", status.Job.LastError)
+	}
+
+	env.openai.Fail(openaitest.EmbeddingsPath, 0)
+	if _, err := env.svc.Jobs.RetryJob(ctx, status.Job.ID); err != nil {
+		t.Fatalf("RetryJob: %v", err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+
+	if _, err := env.svc.Jobs.RetryJob(ctx, status.Job.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("retrying a completed job = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDeadJobsAreNotRetriedBesideAnActiveOne(t *testing.T) {
+	repo := repository.NewMemoryStore()
+	jobs := NewJobService(repo, nil, nil, &config.Config{}, logger.New("error"))
+	ctx := context.Background()
+
+	if err := repo.CreateDocument(ctx, &models.Document{ID: "doc-1", Filename: "report.txt", FileType: "txt", Status: "failed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.EnqueueJob(ctx, &models.ProcessingJob{ID: "dead", DocumentID: "doc-1", MaxAttempts: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ClaimJob(ctx, "worker", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.FailJob(ctx, "dead", "worker", "failed", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.EnqueueJob(ctx, &models.ProcessingJob{ID: "queued", DocumentID: "doc-1", MaxAttempts: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jobs.RetryJob(ctx, "dead"); !errors.Is(err, repository.ErrActiveJob) {
+		t.Fatalf("RetryJob beside a queued job = %v, want ErrActiveJob", err)
+	}
+}
+
+// failingEnqueues is a store that cannot queue jobs.
+type failingEnqueues struct {
+	repository.DocumentStore
+}
+
+func (f *failingEnqueues) EnqueueJob(ctx context.Context, job *models.ProcessingJob) error {
+	return errors.New("enqueue failed")
+}
+
+func TestUploadsThatCannotBeQueuedFail(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	client := openai.New(env.cfg.OpenAI, nil)
+	processing := NewProcessingService(&failingEnqueues{env.repo}, env.store, client, client, client, env.ocr, nil, env.svc.Events, env.cfg, logger.New("error"))
+	file := fileHeader(t, "report.txt", "text/plain", []byte(reportText))
+	if _, err := processing.ProcessDocumentWithFile(ctx, "doc-1", file, models.DuplicatePolicyAsk); err == nil {
+		t.Fatal("ProcessDocumentWithFile succeeded, want the enqueue failure")
+	}
+
+	// The document does not wait for a job that does not exist, and can be
+	// processed again
+	if doc, err := env.repo.GetDocumentByID(ctx, "doc-1"); err != nil || doc.Status != "failed" {
+		t.Fatalf("document = %+v, %v; want it failed", doc, err)
+	}
+	if err := env.svc.Processing.ProcessDocument(ctx, "doc-1"); err != nil {
+		t.Fatalf("ProcessDocument: %v", err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+}
+
+func TestRetryFailedPagesRefusals(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+
+	if err := env.svc.Processing.RetryFailedPages(ctx, "doc-1"); !errors.Is(err, ErrCannotRetryPages) {
+		t.Fatalf("RetryFailedPages without failed pages = %v, want ErrCannotRetryPages", err)
+	}
+	if err := env.svc.Processing.RetryFailedPages(ctx, "doc-2"); !errors.Is(err, repository.ErrNotFound) || errors.Is(err, ErrCannotRetryPages) {
+		t.Fatalf("RetryFailedPages of a missing document = %v, want ErrNotFound", err)
+	}
+}
+
+// failingCreates is a store that cannot create documents.
+type failingCreates struct {
+	repository.DocumentStore
+}
+
+func (f *failingCreates) CreateDocument(ctx context.Context, doc *models.Document) error {
+	return errors.New("insert failed")
+}
+
+func TestUploadsLeaveNoObjectsBehind(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk); err != nil {
+		t.Fatal(err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+
+	objects := func() []string {
+		var keys []string
+		env.store.List(ctx, documentsPrefix, func(info storage.ObjectInfo) error {
+			keys = append(keys, info.Key)
+			return nil
+		})
+		return keys
+	}
+	before := objects()
+
+	// An existing ID is rejected before anything is stored
+	if _, err := env.upload(t, "doc-1", "report.txt", reportText+" Revised.", models.DuplicatePolicyAsk); !errors.Is(err, repository.ErrAlreadyExists) {
+		t.Fatalf("upload of an existing ID = %v, want ErrAlreadyExists", err)
+	}
+
+	// A document that cannot be created takes its stored file with it
+	client := openai.New(env.cfg.OpenAI, nil)
+	processing := NewProcessingService(&failingCreates{env.repo}, env.store, client, client, client, env.ocr, nil, env.svc.Events, env.cfg, logger.New("error"))
+	file := fileHeader(t, "other.txt", "text/plain", []byte("Another report."))
+	if _, err := processing.ProcessDocumentWithFile(ctx, "doc-2", file, models.DuplicatePolicyAsk); err == nil {
+		t.Fatal("ProcessDocumentWithFile succeeded, want the insert failure")
+	}
+
+	if after := objects(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("objects = %v after the failed uploads, want %v", after, before)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Storage.TrashRetention = 0
+	})
+	ctx := context.Background()
+
+	for _, id := range []string{"kept", "trashed"} {
+		if _, err := env.upload(t, id, id+".txt", reportText+" "+id, models.DuplicatePolicyAsk); err != nil {
+			t.Fatal(err)
+		}
+		env.waitForStatus(t, id, "processed")
+	}
+	trashed, _ := env.repo.GetDocumentByID(ctx, "trashed")
+
+	if err := env.svc.Documents.TrashDocument(ctx, "trashed"); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := env.svc.Documents.PurgeTrash(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeTrash = %d, %v; want 1 document purged", purged, err)
+	}
+	if _, err := env.repo.GetDocumentByID(ctx, "trashed"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("purged document still exists: %v", err)
+	}
+	if _, err := env.store.Stat(ctx, trashed.FilePath); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("purged document's file still stored: %v", err)
+	}
+	if _, err := env.repo.GetDocumentByID(ctx, "kept"); err != nil {
+		t.Fatalf("purge removed a live document: %v", err)
+	}
+}
+
+// failingDeletes is a store that cannot delete some documents.
+type failingDeletes struct {
+	repository.DocumentStore
+	ids map[string]bool
+}
+
+func (f *failingDeletes) DeleteDocument(ctx context.Context, documentID string) error {
+	if f.ids[documentID] {
+		return errors.New("delete failed")
+	}
+	return f.DocumentStore.DeleteDocument(ctx, documentID)
+}
+
+func TestPurgeTrashSkipsDocumentsThatFail(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Storage.TrashRetention = 0
+	})
+	ctx := context.Background()
+
+	// The failing document comes first in every listing
+	for _, id := range []string{"stuck", "trashed"} {
+		if _, err := env.upload(t, id, id+".txt", reportText+" "+id, models.DuplicatePolicyAsk); err != nil {
+			t.Fatal(err)
+		}
+		env.waitForStatus(t, id, "processed")
+		if err := env.svc.Documents.TrashDocument(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo := &failingDeletes{DocumentStore: env.repo, ids: map[string]bool{"stuck": true}}
+	documents := NewDocumentService(repo, env.store, env.svc.Processing, env.cfg, logger.New("error"))
+
+	purged, err := documents.PurgeTrash(ctx)
+	if purged != 1 || err == nil || !strings.Contains(err.Error(), "stuck") {
+		t.Fatalf("PurgeTrash = %d, %v; want 1 document purged and the failure of stuck", purged, err)
+	}
+	if _, err := env.repo.GetDocumentByID(ctx, "trashed"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("document after the failing one was not purged: %v", err)
+	}
+}
+
+func TestSweepOrphans(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.Storage.OrphanDelete = true
+	})
+	ctx := context.Background()
+
+	doc, err := env.upload(t, "doc-1", "report.txt", reportText, models.DuplicatePolicyAsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.waitForStatus(t, "doc-1", "processed")
+
+	orphan := documentsPrefix + "ghost/" + strings.Repeat("0", 64)
+	if err := env.store.Put(ctx, orphan, strings.NewReader("left behind"), -1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := env.svc.Documents.SweepOrphans(ctx)
+	if err != nil {
+		t.Fatalf("SweepOrphans: %v", err)
+	}
+	if report.Scanned != 2 || report.Removed != 1 || len(report.Orphans) != 1 || report.Orphans[0] != orphan {
+		t.Fatalf("sweep report = %+v, want only %s removed", report, orphan)
+	}
+
+	if _, err := env.store.Stat(ctx, orphan); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("orphan still stored: %v", err)
+	}
+	object, err := env.store.Get(ctx, doc.FilePath)
+	if err != nil {
+		t.Fatalf("document file removed by the sweep: %v", err)
+	}
+	data, _ := io.ReadAll(object)
+	object.Close()
+	if string(data) != reportText {
+		t.Fatalf("document file = %q, want the upload", data)
+	}
+}
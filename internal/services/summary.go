@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"document-embeddings/internal/models"
+	"document-embeddings/pkg/persian"
+	"document-embeddings/pkg/provider"
+)
+
+// summarizeDocument writes the summary of a document other than an image.
+// Multi-page documents are summarized map-reduce style: every page gets a
+// summary of its own, stored in DocumentPage, and the page summaries are
+// combined into the document summary. Other documents longer than
+// Summary.PartChars are split into parts that are summarized and combined the
+// same way. Each page summary is stored as soon as it is written, so a run
+// that fails, or a later retry, reuses the page summaries already stored.
+//
+// With summaries disabled the document gets an empty summary.
+func (s *ProcessingService) summarizeDocument(ctx context.Context, doc *models.Document, text string) (string, error) {
+	if !s.cfg.Summary.Enabled || strings.TrimSpace(text) == "" {
+		return "", nil
+	}
+
+	pages, err := s.repo.GetDocumentPages(ctx, doc.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get document pages: %w", err)
+	}
+
+	var parts []string
+	switch {
+	case len(pages) > 1:
+		parts, err = s.summarizePages(ctx, doc, pages)
+	case len([]rune(text)) > s.partChars():
+		var texts []string
+		for _, chunk := range chunkText(text, s.partChars(), 0) {
+			texts = append(texts, chunk.Content)
+		}
+		parts, err = s.summarizeParts(ctx, doc.FileType, texts, nil)
+	default:
+		return s.summarize(ctx, doc.FileType, text, s.cfg.Summary.MaxWords, false)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return s.combineSummaries(ctx, doc.FileType, parts)
+}
+
+// summarizePages summarizes each processed page that has no summary yet,
+// storing each summary as soon as it is written, and returns the page
+// summaries in page order, labelled with their page number when there is more
+// than one.
+func (s *ProcessingService) summarizePages(ctx context.Context, doc *models.Document, pages []models.DocumentPage) ([]string, error) {
+	summaries := make([]string, len(pages))
+	var texts []string
+	var pending []int
+	for i, page := range pages {
+		switch {
+		case page.Status != models.PageStatusProcessed || page.Content == nil || strings.TrimSpace(*page.Content) == "":
+		case page.Summary != nil:
+			summaries[i] = *page.Summary
+		default:
+			texts = append(texts, persian.Normalize(*page.Content))
+			pending = append(pending, i)
+		}
+	}
+
+	written, err := s.summarizeParts(ctx, doc.FileType, texts, func(j int, summary string) error {
+		page := pages[pending[j]]
+		if err := s.repo.UpdateDocumentPageSummary(ctx, doc.ID, page.PageNumber, summary); err != nil {
+			return fmt.Errorf("failed to update summary of page %d: %w", page.PageNumber, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for j, i := range pending {
+		summaries[i] = written[j]
+	}
+
+	var parts, labels []string
+	for i, summary := range summaries {
+		if summary != "" {
+			parts = append(parts, summary)
+			labels = append(labels, fmt.Sprintf("Page %d: %s", pages[i].PageNumber, summary))
+		}
+	}
+	if len(parts) == 1 {
+		return parts, nil
+	}
+	return labels, nil
+}
+
+// summarizeParts summarizes texts in Summary.PageMaxWords words each, at most
+// Summary.Concurrency at a time, and returns the summaries in order. If done
+// is not nil it is called with each summary as soon as it is written; an
+// error from it fails that part.
+func (s *ProcessingService) summarizeParts(ctx context.Context, documentType string, texts []string, done func(index int, summary string) error) ([]string, error) {
+	summaries := make([]string, len(texts))
+	errs := make([]error, len(texts))
+	sem := make(chan struct{}, max(s.cfg.Summary.Concurrency, 1))
+
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		wg.Add(1)
+		go func(index int, text string) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				errs[index] = ctx.Err()
+				return
+			}
+
+			summaries[index], errs[index] = s.summarize(ctx, documentType, text, s.cfg.Summary.PageMaxWords, false)
+			if errs[index] == nil && done != nil {
+				errs[index] = done(index, summaries[index])
+			}
+		}(i, text)
+	}
+
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to summarize part %d: %w", i+1, err)
+		}
+	}
+	return summaries, nil
+}
+
+// combineSummaries merges part summaries into one of Summary.MaxWords words.
+// While the parts together are longer than Summary.PartChars, neighbouring
+// parts are first combined in groups that fit. A single part, written to
+// Summary.PageMaxWords, is rewritten to the document length.
+func (s *ProcessingService) combineSummaries(ctx context.Context, documentType string, parts []string) (string, error) {
+	switch len(parts) {
+	case 0:
+		return "", nil
+	case 1:
+		summary, err := s.summarize(ctx, documentType, parts[0], s.cfg.Summary.MaxWords, true)
+		if err != nil {
+			return "", fmt.Errorf("failed to combine summaries: %w", err)
+		}
+		return summary, nil
+	}
+
+	for len(parts) > 1 && len([]rune(strings.Join(parts, "\n\n"))) > s.partChars() {
+		var groups [][]string
+		var size int
+		for _, part := range parts {
+			n := len([]rune(part)) + 2
+			// A group always takes two parts, so every round shrinks the list
+			if len(groups) == 0 || (size+n > s.partChars() && len(groups[len(groups)-1]) > 1) {
+				groups = append(groups, nil)
+				size = 0
+			}
+			groups[len(groups)-1] = append(groups[len(groups)-1], part)
+			size += n
+		}
+
+		combined := make([]string, 0, len(groups))
+		for _, group := range groups {
+			if len(group) == 1 {
+				combined = append(combined, group[0])
+				continue
+			}
+			summary, err := s.summarize(ctx, documentType, strings.Join(group, "\n\n"), s.cfg.Summary.MaxWords, true)
+			if err != nil {
+				return "", fmt.Errorf("failed to combine summaries: %w", err)
+			}
+			combined = append(combined, summary)
+		}
+		parts = combined
+	}
+
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	summary, err := s.summarize(ctx, documentType, strings.Join(parts, "\n\n"), s.cfg.Summary.MaxWords, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to combine summaries: %w", err)
+	}
+	return summary, nil
+}
+
+func (s *ProcessingService) summarize(ctx context.Context, documentType, text string, maxWords int, combine bool) (string, error) {
+	summary, err := s.chat.Summarize(ctx, provider.SummaryRequest{
+		Text:         text,
+		DocumentType: documentType,
+		MaxWords:     maxWords,
+		Language:     s.cfg.Summary.Language,
+		Combine:      combine,
+	})
+	if err != nil {
+		return "", err
+	}
+	return persian.Normalize(summary), nil
+}
+
+func (s *ProcessingService) partChars() int {
+	if s.cfg.Summary.PartChars <= 0 {
+		return 12000
+	}
+	return s.cfg.Summary.PartChars
+}
@@ -20,8 +20,12 @@ import (
 	"document-embeddings/internal/services"
 	"document-embeddings/pkg/database"
 	"document-embeddings/pkg/logger"
-	"document-embeddings/pkg/minio"
+	"document-embeddings/pkg/ocr"
 	"document-embeddings/pkg/openai"
+	"document-embeddings/pkg/pdf"
+	"document-embeddings/pkg/prompts"
+	"document-embeddings/pkg/provider"
+	"document-embeddings/pkg/storage"
 )
 
 func main() {
@@ -43,23 +47,49 @@ func main() {
 	}
 	defer db.Close()
 
-	// Initialize MinIO client
-	minioClient, err := minio.New(cfg.MinIO)
+	// Initialize object storage
+	store, err := storage.New(cfg)
 	if err != nil {
-		logger.Fatal("Failed to initialize MinIO client", "error", err)
+		logger.Fatal("Failed to initialize object storage", "error", err)
 	}
 
-	// Initialize OpenAI client
-	openaiClient := openai.New(cfg.OpenAI)
+	// Initialize embedding, vision and chat providers
+	embedder, vision, chat, err := newProviders(cfg)
+	if err != nil {
+		logger.Fatal("Failed to initialize providers", "error", err)
+	}
+
+	// Initialize local OCR (nil unless OCR_TESSERACT_MODE enables it)
+	ocrEngine, err := ocr.New(cfg.OCR)
+	if err != nil {
+		logger.Fatal("Failed to initialize OCR", "error", err)
+	}
+
+	// Initialize PDF rasterizer
+	rasterizer, err := pdf.NewRasterizer(cfg.PDF)
+	if err != nil {
+		logger.Fatal("Failed to initialize PDF rasterizer", "error", err)
+	}
 
 	// Initialize repositories
 	repo := repository.New(db, logger)
 
+	// Every chunk insert fails if embeddings do not fit the vector column
+	if err := checkEmbeddingDimensions(repo, cfg.OpenAI.EmbeddingDimensions); err != nil {
+		logger.Fatal("Embedding dimensions do not match the database", "error", err)
+	}
+
 	// Initialize services
-	svc := services.New(repo, minioClient, openaiClient, cfg, logger)
+	svc := services.New(repo, store, embedder, vision, chat, ocrEngine, rasterizer, cfg, logger)
+
+	// Start processing workers, the orphaned object sweeper and event pruning
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	svc.Jobs.Start(workerCtx)
+	svc.Documents.Start(workerCtx)
+	svc.Events.Start(workerCtx)
 
 	// Initialize API handlers
-	handler := api.New(svc, logger)
+	handler := api.New(svc, cfg, logger)
 
 	// Setup Gin router
 	r := gin.New()
@@ -75,6 +105,8 @@ func main() {
 		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
 		Handler: r,
 	}
+	// Event streams stay open until the client leaves; end them on shutdown
+	srv.RegisterOnShutdown(svc.Events.Close)
 
 	// Start server in goroutine
 	go func() {
@@ -99,6 +131,71 @@ func main() {
 		logger.Fatal("Server forced to shutdown", "error", err)
 	}
 
+	// Stop workers; in-flight jobs are released back to the queue
+	stopWorkers()
+	svc.Jobs.Wait()
+	svc.Documents.Wait()
+	svc.Events.Wait()
+
 	logger.Info("Server exited")
 }
 
+// checkEmbeddingDimensions compares OPENAI_EMBEDDING_DIMENSIONS with the
+// dimension of the DocumentChunk.embedding column created by init.sql.
+func checkEmbeddingDimensions(repo *repository.Repository, dimensions int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	column, err := repo.EmbeddingDimensions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read the embedding column: %w", err)
+	}
+	if column != 0 && column != dimensions {
+		return fmt.Errorf("OPENAI_EMBEDDING_DIMENSIONS is %d but the embedding column is vector(%d); change one to match", dimensions, column)
+	}
+	return nil
+}
+
+// newProviders returns the embedding, vision and chat providers selected by
+// EMBEDDING_PROVIDER, VISION_PROVIDER and CHAT_PROVIDER.
+func newProviders(cfg *config.Config) (provider.EmbeddingProvider, provider.VisionProvider, provider.ChatProvider, error) {
+	templates, err := prompts.Load(cfg.Prompts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	client := openai.New(cfg.OpenAI, templates)
+	fake := provider.NewFake(cfg.OpenAI.EmbeddingDimensions)
+
+	var embedder provider.EmbeddingProvider
+	switch cfg.Provider.Embedding {
+	case provider.NameOpenAI:
+		embedder = client
+	case provider.NameFake:
+		embedder = fake
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown embedding provider %q (want openai or fake)", cfg.Provider.Embedding)
+	}
+
+	var vision provider.VisionProvider
+	switch cfg.Provider.Vision {
+	case provider.NameOpenAI:
+		vision = client
+	case provider.NameFake:
+		vision = fake
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown vision provider %q (want openai or fake)", cfg.Provider.Vision)
+	}
+
+	var chat provider.ChatProvider
+	switch cfg.Provider.Chat {
+	case provider.NameOpenAI:
+		chat = client
+	case provider.NameFake:
+		chat = fake
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown chat provider %q (want openai or fake)", cfg.Provider.Chat)
+	}
+
+	return embedder, vision, chat, nil
+}
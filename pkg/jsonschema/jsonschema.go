@@ -0,0 +1,202 @@
+// Package jsonschema describes JSON values with the subset of JSON Schema
+// that structured model outputs use, and validates values against it.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Types a Schema can have.
+const (
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+)
+
+// Schema is a JSON Schema. It marshals to the form model APIs accept for
+// structured outputs.
+type Schema struct {
+	Type        string             `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	// AdditionalProperties is false for objects that allow only the listed
+	// properties.
+	AdditionalProperties *bool `json:"additionalProperties,omitempty"`
+}
+
+// Object returns a strict object schema: every property is required and no
+// other property is allowed, as strict structured outputs demand.
+func Object(properties map[string]*Schema) *Schema {
+	required := make([]string, 0, len(properties))
+	for name := range properties {
+		required = append(required, name)
+	}
+	sort.Strings(required)
+
+	closed := false
+	return &Schema{Type: TypeObject, Properties: properties, Required: required, AdditionalProperties: &closed}
+}
+
+// String returns a string schema.
+func String(description string) *Schema {
+	return &Schema{Type: TypeString, Description: description}
+}
+
+// Integer returns an integer schema.
+func Integer(description string) *Schema {
+	return &Schema{Type: TypeInteger, Description: description}
+}
+
+// Boolean returns a boolean schema.
+func Boolean(description string) *Schema {
+	return &Schema{Type: TypeBoolean, Description: description}
+}
+
+// Array returns an array schema with the given items.
+func Array(description string, items *Schema) *Schema {
+	return &Schema{Type: TypeArray, Description: description, Items: items}
+}
+
+// Validate checks that data is a single JSON value matching the schema. The
+// error names the first offending location, e.g. "metadata.colors[1]".
+func (s *Schema) Validate(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("invalid JSON: unexpected data after the top-level value")
+	}
+	return s.validate(value, "")
+}
+
+func (s *Schema) validate(value interface{}, path string) error {
+	at := func(format string, args ...interface{}) error {
+		location := path
+		if location == "" {
+			location = "value"
+		}
+		return fmt.Errorf("%s: %s", location, fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case TypeObject:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return at("expected an object, got %s", typeName(value))
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return at("missing property %q", name)
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return at("unexpected property %q", name)
+				}
+				continue
+			}
+			if err := property.validate(object[name], join(path, name)); err != nil {
+				return err
+			}
+		}
+
+	case TypeArray:
+		array, ok := value.([]interface{})
+		if !ok {
+			return at("expected an array, got %s", typeName(value))
+		}
+		if s.Items != nil {
+			for i, item := range array {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case TypeString:
+		str, ok := value.(string)
+		if !ok {
+			return at("expected a string, got %s", typeName(value))
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return at("%q is not one of %s", str, strings.Join(s.Enum, ", "))
+		}
+
+	case TypeNumber, TypeInteger:
+		number, ok := value.(json.Number)
+		if !ok {
+			return at("expected a number, got %s", typeName(value))
+		}
+		if s.Type == TypeInteger {
+			if _, err := number.Int64(); err != nil {
+				return at("expected an integer, got %s", number)
+			}
+		}
+
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return at("expected a boolean, got %s", typeName(value))
+		}
+
+	default:
+		return fmt.Errorf("unsupported schema type %q", s.Type)
+	}
+
+	return nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	schema := Object(map[string]*Schema{
+		"name": String(""),
+		"tags": Array("", String("")),
+		"size": {Type: TypeInteger},
+		"kind": {Type: TypeString, Enum: []string{"a", "b"}},
+	})
+
+	tests := []struct {
+		data    string
+		wantErr string
+	}{
+		{data: `{"name": "x", "tags": ["a"], "size": 3, "kind": "a"}`},
+		{data: `{"name": "x", "tags": [], "size": 3}`, wantErr: `value: missing property "kind"`},
+		{data: `{"name": "x", "tags": ["a", 2], "size": 3, "kind": "a"}`, wantErr: "tags[1]: expected a string, got a number"},
+		{data: `{"name": "x", "tags": null, "size": 3, "kind": "a"}`, wantErr: "tags: expected an array, got null"},
+		{data: `{"name": "x", "tags": [], "size": 3.5, "kind": "a"}`, wantErr: "size: expected an integer"},
+		{data: `{"name": "x", "tags": [], "size": 3, "kind": "c"}`, wantErr: `kind: "c" is not one of a, b`},
+		{data: `{"name": "x", "tags": [], "size": 3, "kind": "a", "extra": 1}`, wantErr: `unexpected property "extra"`},
+		{data: `{"name": "x"} trailing`, wantErr: "invalid JSON"},
+		{data: `not json`, wantErr: "invalid JSON"},
+	}
+
+	for _, tt := range tests {
+		err := schema.Validate([]byte(tt.data))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Validate(%s) = %v, want no error", tt.data, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Validate(%s) = %v, want an error containing %q", tt.data, err, tt.wantErr)
+		}
+	}
+}
@@ -45,10 +45,19 @@ func New(cfg config.MinIOConfig) (*Client, error) {
 	}, nil
 }
 
-func (c *Client) GetObject(ctx context.Context, objectPath string) (io.ReadCloser, error) {
+func (c *Client) GetObject(ctx context.Context, objectPath string) (*minio.Object, error) {
 	return c.Client.GetObject(ctx, c.BucketName, objectPath, minio.GetObjectOptions{})
 }
 
 func (c *Client) PutObject(ctx context.Context, objectPath string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
 	return c.Client.PutObject(ctx, c.BucketName, objectPath, reader, objectSize, opts)
 }
+
+func (c *Client) RemoveObject(ctx context.Context, objectPath string) error {
+	return c.Client.RemoveObject(ctx, c.BucketName, objectPath, minio.RemoveObjectOptions{})
+}
+
+// ListObjects lists every object under prefix, recursively.
+func (c *Client) ListObjects(ctx context.Context, prefix string) <-chan minio.ObjectInfo {
+	return c.Client.ListObjects(ctx, c.BucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+}
@@ -0,0 +1,72 @@
+package ocr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"document-embeddings/internal/config"
+)
+
+// How Tesseract is used next to the vision model (OCR_TESSERACT_MODE).
+const (
+	// ModeOff sends every image to the vision model.
+	ModeOff = "off"
+	// ModePrimary reads images with Tesseract and escalates pages to the
+	// vision model according to the escalation policy.
+	ModePrimary = "primary"
+	// ModeFallback uses the vision model and falls back to Tesseract when it
+	// fails.
+	ModeFallback = "fallback"
+	// ModeCompare uses the vision model and runs Tesseract alongside it,
+	// recording how the two compare without using Tesseract's text.
+	ModeCompare = "compare"
+)
+
+// When a page read by Tesseract in ModePrimary is sent to the vision model
+// (OCR_ESCALATION_POLICY).
+const (
+	// EscalateLowConfidence escalates pages without text or with a
+	// confidence below OCR_MIN_CONFIDENCE.
+	EscalateLowConfidence = "low_confidence"
+	// EscalateEmpty escalates only pages on which Tesseract found no text.
+	EscalateEmpty = "empty"
+	// EscalateNever keeps Tesseract's result for every page.
+	EscalateNever = "never"
+)
+
+// Engine reads the text of images locally.
+type Engine interface {
+	// Recognize returns the text of an encoded image (PNG, JPEG, TIFF, ...).
+	Recognize(ctx context.Context, imageData []byte) (*Result, error)
+}
+
+// Result is the text of an image with the engine's confidence in it, from 0
+// to 1.
+type Result struct {
+	Text       string
+	Confidence float64
+}
+
+// New returns the engine selected by cfg.TesseractMode, or nil when Tesseract
+// is off.
+func New(cfg config.OCRConfig) (Engine, error) {
+	switch strings.ToLower(cfg.EscalationPolicy) {
+	case "", EscalateLowConfidence, EscalateEmpty, EscalateNever:
+	default:
+		return nil, fmt.Errorf("unknown OCR escalation policy: %s", cfg.EscalationPolicy)
+	}
+
+	switch strings.ToLower(cfg.TesseractMode) {
+	case "", ModeOff:
+		return nil, nil
+	case ModePrimary, ModeFallback, ModeCompare:
+		tesseract, err := NewTesseract(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return tesseract, nil
+	default:
+		return nil, fmt.Errorf("unknown Tesseract mode: %s", cfg.TesseractMode)
+	}
+}
@@ -0,0 +1,135 @@
+package ocr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"document-embeddings/internal/config"
+)
+
+// Tesseract shells out to the tesseract command line tool.
+type Tesseract struct {
+	languages string
+	psm       int
+}
+
+// NewTesseract checks that tesseract is installed with every language in
+// cfg.TesseractLanguages ("+"-separated, e.g. "fas+eng").
+func NewTesseract(cfg config.OCRConfig) (*Tesseract, error) {
+	languages := cfg.TesseractLanguages
+	if languages == "" {
+		languages = "fas+eng"
+	}
+
+	psm := cfg.TesseractPSM
+	if psm == 0 {
+		psm = 3
+	}
+	if psm < 0 || psm > 13 {
+		return nil, fmt.Errorf("invalid Tesseract page segmentation mode: %d", psm)
+	}
+
+	output, err := exec.Command("tesseract", "--list-langs").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("tesseract is not available: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	installed := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		installed[strings.TrimSpace(line)] = true
+	}
+	for _, language := range strings.Split(languages, "+") {
+		if !installed[language] {
+			return nil, fmt.Errorf("tesseract language %q is not installed", language)
+		}
+	}
+
+	return &Tesseract{languages: languages, psm: psm}, nil
+}
+
+func (t *Tesseract) Recognize(ctx context.Context, imageData []byte) (*Result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "tesseract", "stdin", "stdout",
+		"-l", t.languages, "--psm", strconv.Itoa(t.psm), "tsv")
+	cmd.Stdin = bytes.NewReader(imageData)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Pages are already recognized in parallel; Tesseract's own threads
+	// would only compete with each other
+	cmd.Env = append(os.Environ(), "OMP_THREAD_LIMIT=1")
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseTSV(&stdout)
+}
+
+// parseTSV rebuilds the text from Tesseract's TSV output, one word per row,
+// breaking lines and paragraphs where Tesseract found them. The confidence is
+// the mean word confidence weighted by word length, so a misread stray mark
+// counts for less than a misread word.
+func parseTSV(r io.Reader) (*Result, error) {
+	const (
+		colLevel = 0
+		colBlock = 2
+		colPar   = 3
+		colLine  = 4
+		colConf  = 10
+		colText  = 11
+		wordRow  = "5"
+	)
+
+	var text strings.Builder
+	var weighted, weight float64
+	var lastPar, lastLine string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for row := 0; scanner.Scan(); row++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if row == 0 || len(fields) <= colText || fields[colLevel] != wordRow {
+			continue
+		}
+
+		word := strings.TrimSpace(fields[colText])
+		conf, err := strconv.ParseFloat(fields[colConf], 64)
+		if word == "" || err != nil || conf < 0 {
+			continue
+		}
+
+		par := fields[colBlock] + "." + fields[colPar]
+		line := par + "." + fields[colLine]
+		switch {
+		case text.Len() == 0:
+		case par != lastPar:
+			text.WriteString("\n\n")
+		case line != lastLine:
+			text.WriteString("\n")
+		default:
+			text.WriteString(" ")
+		}
+		text.WriteString(word)
+		lastPar, lastLine = par, line
+
+		n := float64(len([]rune(word)))
+		weighted += conf / 100 * n
+		weight += n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tesseract output: %w", err)
+	}
+
+	result := &Result{Text: text.String()}
+	if weight > 0 {
+		result.Confidence = weighted / weight
+	}
+	return result, nil
+}
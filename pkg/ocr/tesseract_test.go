@@ -0,0 +1,45 @@
+package ocr
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseTSV(t *testing.T) {
+	rows := []string{
+		"level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext",
+		"1\t1\t0\t0\t0\t0\t0\t0\t800\t600\t-1\t",
+		"4\t1\t1\t1\t1\t0\t10\t10\t200\t20\t-1\t",
+		"5\t1\t1\t1\t1\t1\t10\t10\t50\t20\t90\tگزارش",
+		"5\t1\t1\t1\t1\t2\t70\t10\t50\t20\t70\tسالانه",
+		"5\t1\t1\t1\t2\t1\t10\t40\t50\t20\t80\tline",
+		"5\t1\t1\t1\t2\t2\t70\t40\t50\t20\t-1\t ",
+		"5\t1\t2\t1\t1\t1\t10\t90\t50\t20\t100\tx",
+	}
+
+	result, err := parseTSV(strings.NewReader(strings.Join(rows, "\n") + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "گزارش سالانه\nline\n\nx"; result.Text != want {
+		t.Fatalf("text = %q, want %q", result.Text, want)
+	}
+
+	// Weighted by word length: 5 runes at 0.9, 6 at 0.7, 4 at 0.8, 1 at 1.0
+	want := (5*0.9 + 6*0.7 + 4*0.8 + 1*1.0) / 16
+	if math.Abs(result.Confidence-want) > 1e-9 {
+		t.Fatalf("confidence = %v, want %v", result.Confidence, want)
+	}
+}
+
+func TestParseTSVWithoutWords(t *testing.T) {
+	result, err := parseTSV(strings.NewReader("level\tpage_num\n1\t1\t0\t0\t0\t0\t0\t0\t800\t600\t-1\t\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "" || result.Confidence != 0 {
+		t.Fatalf("result = %+v, want no text and no confidence", result)
+	}
+}
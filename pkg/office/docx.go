@@ -0,0 +1,139 @@
+package office
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// extractDOCX walks word/document.xml in order, so headings, paragraphs and
+// tables keep their position in the text.
+func extractDOCX(zr *archive) (string, error) {
+	f, err := openFile(zr, "word/document.xml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var out blocks
+	var para strings.Builder
+	var style string
+	var listItem, inText, inTabStops bool
+
+	// Tables may nest; only the outermost one is rendered, nested cell text is
+	// folded into the enclosing cell.
+	var tableDepth int
+	var rows [][]string
+	var row []string
+	var cell []string
+
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tbl":
+				tableDepth++
+				if tableDepth == 1 {
+					rows = nil
+				}
+			case "tr":
+				if tableDepth == 1 {
+					row = nil
+				}
+			case "tc":
+				if tableDepth == 1 {
+					cell = nil
+				}
+			case "p":
+				para.Reset()
+				style = ""
+				listItem = false
+			case "pStyle":
+				style = attr(t.Attr, "val")
+			case "numPr":
+				listItem = true
+			case "t":
+				inText = true
+			case "tabs":
+				// Tab stop definitions in paragraph properties, not content
+				inTabStops = true
+			case "tab":
+				if !inTabStops {
+					para.WriteByte('\t')
+				}
+			case "br", "cr":
+				para.WriteByte('\n')
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "tabs":
+				inTabStops = false
+			case "p":
+				text := strings.TrimSpace(para.String())
+				if text == "" {
+					continue
+				}
+				if tableDepth > 0 {
+					cell = append(cell, text)
+					continue
+				}
+				if level := headingLevel(style); level > 0 {
+					text = heading(level, text)
+				} else if listItem {
+					text = "- " + text
+				}
+				out.add(text)
+			case "tc":
+				if tableDepth == 1 {
+					row = append(row, strings.Join(cell, " "))
+				}
+			case "tr":
+				if tableDepth == 1 {
+					rows = append(rows, row)
+				}
+			case "tbl":
+				tableDepth--
+				if tableDepth == 0 && len(rows) > 0 {
+					out.add(markdownTable(rows))
+				}
+			}
+
+		case xml.CharData:
+			if inText {
+				para.Write(t)
+			}
+		}
+	}
+
+	return out.String(), nil
+}
+
+// headingLevel maps Word's built-in style IDs ("Title", "Heading1"...) to a
+// Markdown heading level, or 0 for body text.
+func headingLevel(style string) int {
+	lower := strings.ToLower(style)
+	switch {
+	case lower == "title":
+		return 1
+	case lower == "subtitle":
+		return 2
+	case strings.HasPrefix(lower, "heading"):
+		if level, err := strconv.Atoi(strings.TrimPrefix(lower, "heading")); err == nil {
+			return level
+		}
+	}
+	return 0
+}
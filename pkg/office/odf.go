@@ -0,0 +1,174 @@
+package office
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const odfSpreadsheet = "application/vnd.oasis.opendocument.spreadsheet"
+
+// extractODF walks content.xml of an OpenDocument text, spreadsheet or
+// presentation. The three share one vocabulary (text:h, text:p, table:table,
+// draw:page, presentation:notes), so a single walker handles all of them.
+func extractODF(zr *archive) (string, error) {
+	mimeType, _ := readFile(zr, "mimetype")
+	spreadsheet := strings.TrimSpace(string(mimeType)) == odfSpreadsheet
+
+	f, err := openFile(zr, "content.xml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var out blocks
+	var para strings.Builder
+	var textDepth, listDepth, headingLevel, slide int
+	var inNotes bool
+	var notes []string
+
+	var tableDepth int
+	var tableName string
+	var rows [][]string
+	var row, cell []string
+	var rowRepeat, cellRepeat int
+
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "page":
+				slide++
+				out.add(heading(2, fmt.Sprintf("Slide %d", slide)))
+			case "notes":
+				inNotes = true
+				notes = nil
+			case "list-item":
+				listDepth++
+			case "table":
+				tableDepth++
+				if tableDepth == 1 {
+					tableName = attr(t.Attr, "name")
+					rows = nil
+				}
+			case "table-row":
+				if tableDepth == 1 {
+					row = nil
+					rowRepeat = repeatCount(attr(t.Attr, "number-rows-repeated"))
+				}
+			case "table-cell", "covered-table-cell":
+				if tableDepth == 1 {
+					cell = nil
+					cellRepeat = repeatCount(attr(t.Attr, "number-columns-repeated"))
+				}
+			case "h", "p":
+				if textDepth == 0 {
+					para.Reset()
+					headingLevel = 0
+					if t.Name.Local == "h" {
+						headingLevel = max(repeatCount(attr(t.Attr, "outline-level")), 1)
+					}
+				}
+				textDepth++
+			case "s":
+				para.WriteString(strings.Repeat(" ", repeatCount(attr(t.Attr, "c"))))
+			case "tab":
+				para.WriteByte('\t')
+			case "line-break":
+				para.WriteByte('\n')
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "notes":
+				inNotes = false
+				if len(notes) > 0 {
+					out.add("Speaker notes: " + strings.Join(notes, "\n"))
+				}
+			case "list-item":
+				listDepth--
+			case "h", "p":
+				textDepth--
+				if textDepth > 0 {
+					continue
+				}
+				text := strings.TrimSpace(para.String())
+				switch {
+				case text == "":
+				case tableDepth > 0:
+					cell = append(cell, text)
+				case inNotes:
+					notes = append(notes, text)
+				case headingLevel > 0:
+					out.add(heading(headingLevel, text))
+				case listDepth > 0:
+					out.add("- " + text)
+				default:
+					out.add(text)
+				}
+			case "table-cell", "covered-table-cell":
+				if tableDepth == 1 {
+					text := strings.Join(cell, " ")
+					// Trailing empty cells are often "repeated" thousands of times
+					if text == "" {
+						cellRepeat = 1
+					}
+					for i := 0; i < cellRepeat && len(row) < maxColumns; i++ {
+						row = append(row, text)
+					}
+				}
+			case "table-row":
+				if tableDepth == 1 {
+					if strings.TrimSpace(strings.Join(row, "")) == "" {
+						rowRepeat = 1
+					}
+					for i := 0; i < min(rowRepeat, maxRepeatedRows); i++ {
+						rows = append(rows, row)
+					}
+				}
+			case "table":
+				tableDepth--
+				if tableDepth > 0 {
+					continue
+				}
+				for len(rows) > 0 && strings.TrimSpace(strings.Join(rows[len(rows)-1], "")) == "" {
+					rows = rows[:len(rows)-1]
+				}
+				table := markdownTable(rows)
+				if table != "" && spreadsheet && tableName != "" {
+					table = heading(2, tableName) + "\n\n" + table
+				}
+				out.add(table)
+			}
+
+		case xml.CharData:
+			if textDepth > 0 {
+				para.Write(t)
+			}
+		}
+	}
+
+	return out.String(), nil
+}
+
+// maxRepeatedRows caps how often a repeated non-empty row is expanded.
+const maxRepeatedRows = 100
+
+func repeatCount(value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,278 @@
+// Package office extracts text from OOXML (docx, xlsx, pptx) and ODF (odt,
+// ods, odp) documents. Headings, paragraphs, tables and speaker notes are
+// rendered as Markdown; embedded images are returned separately so the caller
+// can send them to image analysis.
+package office
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Document is the extracted content of an office file.
+type Document struct {
+	Text   string
+	Images []Image
+}
+
+// Image is a picture embedded in an office file. Its content is only read
+// from the archive when asked for, so large presentations are not loaded
+// into memory image by image.
+type Image struct {
+	Name     string
+	MimeType string
+	Size     int64
+	file     *zip.File
+	maxSize  int64
+}
+
+// Data reads the image from the archive. Images larger than the maximum
+// entry size given to Extract are an error.
+func (i Image) Data() ([]byte, error) {
+	f, err := openEntry(i.file, i.maxSize)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Extract parses an office file of the given format ("docx", "xlsx", "pptx",
+// "odt", "ods" or "odp"). The archive is read through r, so the file does not
+// have to be held in memory. Archive entries, including images, larger than
+// maxEntrySize bytes uncompressed are not read; 0 means DefaultMaxEntrySize.
+func Extract(r io.ReaderAt, size int64, format string, maxEntrySize int64) (*Document, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s archive: %w", format, err)
+	}
+	if maxEntrySize <= 0 {
+		maxEntrySize = DefaultMaxEntrySize
+	}
+	zr := &archive{Reader: reader, maxEntrySize: maxEntrySize}
+
+	var text string
+	var mediaDir string
+	switch strings.ToLower(format) {
+	case "docx":
+		text, err = extractDOCX(zr)
+		mediaDir = "word/media/"
+	case "xlsx":
+		text, err = extractXLSX(zr)
+		mediaDir = "xl/media/"
+	case "pptx":
+		text, err = extractPPTX(zr)
+		mediaDir = "ppt/media/"
+	case "odt", "ods", "odp":
+		text, err = extractODF(zr)
+		mediaDir = "Pictures/"
+	default:
+		return nil, fmt.Errorf("unsupported office format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{Text: strings.TrimSpace(text), Images: mediaImages(zr, mediaDir)}, nil
+}
+
+// DefaultMaxEntrySize is the largest archive entry Extract reads when no
+// limit is given.
+const DefaultMaxEntrySize = 100 << 20
+
+// archive is an office file's zip archive. No entry is read beyond
+// maxEntrySize bytes, so a small, highly compressed upload cannot expand
+// into gigabytes of memory.
+type archive struct {
+	*zip.Reader
+	maxEntrySize int64
+}
+
+func openFile(zr *archive, name string) (io.ReadCloser, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("missing %s: %w", name, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if header, ok := info.Sys().(*zip.FileHeader); ok {
+		if err := checkEntrySize(header, zr.maxEntrySize); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return limitEntry(f, name, zr.maxEntrySize), nil
+}
+
+func readFile(zr *archive, name string) ([]byte, error) {
+	f, err := openFile(zr, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// openEntry opens an archive entry that is at most maxSize bytes
+// uncompressed.
+func openEntry(f *zip.File, maxSize int64) (io.ReadCloser, error) {
+	if err := checkEntrySize(&f.FileHeader, maxSize); err != nil {
+		return nil, err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return limitEntry(rc, f.Name, maxSize), nil
+}
+
+func checkEntrySize(header *zip.FileHeader, maxSize int64) error {
+	if header.UncompressedSize64 > uint64(maxSize) {
+		return fmt.Errorf("%s is too large: %d bytes uncompressed, at most %d allowed", header.Name, header.UncompressedSize64, maxSize)
+	}
+	return nil
+}
+
+// limitEntry fails reading once more than maxSize bytes come out of an
+// entry: the size in its header can lie.
+func limitEntry(rc io.ReadCloser, name string, maxSize int64) io.ReadCloser {
+	return &limitedEntry{
+		Reader:  io.LimitReader(rc, maxSize+1),
+		Closer:  rc,
+		name:    name,
+		maxSize: maxSize,
+	}
+}
+
+type limitedEntry struct {
+	io.Reader
+	io.Closer
+	name    string
+	maxSize int64
+	read    int64
+}
+
+func (e *limitedEntry) Read(p []byte) (int, error) {
+	n, err := e.Reader.Read(p)
+	e.read += int64(n)
+	if e.read > e.maxSize {
+		return 0, fmt.Errorf("%s is too large: more than %d bytes uncompressed", e.name, e.maxSize)
+	}
+	return n, err
+}
+
+var imageMimeTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".bmp":  "image/bmp",
+	".webp": "image/webp",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+}
+
+// mediaImages returns the raster images stored under dir, sorted by name.
+// Vector formats such as EMF/WMF are skipped since vision models cannot read
+// them.
+func mediaImages(zr *archive, dir string) []Image {
+	var images []Image
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, dir) || f.FileInfo().IsDir() {
+			continue
+		}
+
+		mimeType, ok := imageMimeTypes[strings.ToLower(path.Ext(f.Name))]
+		if !ok {
+			continue
+		}
+
+		images = append(images, Image{
+			Name:     path.Base(f.Name),
+			MimeType: mimeType,
+			Size:     int64(f.UncompressedSize64),
+			file:     f,
+			maxSize:  zr.maxEntrySize,
+		})
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].Name < images[j].Name })
+	return images
+}
+
+// resolveTarget resolves a relationship target relative to the part that
+// declares it, e.g. "../notesSlides/notesSlide1.xml" from "ppt/slides".
+func resolveTarget(baseDir, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	return path.Join(baseDir, target)
+}
+
+// markdownTable renders rows as a Markdown table using the first row as the
+// header. Empty trailing columns are dropped.
+func markdownTable(rows [][]string) string {
+	width := 0
+	for _, row := range rows {
+		for i := len(row) - 1; i >= 0; i-- {
+			if strings.TrimSpace(row[i]) != "" {
+				width = max(width, i+1)
+				break
+			}
+		}
+	}
+	if width == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	writeRow := func(row []string) {
+		b.WriteString("|")
+		for i := 0; i < width; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = escapeCell(row[i])
+			}
+			b.WriteString(" " + cell + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	writeRow(rows[0])
+	b.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func escapeCell(cell string) string {
+	cell = strings.TrimSpace(cell)
+	cell = strings.ReplaceAll(cell, "|", `\|`)
+	return strings.Join(strings.Fields(strings.ReplaceAll(cell, "\n", " ")), " ")
+}
+
+func heading(level int, text string) string {
+	level = min(max(level, 1), 6)
+	return strings.Repeat("#", level) + " " + text
+}
+
+// blocks joins non-empty Markdown blocks with blank lines.
+type blocks []string
+
+func (b *blocks) add(block string) {
+	if block = strings.TrimSpace(block); block != "" {
+		*b = append(*b, block)
+	}
+}
+
+func (b blocks) String() string {
+	return strings.Join(b, "\n\n")
+}
@@ -0,0 +1,207 @@
+package office_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"document-embeddings/pkg/office"
+)
+
+const (
+	wordNS   = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	sheetNS  = `xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"`
+	slideNS  = `xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"`
+	relNS    = `xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+	relsOpen = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	odfNS    = `xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0"`
+)
+
+// buildArchive zips files, in the order given as name, content pairs.
+func buildArchive(t *testing.T, files ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func extract(t *testing.T, data []byte, format string, maxEntrySize int64) (*office.Document, error) {
+	t.Helper()
+	return office.Extract(bytes.NewReader(data), int64(len(data)), format, maxEntrySize)
+}
+
+const wantDocument = "# Report\n\nRevenue grew.\n\n- First item\n\n| Region | Sales |\n| --- | --- |\n| North | 12 |"
+
+func TestExtractRendersMarkdown(t *testing.T) {
+	tests := []struct {
+		format string
+		files  []string
+		want   string
+	}{
+		{
+			format: "docx",
+			files: []string{"word/document.xml", `<w:document ` + wordNS + `><w:body>
+				<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Report</w:t></w:r></w:p>
+				<w:p><w:r><w:t>Revenue grew.</w:t></w:r></w:p>
+				<w:p><w:pPr><w:numPr/></w:pPr><w:r><w:t>First item</w:t></w:r></w:p>
+				<w:tbl>
+					<w:tr><w:tc><w:p><w:r><w:t>Region</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Sales</w:t></w:r></w:p></w:tc></w:tr>
+					<w:tr><w:tc><w:p><w:r><w:t>North</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>12</w:t></w:r></w:p></w:tc></w:tr>
+				</w:tbl>
+			</w:body></w:document>`},
+			want: wantDocument,
+		},
+		{
+			format: "xlsx",
+			files: []string{
+				"xl/workbook.xml", `<workbook ` + sheetNS + ` ` + relNS + `><sheets><sheet name="Sales" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+				"xl/_rels/workbook.xml.rels", relsOpen + `<Relationship Id="rId1" Type="worksheet" Target="worksheets/sheet1.xml"/></Relationships>`,
+				"xl/sharedStrings.xml", `<sst ` + sheetNS + `><si><t>Region</t></si><si><r><t>Sal</t></r><r><t>es</t></r></si></sst>`,
+				"xl/worksheets/sheet1.xml", `<worksheet ` + sheetNS + `><sheetData>
+					<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+					<row r="2"><c r="A2" t="inlineStr"><is><t>North</t></is></c><c r="B2"><v>12</v></c></row>
+				</sheetData></worksheet>`,
+			},
+			want: "## Sales\n\n| Region | Sales |\n| --- | --- |\n| North | 12 |",
+		},
+		{
+			format: "pptx",
+			files: []string{
+				"ppt/presentation.xml", `<p:presentation ` + slideNS + ` ` + relNS + `><p:sldIdLst><p:sldId id="256" r:id="rId2"/></p:sldIdLst></p:presentation>`,
+				"ppt/_rels/presentation.xml.rels", relsOpen + `<Relationship Id="rId2" Type="slide" Target="slides/slide1.xml"/></Relationships>`,
+				"ppt/slides/slide1.xml", `<p:sld ` + slideNS + `><p:cSld><p:spTree>
+					<p:sp><p:nvSpPr><p:nvPr><p:ph type="title"/></p:nvPr></p:nvSpPr><p:txBody><a:p><a:r><a:t>Results</a:t></a:r></a:p></p:txBody></p:sp>
+					<p:sp><p:txBody><a:p><a:r><a:t>Sales rose.</a:t></a:r></a:p></p:txBody></p:sp>
+					<p:sp><p:nvSpPr><p:nvPr><p:ph type="sldNum"/></p:nvPr></p:nvSpPr><p:txBody><a:p><a:r><a:t>1</a:t></a:r></a:p></p:txBody></p:sp>
+				</p:spTree></p:cSld></p:sld>`,
+				"ppt/slides/_rels/slide1.xml.rels", relsOpen + `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/notesSlide" Target="../notesSlides/notesSlide1.xml"/></Relationships>`,
+				"ppt/notesSlides/notesSlide1.xml", `<p:notes ` + slideNS + `><p:cSld><p:spTree>
+					<p:sp><p:nvSpPr><p:nvPr><p:ph type="body" idx="1"/></p:nvPr></p:nvSpPr><p:txBody><a:p><a:r><a:t>Mention March.</a:t></a:r></a:p></p:txBody></p:sp>
+				</p:spTree></p:cSld></p:notes>`,
+			},
+			want: "## Slide 1: Results\n\nSales rose.\n\nSpeaker notes: Mention March.",
+		},
+		{
+			format: "odt",
+			files: []string{
+				"mimetype", "application/vnd.oasis.opendocument.text",
+				"content.xml", `<office:document-content ` + odfNS + `><office:body><office:text>
+					<text:h text:outline-level="1">Report</text:h>
+					<text:p>Revenue<text:s/>grew.</text:p>
+					<text:list><text:list-item><text:p>First item</text:p></text:list-item></text:list>
+					<table:table table:name="Table1">
+						<table:table-row><table:table-cell><text:p>Region</text:p></table:table-cell><table:table-cell><text:p>Sales</text:p></table:table-cell></table:table-row>
+						<table:table-row><table:table-cell><text:p>North</text:p></table:table-cell><table:table-cell><text:p>12</text:p></table:table-cell></table:table-row>
+					</table:table>
+				</office:text></office:body></office:document-content>`,
+			},
+			want: wantDocument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			doc, err := extract(t, buildArchive(t, tt.files...), tt.format, 0)
+			if err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			if doc.Text != tt.want {
+				t.Fatalf("text =\n%s\nwant\n%s", doc.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractReturnsRasterImages(t *testing.T) {
+	data := buildArchive(t,
+		"word/document.xml", `<w:document `+wordNS+`><w:body><w:p><w:r><w:t>Chart below.</w:t></w:r></w:p></w:body></w:document>`,
+		"word/media/image2.png", "second",
+		"word/media/image1.jpeg", "first",
+		"word/media/drawing.emf", "vector",
+	)
+
+	doc, err := extract(t, data, "docx", 0)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if len(doc.Images) != 2 || doc.Images[0].Name != "image1.jpeg" || doc.Images[0].MimeType != "image/jpeg" || doc.Images[1].Name != "image2.png" {
+		t.Fatalf("images = %+v, want the JPEG and the PNG in name order", doc.Images)
+	}
+	if content, err := doc.Images[0].Data(); err != nil || string(content) != "first" {
+		t.Fatalf("Data = %q, %v", content, err)
+	}
+}
+
+func TestExtractRejectsEntriesOverTheLimit(t *testing.T) {
+	body := strings.Repeat("<w:p><w:r><w:t>Revenue grew.</w:t></w:r></w:p>", 100)
+	document := `<w:document ` + wordNS + `><w:body>` + body + `</w:body></w:document>`
+
+	data := buildArchive(t, "word/document.xml", document)
+	if _, err := extract(t, data, "docx", 1024); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("Extract with a 1 KiB limit = %v, want the document rejected", err)
+	}
+	if _, err := extract(t, data, "docx", int64(len(document))); err != nil {
+		t.Fatalf("Extract with a limit of the document size: %v", err)
+	}
+
+	// Images are checked when they are read
+	data = buildArchive(t,
+		"word/document.xml", `<w:document `+wordNS+`><w:body/></w:document>`,
+		"word/media/image1.png", strings.Repeat("x", 2048),
+	)
+	doc, err := extract(t, data, "docx", 1024)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if _, err := doc.Images[0].Data(); err == nil {
+		t.Fatal("Data of an image over the limit succeeded")
+	}
+}
+
+func TestExtractRejectsEntriesThatUnderstateTheirSize(t *testing.T) {
+	document := `<w:document ` + wordNS + `><w:body>` + strings.Repeat("<w:p><w:r><w:t>Revenue grew.</w:t></w:r></w:p>", 1000) + `</w:body></w:document>`
+
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write([]byte(document))
+	fw.Close()
+
+	// The header claims a few bytes, but the entry inflates to far more
+	// than the limit
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "word/document.xml",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE([]byte(document)),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressed.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := extract(t, buf.Bytes(), "docx", 1024); err == nil {
+		t.Fatal("Extract of an entry larger than its header says succeeded")
+	}
+}
@@ -0,0 +1,199 @@
+package office
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// extractPPTX renders slides in presentation order: the title placeholder
+// becomes the slide heading, other shapes and tables follow, and speaker
+// notes are appended to the slide.
+func extractPPTX(zr *archive) (string, error) {
+	data, err := readFile(zr, "ppt/presentation.xml")
+	if err != nil {
+		return "", err
+	}
+
+	var presentation struct {
+		Slides []struct {
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	if err := xml.Unmarshal(data, &presentation); err != nil {
+		return "", err
+	}
+
+	rels, err := readRels(zr, "ppt/presentation.xml")
+	if err != nil {
+		return "", err
+	}
+
+	var out blocks
+	for i, slideRef := range presentation.Slides {
+		rel, ok := rels[relID(slideRef.Attrs)]
+		if !ok {
+			continue
+		}
+
+		slide, err := parseSlide(zr, rel.Target)
+		if err != nil {
+			return "", err
+		}
+
+		title := fmt.Sprintf("Slide %d", i+1)
+		if slide.title != "" {
+			title += ": " + slide.title
+		}
+
+		var content blocks
+		content.add(heading(2, title))
+		for _, block := range slide.body {
+			content.add(block)
+		}
+
+		notes, err := slideNotes(zr, rel.Target)
+		if err != nil {
+			return "", err
+		}
+		if notes != "" {
+			content.add("Speaker notes: " + notes)
+		}
+
+		out.add(content.String())
+	}
+
+	return out.String(), nil
+}
+
+type slideContent struct {
+	title string
+	body  []string
+}
+
+// parseSlide collects the text of a slide (or notes) part. Shapes are
+// classified by their placeholder type; tables inside graphic frames are
+// rendered as Markdown.
+func parseSlide(zr *archive, part string) (*slideContent, error) {
+	f, err := openFile(zr, part)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	content := &slideContent{}
+
+	var placeholder string
+	var shapeParas []string
+	var para strings.Builder
+	var inText, inTable bool
+	var rows [][]string
+	var row, cell []string
+
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "sp":
+				placeholder = ""
+				shapeParas = nil
+			case "ph":
+				placeholder = attr(t.Attr, "type")
+				if placeholder == "" {
+					placeholder = "body"
+				}
+			case "tbl":
+				inTable = true
+				rows = nil
+			case "tr":
+				row = nil
+			case "tc":
+				cell = nil
+			case "p":
+				para.Reset()
+			case "t":
+				inText = true
+			case "br":
+				para.WriteByte('\n')
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := strings.TrimSpace(para.String())
+				if text == "" {
+					continue
+				}
+				if inTable {
+					cell = append(cell, text)
+				} else {
+					shapeParas = append(shapeParas, text)
+				}
+			case "tc":
+				row = append(row, strings.Join(cell, " "))
+			case "tr":
+				rows = append(rows, row)
+			case "tbl":
+				inTable = false
+				if table := markdownTable(rows); table != "" {
+					content.body = append(content.body, table)
+				}
+			case "sp":
+				text := strings.Join(shapeParas, "\n")
+				switch placeholder {
+				case "title", "ctrTitle":
+					content.title = strings.Join(strings.Fields(text), " ")
+				case "sldNum", "dt", "ftr", "hdr", "sldImg":
+					// Slide furniture carries no content
+				default:
+					if text != "" {
+						content.body = append(content.body, text)
+					}
+				}
+				placeholder = ""
+				shapeParas = nil
+			}
+
+		case xml.CharData:
+			if inText {
+				para.Write(t)
+			}
+		}
+	}
+
+	return content, nil
+}
+
+// slideNotes returns the speaker notes of a slide, if it has any.
+func slideNotes(zr *archive, slidePart string) (string, error) {
+	rels, err := readRels(zr, slidePart)
+	if err != nil {
+		return "", err
+	}
+
+	for _, rel := range rels {
+		if path.Base(rel.Type) != "notesSlide" {
+			continue
+		}
+
+		notes, err := parseSlide(zr, rel.Target)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(notes.body, "\n"), nil
+	}
+	return "", nil
+}
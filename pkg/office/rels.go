@@ -0,0 +1,57 @@
+package office
+
+import (
+	"encoding/xml"
+	"path"
+)
+
+type relationship struct {
+	ID     string `xml:"Id,attr"`
+	Type   string `xml:"Type,attr"`
+	Target string `xml:"Target,attr"`
+}
+
+// readRels loads the relationships of an OOXML part, keyed by ID, with
+// targets resolved to archive paths. A part without relationships yields an
+// empty map.
+func readRels(zr *archive, part string) (map[string]relationship, error) {
+	dir, file := path.Split(part)
+	data, err := readFile(zr, path.Join(dir, "_rels", file+".rels"))
+	if err != nil {
+		return map[string]relationship{}, nil
+	}
+
+	var doc struct {
+		Relationships []relationship `xml:"Relationship"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	rels := make(map[string]relationship, len(doc.Relationships))
+	for _, rel := range doc.Relationships {
+		rel.Target = resolveTarget(path.Clean(dir), rel.Target)
+		rels[rel.ID] = rel
+	}
+	return rels, nil
+}
+
+// relID returns the value of an r:id style attribute, i.e. an "id" attribute
+// in the relationships namespace rather than a plain "id".
+func relID(attrs []xml.Attr) string {
+	for _, attr := range attrs {
+		if attr.Name.Local == "id" && attr.Name.Space != "" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func attr(attrs []xml.Attr, local string) string {
+	for _, attr := range attrs {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
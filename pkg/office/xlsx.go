@@ -0,0 +1,197 @@
+package office
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// extractXLSX renders every worksheet, in workbook order, as a Markdown table
+// under a heading with the sheet name.
+func extractXLSX(zr *archive) (string, error) {
+	data, err := readFile(zr, "xl/workbook.xml")
+	if err != nil {
+		return "", err
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(data, &workbook); err != nil {
+		return "", err
+	}
+
+	rels, err := readRels(zr, "xl/workbook.xml")
+	if err != nil {
+		return "", err
+	}
+
+	shared, err := sharedStrings(zr)
+	if err != nil {
+		return "", err
+	}
+
+	var out blocks
+	for _, sheet := range workbook.Sheets {
+		rel, ok := rels[relID(sheet.Attrs)]
+		if !ok {
+			continue
+		}
+
+		rows, err := sheetRows(zr, rel.Target, shared)
+		if err != nil {
+			return "", err
+		}
+
+		if table := markdownTable(rows); table != "" {
+			out.add(heading(2, sheet.Name) + "\n\n" + table)
+		}
+	}
+
+	return out.String(), nil
+}
+
+func sharedStrings(zr *archive) ([]string, error) {
+	data, err := readFile(zr, "xl/sharedStrings.xml")
+	if err != nil {
+		// Workbooks without text cells have no shared string table
+		return nil, nil
+	}
+
+	var table struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := xml.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+
+	stringsTable := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		text := item.Text
+		for _, run := range item.Runs {
+			text += run.Text
+		}
+		stringsTable[i] = text
+	}
+	return stringsTable, nil
+}
+
+// maxColumns bounds the grid width so a stray cell far to the right cannot
+// blow up the rendered table.
+const maxColumns = 512
+
+// sheetRows reads a worksheet into a dense grid; cells are placed by their
+// reference ("C7") because empty cells are omitted from the XML.
+func sheetRows(zr *archive, part string, shared []string) ([][]string, error) {
+	f, err := openFile(zr, part)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows [][]string
+	var row []string
+	var cellRef, cellType string
+	var value strings.Builder
+	var inValue bool
+
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = nil
+			case "c":
+				cellRef = attr(t.Attr, "r")
+				cellType = attr(t.Attr, "t")
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				col := columnIndex(cellRef)
+				if col < 0 {
+					col = len(row)
+				}
+				if col >= maxColumns {
+					continue
+				}
+				for len(row) <= col {
+					row = append(row, "")
+				}
+				row[col] = cellValue(value.String(), cellType, shared)
+			case "row":
+				rows = append(rows, row)
+			}
+
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+
+	// Drop trailing empty rows
+	for len(rows) > 0 && strings.TrimSpace(strings.Join(rows[len(rows)-1], "")) == "" {
+		rows = rows[:len(rows)-1]
+	}
+	return rows, nil
+}
+
+func cellValue(raw, cellType string, shared []string) string {
+	switch cellType {
+	case "s":
+		if index, err := strconv.Atoi(raw); err == nil && index >= 0 && index < len(shared) {
+			return shared[index]
+		}
+		return ""
+	case "b":
+		if raw == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	default:
+		return raw
+	}
+}
+
+// columnIndex converts the letters of a cell reference to a zero-based
+// column index ("A1" -> 0, "AB3" -> 27), or -1 if there are none.
+func columnIndex(ref string) int {
+	index := 0
+	letters := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		index = index*26 + int(r-'A'+1)
+		letters++
+	}
+	if letters == 0 {
+		return -1
+	}
+	return index - 1
+}
@@ -0,0 +1,95 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"document-embeddings/pkg/prompts"
+	"document-embeddings/pkg/provider"
+)
+
+const answerSchemaName = "document_answer"
+
+// answerSource is a source as the answer prompt sees it.
+type answerSource struct {
+	Number int
+	Title  string
+	Text   string
+}
+
+// Answer sends the question and its sources with the answer prompt to the
+// chat model. The reply must match provider.AnswerSchema; unlike image
+// analysis, a reply that does not even after a repair attempt is an error,
+// since its citations cannot be trusted.
+func (c *Client) Answer(ctx context.Context, req provider.AnswerRequest) (*provider.Answer, error) {
+	prompt, err := c.answerPrompt(prompts.Answer, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var answer provider.Answer
+	messages := []ChatMessage{textMessage("user", prompt)}
+	if _, err := c.chatJSON(ctx, c.chatModel, messages, "", answerSchemaName, provider.AnswerSchema, &answer); err != nil {
+		return nil, err
+	}
+
+	answer.Text = strings.TrimSpace(answer.Text)
+	return &answer, nil
+}
+
+// StreamAnswer sends the question and its sources with the answer_stream
+// prompt to the chat model and passes the reply on as it arrives.
+func (c *Client) StreamAnswer(ctx context.Context, req provider.AnswerRequest, onDelta func(delta string) error) (string, error) {
+	prompt, err := c.answerPrompt(prompts.AnswerStream, req)
+	if err != nil {
+		return "", err
+	}
+
+	chatReq := ChatRequest{
+		Model:     c.chatModel,
+		Messages:  []ChatMessage{textMessage("user", prompt)},
+		MaxTokens: chatMaxTokens,
+		Stream:    true,
+	}
+
+	var text strings.Builder
+	err = c.makeStreamRequest(ctx, "/chat/completions", chatReq, func(data []byte) error {
+		var chunk ChatStreamChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("failed to decode OpenAI stream: %v", err)
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+
+		delta := chunk.Choices[0].Delta
+		if delta.Refusal != "" {
+			return fmt.Errorf("model refused the request: %s", delta.Refusal)
+		}
+		if delta.Content == "" {
+			return nil
+		}
+		text.WriteString(delta.Content)
+		return onDelta(delta.Content)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(text.String()), nil
+}
+
+func (c *Client) answerPrompt(name string, req provider.AnswerRequest) (string, error) {
+	sources := make([]answerSource, len(req.Sources))
+	for i, source := range req.Sources {
+		sources[i] = answerSource{Number: i + 1, Title: source.Title, Text: source.Text}
+	}
+
+	return c.prompts.Render(name, "", map[string]interface{}{
+		"Question": req.Question,
+		"Sources":  sources,
+		"Language": c.prompts.Language(),
+	})
+}
@@ -0,0 +1,166 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"document-embeddings/pkg/jsonschema"
+	"document-embeddings/pkg/prompts"
+)
+
+// Kinds of structured output, weakest first.
+const (
+	structuredOff int32 = iota
+	structuredJSONObject
+	structuredJSONSchema
+)
+
+const chatMaxTokens = 4000
+
+// chat sends messages to model and returns the reply. The reply is
+// constrained to schema as far as the API supports: a json_schema response
+// format where available, else json_object. When the API rejects a response
+// format, the request is repeated with the next weaker one, which is then
+// used for all later requests.
+func (c *Client) chat(ctx context.Context, model string, messages []ChatMessage, schemaName string, schema *jsonschema.Schema) (string, error) {
+	for {
+		level := c.structuredOutput.Load()
+		req := ChatRequest{
+			Model:          model,
+			Messages:       messages,
+			MaxTokens:      chatMaxTokens,
+			ResponseFormat: responseFormat(level, schemaName, schema),
+		}
+
+		var resp ChatResponse
+		err := c.makeRequest(ctx, "POST", "/chat/completions", req, &resp)
+
+		var apiErr *APIError
+		if level > structuredOff && errors.As(err, &apiErr) && rejectsResponseFormat(apiErr) {
+			c.structuredOutput.CompareAndSwap(level, level-1)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if len(resp.Choices) == 0 {
+			return "", fmt.Errorf("no response from OpenAI")
+		}
+		message := resp.Choices[0].Message
+		if message.Refusal != "" {
+			return "", fmt.Errorf("model refused the request: %s", message.Refusal)
+		}
+		return message.Content, nil
+	}
+}
+
+func responseFormat(level int32, schemaName string, schema *jsonschema.Schema) *ResponseFormat {
+	switch {
+	case schema == nil || level == structuredOff:
+		return nil
+	case level == structuredJSONObject:
+		return &ResponseFormat{Type: "json_object"}
+	default:
+		return &ResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &JSONSchemaFormat{Name: schemaName, Strict: true, Schema: schema},
+		}
+	}
+}
+
+// rejectsResponseFormat reports whether the API refused a request because it
+// does not support the response format it asked for.
+func rejectsResponseFormat(err *APIError) bool {
+	if err.StatusCode != http.StatusBadRequest && err.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	message := strings.ToLower(err.Message)
+	return strings.Contains(message, "response_format") || strings.Contains(message, "json_schema")
+}
+
+// chatJSON asks for a JSON reply matching schema and decodes it into v. A
+// reply that is not valid JSON or does not match the schema is sent back
+// once with a repair prompt. If the repaired reply is not valid either, the
+// last reply is returned with the validation error.
+func (c *Client) chatJSON(ctx context.Context, model string, messages []ChatMessage, documentType, schemaName string, schema *jsonschema.Schema, v interface{}) (string, error) {
+	content, err := c.chat(ctx, model, messages, schemaName, schema)
+	if err != nil {
+		return "", err
+	}
+
+	invalid := decodeJSON(content, schema, v)
+	if invalid == nil {
+		return content, nil
+	}
+
+	prompt, err := c.repairPrompt(documentType, content, schema, invalid)
+	if err != nil {
+		return content, err
+	}
+	repaired, err := c.chat(ctx, model, []ChatMessage{textMessage("user", prompt)}, schemaName, schema)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return content, &InvalidResponseError{Err: invalid}
+	}
+
+	if invalid := decodeJSON(repaired, schema, v); invalid != nil {
+		return repaired, &InvalidResponseError{Err: invalid}
+	}
+	return repaired, nil
+}
+
+// InvalidResponseError is returned when a model's reply does not match the
+// schema it was asked for, even after a repair attempt.
+type InvalidResponseError struct {
+	Err error
+}
+
+func (e *InvalidResponseError) Error() string {
+	return "invalid model response: " + e.Err.Error()
+}
+
+func (e *InvalidResponseError) Unwrap() error {
+	return e.Err
+}
+
+func (c *Client) repairPrompt(documentType, content string, schema *jsonschema.Schema, problem error) (string, error) {
+	schemaJSON, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return c.prompts.Render(prompts.Repair, documentType, map[string]interface{}{
+		"Error":    problem.Error(),
+		"Response": content,
+		"Schema":   string(schemaJSON),
+		"Language": c.prompts.Language(),
+	})
+}
+
+// decodeJSON validates a reply against schema and decodes it into v.
+// Markdown code fences around the JSON are ignored.
+func decodeJSON(content string, schema *jsonschema.Schema, v interface{}) error {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(content, "```")
+		content = strings.TrimSpace(content)
+	}
+
+	if err := schema.Validate([]byte(content)); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(content), v)
+}
+
+func textMessage(role, text string) ChatMessage {
+	return ChatMessage{Role: role, Content: []ContentPart{{Type: "text", Text: text}}}
+}
@@ -0,0 +1,562 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"document-embeddings/internal/config"
+	"document-embeddings/pkg/openai"
+	"document-embeddings/pkg/openai/openaitest"
+	"document-embeddings/pkg/prompts"
+	"document-embeddings/pkg/provider"
+)
+
+func TestClientAgainstStandIn(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+
+	cfg := srv.Config()
+	client := openai.New(cfg, nil)
+	ctx := context.Background()
+
+	texts := []string{"first text", "second text"}
+	got, err := client.GenerateEmbeddings(ctx, texts)
+	if err != nil {
+		t.Fatalf("GenerateEmbeddings: %v", err)
+	}
+	want, _ := srv.Provider.GenerateEmbeddings(ctx, texts)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("embeddings = %v, want %v", got, want)
+	}
+
+	image := provider.ImageInput{Data: []byte("not really a png"), MimeType: "image/png"}
+	text, err := client.ExtractTextFromImage(ctx, image)
+	if err != nil {
+		t.Fatalf("ExtractTextFromImage: %v", err)
+	}
+	analysis, _ := srv.Provider.AnalyzeImage(ctx, image)
+	if text != analysis.Text() {
+		t.Fatalf("text = %q, want %q", text, analysis.Text())
+	}
+
+	if models := srv.Requests(openaitest.EmbeddingsPath); !reflect.DeepEqual(models, []string{cfg.Model}) {
+		t.Fatalf("embedding requests used %v, want %s", models, cfg.Model)
+	}
+	if models := srv.Requests(openaitest.ChatPath); !reflect.DeepEqual(models, []string{cfg.VisionModel}) {
+		t.Fatalf("chat requests used %v, want %s", models, cfg.VisionModel)
+	}
+}
+
+func TestEmbeddingDimensionsAreOnlySentWhenSet(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+
+	for _, send := range []bool{false, true} {
+		cfg := srv.Config()
+		cfg.SendEmbeddingDimensions = send
+		if _, err := openai.New(cfg, nil).GenerateEmbeddings(context.Background(), []string{"text"}); err != nil {
+			t.Fatalf("GenerateEmbeddings: %v", err)
+		}
+	}
+
+	bodies := srv.Bodies(openaitest.EmbeddingsPath)
+	var req map[string]interface{}
+	json.Unmarshal(bodies[0], &req)
+	if _, ok := req["dimensions"]; ok {
+		t.Fatalf("request %s has dimensions, want them left to the model", bodies[0])
+	}
+	json.Unmarshal(bodies[1], &req)
+	if req["dimensions"] != float64(8) {
+		t.Fatalf("request %s, want 8 dimensions", bodies[1])
+	}
+}
+
+func TestClientReportsAPIErrors(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+
+	cfg := srv.Config()
+	cfg.APIKey = "wrong"
+	if _, err := openai.New(cfg, nil).GenerateEmbeddings(context.Background(), []string{"text"}); err == nil {
+		t.Fatal("request with a wrong API key succeeded")
+	}
+}
+
+// flakyServer answers the embeddings endpoint with the given responses in
+// turn, then with embeddings, and records the body of every request.
+type flakyServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter)
+	bodies    []string
+}
+
+func newFlakyServer(t *testing.T, responses ...func(w http.ResponseWriter)) *flakyServer {
+	s := &flakyServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		var respond func(w http.ResponseWriter)
+		if len(s.responses) > 0 {
+			respond, s.responses = s.responses[0], s.responses[1:]
+		}
+		s.mu.Unlock()
+
+		if respond != nil {
+			respond(w)
+			return
+		}
+		w.Write([]byte(`{"data": [{"embedding": [1, 0], "index": 0}]}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *flakyServer) client(maxRetries int, baseDelay, maxDelay time.Duration) *openai.Client {
+	return openai.New(config.OpenAIConfig{
+		BaseURL:        s.URL,
+		Model:          "test-embedding",
+		MaxRetries:     maxRetries,
+		RetryBaseDelay: baseDelay,
+		RetryMaxDelay:  maxDelay,
+	}, nil)
+}
+
+func fail(status int, header map[string]string, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for key, value := range header {
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestRetriesResendTheRequestBody(t *testing.T) {
+	srv := newFlakyServer(t,
+		fail(http.StatusInternalServerError, nil, ""),
+		fail(http.StatusTooManyRequests, nil, `{"error": {"message": "slow down", "type": "rate_limit"}}`),
+		fail(http.StatusBadGateway, nil, "bad gateway"),
+	)
+
+	if _, err := srv.client(3, time.Millisecond, 10*time.Millisecond).GenerateEmbeddings(context.Background(), []string{"text"}); err != nil {
+		t.Fatalf("GenerateEmbeddings: %v", err)
+	}
+
+	if len(srv.bodies) != 4 {
+		t.Fatalf("%d attempts, want 4", len(srv.bodies))
+	}
+	for i, body := range srv.bodies {
+		if body == "" || body != srv.bodies[0] {
+			t.Fatalf("attempt %d sent %q, want %q", i+1, body, srv.bodies[0])
+		}
+	}
+}
+
+func TestRetriesHonorRetryAfter(t *testing.T) {
+	srv := newFlakyServer(t, fail(http.StatusTooManyRequests, map[string]string{"retry-after-ms": "100"}, ""))
+
+	start := time.Now()
+	if _, err := srv.client(1, time.Millisecond, time.Second).GenerateEmbeddings(context.Background(), []string{"text"}); err != nil {
+		t.Fatalf("GenerateEmbeddings: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("retried after %v, want at least the 100ms asked for", elapsed)
+	}
+}
+
+func TestRetriesGiveUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		response func(w http.ResponseWriter)
+		attempts int
+		status   int
+		message  string
+	}{
+		{
+			name:     "after max retries",
+			response: fail(http.StatusServiceUnavailable, nil, `{"error": {"message": "overloaded", "type": "server_error", "code": null}}`),
+			attempts: 3, status: http.StatusServiceUnavailable, message: "overloaded",
+		},
+		{
+			name:     "on client errors",
+			response: fail(http.StatusBadRequest, nil, `{"error": {"message": "input too long", "type": "invalid_request_error", "code": "context_length_exceeded"}}`),
+			attempts: 1, status: http.StatusBadRequest, message: "input too long",
+		},
+		{
+			name:     "when Retry-After is longer than the maximum delay",
+			response: fail(http.StatusTooManyRequests, map[string]string{"Retry-After": "3600"}, `{"error": {"message": "quota exceeded"}}`),
+			attempts: 1, status: http.StatusTooManyRequests, message: "quota exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFlakyServer(t, tt.response, tt.response, tt.response)
+
+			_, err := srv.client(2, time.Millisecond, time.Second).GenerateEmbeddings(context.Background(), []string{"text"})
+
+			var apiErr *openai.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want an APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Message != tt.message {
+				t.Fatalf("error = %+v, want status %d and message %q", apiErr, tt.status, tt.message)
+			}
+			if len(srv.bodies) != tt.attempts {
+				t.Fatalf("%d attempts, want %d", len(srv.bodies), tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetriesStopWhenTheContextIsCanceled(t *testing.T) {
+	srv := newFlakyServer(t, fail(http.StatusInternalServerError, nil, ""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := srv.client(3, time.Hour, time.Hour).GenerateEmbeddings(ctx, []string{"text"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the context's error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("gave up after %v, want as soon as the context ended", elapsed)
+	}
+}
+
+const validAnalysis = `{"summary": "A receipt", "raw_text_content": "Total 12", "metadata": {"image_type": "Receipt", "colors": ["White", "Beige"], "objects_detected": ["receipt"], "mood": "", "quality": "Sharp"}}`
+
+// chatRequest decodes the parts of a chat request the tests look at.
+func chatRequest(t *testing.T, body json.RawMessage) (prompt string, hasImage bool, format *openai.ResponseFormat) {
+	t.Helper()
+	var req openai.ChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range req.Messages[0].Content {
+		switch part.Type {
+		case "text":
+			prompt = part.Text
+		case "image_url":
+			hasImage = true
+		}
+	}
+	return prompt, hasImage, req.ResponseFormat
+}
+
+func TestAnalyzeImageUsesStructuredOutput(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+	srv.Reply(validAnalysis)
+
+	analysis, err := openai.New(srv.Config(), nil).AnalyzeImage(context.Background(), provider.ImageInput{Data: []byte("img"), MimeType: "image/png", DocumentType: "png"})
+	if err != nil {
+		t.Fatalf("AnalyzeImage: %v", err)
+	}
+	want := &provider.ImageAnalysis{
+		Summary:        "A receipt",
+		RawTextContent: "Total 12",
+		Metadata: provider.ImageMetadata{
+			ImageType:       "Receipt",
+			Colors:          []string{"White", "Beige"},
+			ObjectsDetected: []string{"receipt"},
+			Quality:         "Sharp",
+		},
+	}
+	if !reflect.DeepEqual(analysis, want) {
+		t.Fatalf("analysis = %+v, want %+v", analysis, want)
+	}
+
+	_, _, format := chatRequest(t, srv.Bodies(openaitest.ChatPath)[0])
+	if format == nil || format.Type != "json_schema" || !format.JSONSchema.Strict || !reflect.DeepEqual(format.JSONSchema.Schema, provider.ImageAnalysisSchema) {
+		t.Fatalf("response format = %+v, want the strict analysis schema", format)
+	}
+}
+
+func TestAnalyzeImageRepairsInvalidReplies(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+	srv.Reply(`{"summary": "A receipt", "metadata": {"colors": "White"}}`, "```json\n"+validAnalysis+"\n```")
+
+	analysis, err := openai.New(srv.Config(), nil).AnalyzeImage(context.Background(), provider.ImageInput{Data: []byte("img"), MimeType: "image/png"})
+	if err != nil {
+		t.Fatalf("AnalyzeImage: %v", err)
+	}
+	if analysis.Text() != "Total 12" {
+		t.Fatalf("analysis = %+v, want the repaired reply", analysis)
+	}
+
+	bodies := srv.Bodies(openaitest.ChatPath)
+	if len(bodies) != 2 {
+		t.Fatalf("%d chat requests, want the analysis and one repair", len(bodies))
+	}
+	prompt, hasImage, _ := chatRequest(t, bodies[1])
+	if hasImage || !strings.Contains(prompt, `missing property "raw_text_content"`) {
+		t.Fatalf("repair request has image %v and prompt %q, want the validation error without the image", hasImage, prompt)
+	}
+}
+
+func TestAnalyzeImageDegradesAfterFailedRepair(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+	srv.Reply("The image shows a receipt.", "Sorry, it shows a receipt.")
+
+	analysis, err := openai.New(srv.Config(), nil).AnalyzeImage(context.Background(), provider.ImageInput{Data: []byte("img"), MimeType: "image/png"})
+	if err != nil {
+		t.Fatalf("AnalyzeImage: %v", err)
+	}
+	if analysis.Summary != "Sorry, it shows a receipt." || analysis.ResponseError == "" {
+		t.Fatalf("analysis = %+v, want the last reply with the error", analysis)
+	}
+}
+
+func TestExtractTextFromImageReturnsOnlyTheText(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+	srv.Reply(`{"summary": "A blank page", "raw_text_content": "", "metadata": {"image_type": "Document", "colors": [], "objects_detected": [], "mood": "", "quality": "Sharp"}}`)
+
+	client := openai.New(srv.Config(), nil)
+	text, err := client.ExtractTextFromImage(context.Background(), provider.ImageInput{Data: []byte("img"), MimeType: "image/png"})
+	if err != nil || text != "" {
+		t.Fatalf("ExtractTextFromImage = %q, %v, want no text for a page without any", text, err)
+	}
+
+	// An invalid reply is an error, not text
+	srv.Reply("The image shows a receipt.", "Sorry, it shows a receipt.")
+	_, err = client.ExtractTextFromImage(context.Background(), provider.ImageInput{Data: []byte("img"), MimeType: "image/png"})
+	var invalid *openai.InvalidResponseError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("ExtractTextFromImage error = %v, want an InvalidResponseError", err)
+	}
+}
+
+func TestAnswerNumbersSourcesAndRequiresTheSchema(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+	srv.Reply(`{"answered": true, "answer": "It was cleared in March [2].", "sources": [2]}`)
+
+	client := openai.New(srv.Config(), nil)
+	req := provider.AnswerRequest{
+		Question: "When was the backlog cleared?",
+		Sources: []provider.Source{
+			{Title: "report.pdf, page 1", Text: "Revenue grew."},
+			{Title: "report.pdf, page 2", Text: "The backlog was cleared in March."},
+		},
+	}
+	answer, err := client.Answer(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Answer: %v", err)
+	}
+	if want := (&provider.Answer{Answered: true, Text: "It was cleared in March [2].", Sources: []int{2}}); !reflect.DeepEqual(answer, want) {
+		t.Fatalf("answer = %+v, want %+v", answer, want)
+	}
+
+	prompt, _, format := chatRequest(t, srv.Bodies(openaitest.ChatPath)[0])
+	if !strings.Contains(prompt, "[2] report.pdf, page 2\nThe backlog was cleared in March.") || !strings.Contains(prompt, req.Question) {
+		t.Fatalf("prompt %q does not number the sources and ask the question", prompt)
+	}
+	if format == nil || !reflect.DeepEqual(format.JSONSchema.Schema, provider.AnswerSchema) {
+		t.Fatalf("response format = %+v, want the answer schema", format)
+	}
+	if models := srv.Requests(openaitest.ChatPath); models[0] != srv.Config().ChatModel {
+		t.Fatalf("answer request used model %q, want %q", models[0], srv.Config().ChatModel)
+	}
+
+	// Citations of a reply that cannot be repaired are not trusted
+	srv.Reply("It was cleared in March.", "Still not JSON.")
+	var invalid *openai.InvalidResponseError
+	if _, err := client.Answer(context.Background(), req); !errors.As(err, &invalid) {
+		t.Fatalf("Answer with invalid replies = %v, want InvalidResponseError", err)
+	}
+}
+
+func TestStreamAnswerPassesTheReplyOnAsItArrives(t *testing.T) {
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+	srv.Reply("It was cleared in March [2].")
+
+	client := openai.New(srv.Config(), nil)
+	req := provider.AnswerRequest{
+		Question: "When was the backlog cleared?",
+		Sources:  []provider.Source{{Title: "report.pdf, page 2", Text: "The backlog was cleared in March."}},
+	}
+
+	var deltas []string
+	text, err := client.StreamAnswer(context.Background(), req, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamAnswer: %v", err)
+	}
+	if text != "It was cleared in March [2]." || strings.Join(deltas, "") != text || len(deltas) < 2 {
+		t.Fatalf("StreamAnswer = %q in %q, want the reply in pieces", text, deltas)
+	}
+
+	var body struct {
+		Stream         bool            `json:"stream"`
+		ResponseFormat json.RawMessage `json:"response_format"`
+	}
+	json.Unmarshal(srv.Bodies(openaitest.ChatPath)[0], &body)
+	if !body.Stream || body.ResponseFormat != nil {
+		t.Fatalf("request = %+v, want a streamed plain text reply", body)
+	}
+
+	// An error from onDelta ends the answer
+	stop := errors.New("client left")
+	srv.Reply("It was cleared in March [2].")
+	if _, err := client.StreamAnswer(context.Background(), req, func(string) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("StreamAnswer with failing onDelta = %v, want %v", err, stop)
+	}
+}
+
+func TestBrokenStreamsAreNotRetried(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices": [{"delta": {"content": "It was"}}]}`+"\n\n")
+	}))
+	defer srv.Close()
+
+	cfg := config.OpenAIConfig{APIKey: "key", BaseURL: srv.URL, ChatModel: "test-chat", MaxRetries: 3}
+	client := openai.New(cfg, nil)
+
+	var text string
+	_, err := client.StreamAnswer(context.Background(), provider.AnswerRequest{Question: "When?"}, func(delta string) error {
+		text += delta
+		return nil
+	})
+	if err == nil || text != "It was" {
+		t.Fatalf("StreamAnswer = %v after %q, want an error after the first piece", err, text)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("%d requests, want 1", n)
+	}
+}
+
+func TestOnlyStreamsOutlastTheRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+
+		// The reply starts at once but takes longer than the timeout
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range []string{"It was", " cleared."} {
+			fmt.Fprintf(w, `data: {"choices": [{"delta": {"content": %q}}]}`+"\n\n", piece)
+			w.(http.Flusher).Flush()
+			time.Sleep(150 * time.Millisecond)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	cfg := config.OpenAIConfig{APIKey: "key", BaseURL: srv.URL, ChatModel: "test-chat", RequestTimeout: 100 * time.Millisecond}
+	client := openai.New(cfg, nil)
+	req := provider.AnswerRequest{Question: "When?"}
+
+	var text string
+	if _, err := client.StreamAnswer(context.Background(), req, func(delta string) error {
+		text += delta
+		return nil
+	}); err != nil || text != "It was cleared." {
+		t.Fatalf("StreamAnswer = %v after %q, want the whole reply", err, text)
+	}
+
+	if _, err := client.Answer(context.Background(), req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Answer = %v, want it to time out", err)
+	}
+}
+
+func TestUnsupportedResponseFormatsAreDowngraded(t *testing.T) {
+	var mu sync.Mutex
+	var formats []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		format := "none"
+		if req.ResponseFormat != nil {
+			format = req.ResponseFormat.Type
+		}
+		mu.Lock()
+		formats = append(formats, format)
+		mu.Unlock()
+
+		if format == "json_schema" {
+			fail(http.StatusBadRequest, nil, `{"error": {"message": "Invalid parameter: 'response_format' of type 'json_schema' is not supported with this model."}}`)(w)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"message": map[string]string{"content": validAnalysis}}},
+		})
+	}))
+	defer srv.Close()
+
+	client := openai.New(config.OpenAIConfig{BaseURL: srv.URL, VisionModel: "test-vision"}, nil)
+	for i := 0; i < 2; i++ {
+		if _, err := client.ExtractTextFromImage(context.Background(), provider.ImageInput{Data: []byte("img"), MimeType: "image/png"}); err != nil {
+			t.Fatalf("ExtractTextFromImage: %v", err)
+		}
+	}
+
+	if want := []string{"json_schema", "json_object", "json_object"}; !reflect.DeepEqual(formats, want) {
+		t.Fatalf("response formats = %v, want %v", formats, want)
+	}
+}
+
+func TestPromptTemplatesPerDocumentTypeAndLanguage(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"image_analysis.fa.tmpl":     "Persian prompt for {{.DocumentType}}",
+		"image_analysis.pdf.fa.tmpl": "Persian page prompt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templates, err := prompts.Load(config.PromptConfig{Dir: dir, Language: "fa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := openaitest.NewServer(8)
+	defer srv.Close()
+	client := openai.New(srv.Config(), templates)
+
+	for documentType, want := range map[string]string{"pdf": "Persian page prompt", "png": "Persian prompt for png"} {
+		srv.Reply(validAnalysis)
+		if _, err := client.AnalyzeImage(context.Background(), provider.ImageInput{Data: []byte("img"), MimeType: "image/png", DocumentType: documentType}); err != nil {
+			t.Fatalf("AnalyzeImage: %v", err)
+		}
+		bodies := srv.Bodies(openaitest.ChatPath)
+		if prompt, _, _ := chatRequest(t, bodies[len(bodies)-1]); prompt != want {
+			t.Fatalf("%s prompt = %q, want %q", documentType, prompt, want)
+		}
+	}
+}
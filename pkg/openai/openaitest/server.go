@@ -0,0 +1,329 @@
+// Package openaitest provides an in-process stand-in for the OpenAI API, so
+// code using openai.Client can be tested offline. Answers come from a
+// provider.Fake and are deterministic, unless replies are queued with Reply.
+package openaitest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"document-embeddings/internal/config"
+	"document-embeddings/pkg/openai"
+	"document-embeddings/pkg/provider"
+)
+
+const (
+	EmbeddingsPath = "/embeddings"
+	ChatPath       = "/chat/completions"
+
+	// APIKey is the key Config returns; requests without it get 401.
+	APIKey = "test-key"
+
+	// SummaryWords is the length of the replies to chat requests without
+	// an image: the first words of the prompt.
+	SummaryWords = 20
+)
+
+// Server serves the embeddings and chat completions endpoints.
+type Server struct {
+	*httptest.Server
+	Provider *provider.Fake
+
+	mu       sync.Mutex
+	requests map[string][]json.RawMessage
+	failures map[string]int
+	replies  []string
+}
+
+// NewServer starts a server returning embeddings of the given size. Close it
+// when done.
+func NewServer(dimensions int) *Server {
+	s := &Server{
+		Provider: provider.NewFake(dimensions),
+		requests: make(map[string][]json.RawMessage),
+		failures: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(EmbeddingsPath, s.handle(s.embeddings))
+	mux.HandleFunc(ChatPath, s.handle(s.chat))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns a client configuration pointing at the server.
+func (s *Server) Config() config.OpenAIConfig {
+	return config.OpenAIConfig{
+		APIKey:              APIKey,
+		BaseURL:             s.URL,
+		Model:               "test-embedding",
+		VisionModel:         "test-vision",
+		ChatModel:           "test-chat",
+		EmbeddingDimensions: s.Provider.Dimensions,
+	}
+}
+
+// Fail makes every request to path fail with status until it is called
+// again with status 0.
+func (s *Server) Fail(path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.failures, path)
+		return
+	}
+	s.failures[path] = status
+}
+
+// Reply queues chat completion replies. Chat requests are answered with
+// them, in order, before the fake provider is asked.
+func (s *Server) Reply(contents ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies = append(s.replies, contents...)
+}
+
+// Requests returns the model named by each request made to path so far.
+func (s *Server) Requests(path string) []string {
+	var models []string
+	for _, body := range s.Bodies(path) {
+		var head struct {
+			Model string `json:"model"`
+		}
+		json.Unmarshal(body, &head)
+		models = append(models, head.Model)
+	}
+	return models
+}
+
+// Bodies returns the body of each request made to path so far.
+func (s *Server) Bodies(path string) []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]json.RawMessage(nil), s.requests[path]...)
+}
+
+// handle decodes a request, records it and applies injected failures before
+// calling serve.
+func (s *Server) handle(serve func(ctx context.Context, body []byte) (interface{}, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+APIKey {
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+
+		s.mu.Lock()
+		s.requests[r.URL.Path] = append(s.requests[r.URL.Path], body)
+		status := s.failures[r.URL.Path]
+		s.mu.Unlock()
+
+		if status != 0 {
+			writeError(w, status, "injected failure")
+			return
+		}
+
+		resp, status, err := serve(r.Context(), body)
+		if err != nil {
+			writeError(w, status, err.Error())
+			return
+		}
+		if reply, ok := resp.(streamReply); ok {
+			writeStream(w, string(reply))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (s *Server) embeddings(ctx context.Context, body []byte) (interface{}, int, error) {
+	var req openai.EmbeddingRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	embeddings, err := s.Provider.GenerateEmbeddings(ctx, req.Input)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	var resp openai.EmbeddingResponse
+	for i, embedding := range embeddings {
+		resp.Data = append(resp.Data, struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		}{Embedding: embedding, Index: i})
+		resp.Usage.PromptTokens += len(strings.Fields(req.Input[i]))
+	}
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens
+	return resp, http.StatusOK, nil
+}
+
+// streamReply is the content of a reply to be streamed.
+type streamReply string
+
+// chat answers with the next queued reply. Otherwise image analysis
+// requests get the fake provider's analysis, and requests without an image
+// the fake provider's summary of the prompt. Requests with stream set get
+// the reply as server-sent events, a word at a time.
+func (s *Server) chat(ctx context.Context, body []byte) (interface{}, int, error) {
+	var req openai.ChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	if req.Stream {
+		if reply, ok := s.nextReply(); ok {
+			return streamReply(reply), http.StatusOK, nil
+		}
+		summary, err := s.Provider.Summarize(ctx, provider.SummaryRequest{Text: promptText(req), MaxWords: SummaryWords})
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return streamReply(summary), http.StatusOK, nil
+	}
+
+	var resp openai.ChatResponse
+	resp.Choices = make([]struct {
+		Message struct {
+			Content string `json:"content"`
+			Refusal string `json:"refusal,omitempty"`
+		} `json:"message"`
+	}, 1)
+
+	if reply, ok := s.nextReply(); ok {
+		resp.Choices[0].Message.Content = reply
+		return resp, http.StatusOK, nil
+	}
+
+	if !hasImage(req) {
+		summary, err := s.Provider.Summarize(ctx, provider.SummaryRequest{Text: promptText(req), MaxWords: SummaryWords})
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		resp.Choices[0].Message.Content = summary
+		return resp, http.StatusOK, nil
+	}
+
+	image, mimeType, err := findImage(req)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	analysis, err := s.Provider.AnalyzeImage(ctx, provider.ImageInput{Data: image, MimeType: mimeType})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	content, err := json.Marshal(analysis)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	resp.Choices[0].Message.Content = string(content)
+	return resp, http.StatusOK, nil
+}
+
+// nextReply takes the next queued reply, if any.
+func (s *Server) nextReply() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.replies) == 0 {
+		return "", false
+	}
+	reply := s.replies[0]
+	s.replies = s.replies[1:]
+	return reply, true
+}
+
+func hasImage(req openai.ChatRequest) bool {
+	for _, message := range req.Messages {
+		for _, part := range message.Content {
+			if part.Type == "image_url" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// promptText returns the text parts of a chat request.
+func promptText(req openai.ChatRequest) string {
+	var parts []string
+	for _, message := range req.Messages {
+		for _, part := range message.Content {
+			if part.Type == "text" {
+				parts = append(parts, part.Text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// findImage returns the first image of a chat request, which must be given
+// as a base64 data URL.
+func findImage(req openai.ChatRequest) ([]byte, string, error) {
+	for _, message := range req.Messages {
+		for _, part := range message.Content {
+			if part.Type != "image_url" || part.ImageURL == nil {
+				continue
+			}
+
+			header, data, ok := strings.Cut(strings.TrimPrefix(part.ImageURL.URL, "data:"), ",")
+			mimeType, isBase64 := strings.CutSuffix(header, ";base64")
+			if !ok || !isBase64 {
+				return nil, "", fmt.Errorf("image must be a base64 data URL")
+			}
+			image, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid image data: %v", err)
+			}
+			return image, mimeType, nil
+		}
+	}
+	return nil, "", fmt.Errorf("request has no image")
+}
+
+// writeStream sends content as a streamed chat completion, one chunk per
+// word, followed by [DONE].
+func writeStream(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	for _, word := range strings.SplitAfter(content, " ") {
+		if word == "" {
+			continue
+		}
+		chunk, _ := json.Marshal(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"delta": map[string]string{"content": word}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": message},
+	})
+}
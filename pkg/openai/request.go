@@ -0,0 +1,259 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxErrorBody caps how much of an error response is read.
+	maxErrorBody = 64 << 10
+	// maxStreamLine caps the length of a line of a streamed response.
+	maxStreamLine = 1 << 20
+)
+
+// APIError is returned when the API answers with a status other than 200 OK.
+type APIError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+	// RetryAfter is how long the API asked the client to wait before the
+	// next request, or 0 if it did not say.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("OpenAI API error: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("OpenAI API error: %d: %s", e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the request may succeed when repeated: the API
+// was rate limited or failed on its side.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// makeRequest sends body as JSON and decodes the response into response.
+// Rate limits (429), server errors and network errors are retried up to
+// maxRetries times with exponential backoff, or after the delay the API asks
+// for in Retry-After. A Retry-After longer than retryMaxDelay is not waited
+// for; the APIError is returned so the caller can retry later. Each attempt
+// gives up after requestTimeout.
+func (c *Client) makeRequest(ctx context.Context, method, endpoint string, body interface{}, response interface{}) error {
+	return c.send(ctx, method, endpoint, body, c.requestTimeout, func(r io.Reader) error {
+		if err := json.NewDecoder(r).Decode(response); err != nil {
+			return fmt.Errorf("failed to decode OpenAI response: %w", err)
+		}
+		return nil
+	})
+}
+
+// makeStreamRequest sends body as JSON and calls onData with the data of
+// each server-sent event of the response, until the API sends [DONE].
+// Failed requests are retried as by makeRequest, but once events have been
+// passed on a failure ends the request: they cannot be taken back. A reply
+// can take as long as the model writes, so only its headers have to arrive
+// within requestTimeout; the rest is bounded by ctx alone.
+func (c *Client) makeStreamRequest(ctx context.Context, endpoint string, body interface{}, onData func(data []byte) error) error {
+	return c.send(ctx, "POST", endpoint, body, 0, func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64<<10), maxStreamLine)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				return nil
+			}
+			if err := onData([]byte(data)); err != nil {
+				return err
+			}
+		}
+		// Not wrapped: a broken stream must not look like a network error
+		// that can be retried
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read OpenAI stream: %v", err)
+		}
+		return fmt.Errorf("OpenAI stream ended without [DONE]")
+	})
+}
+
+// send makes the request, with retries, and passes the body of a 200 OK
+// response to read. A timeout other than 0 bounds each attempt.
+func (c *Client) send(ctx context.Context, method, endpoint string, body interface{}, timeout time.Duration, read func(io.Reader) error) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, method, endpoint, payload, timeout, read)
+		if err == nil {
+			return nil
+		}
+
+		delay, retry := c.retryDelay(ctx, err, attempt)
+		if !retry || attempt >= c.maxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w while waiting to retry: %v", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// do makes a single attempt. The request is built from payload each time,
+// since a sent request's body cannot be read again.
+func (c *Client) do(ctx context.Context, method, endpoint string, payload []byte, timeout time.Duration, read func(io.Reader) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Drain what is left so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+
+	return read(resp.Body)
+}
+
+// retryDelay returns how long to wait before repeating a request that failed
+// with err, and whether to repeat it at all.
+func (c *Client) retryDelay(ctx context.Context, err error, attempt int) (time.Duration, bool) {
+	if ctx.Err() != nil {
+		return 0, false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if !apiErr.Retryable() {
+			return 0, false
+		}
+		if apiErr.RetryAfter > 0 {
+			return apiErr.RetryAfter, apiErr.RetryAfter <= c.retryMaxDelay
+		}
+		return c.backoff(attempt), true
+	}
+
+	// Connection failures and timeouts; a response that cannot be decoded
+	// is not retried
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return c.backoff(attempt), true
+	}
+	return 0, false
+}
+
+// backoff returns retryBaseDelay * 2^attempt, capped at retryMaxDelay, with
+// up to 20% jitter so clients that failed together do not retry together.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.retryBaseDelay
+	for i := 0; i < attempt && delay < c.retryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, c.retryMaxDelay)
+
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	}
+	return delay
+}
+
+// newAPIError reads the error the API returned. OpenAI-compatible APIs
+// describe it as {"error": {"message": ..., "type": ..., "code": ...}}; any
+// other body is used as the message as is.
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	var body struct {
+		Error struct {
+			Message string      `json:"message"`
+			Type    string      `json:"type"`
+			Code    interface{} `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &body); err == nil && body.Error.Message != "" {
+		apiErr.Message = body.Error.Message
+		apiErr.Type = body.Error.Type
+		if body.Error.Code != nil {
+			apiErr.Code = fmt.Sprint(body.Error.Code)
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+
+	return apiErr
+}
+
+// parseRetryAfter reads the delay from retry-after-ms, which some
+// OpenAI-compatible APIs send, or from Retry-After in seconds or as a date.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return max(time.Duration(seconds*float64(time.Second)), 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"document-embeddings/pkg/prompts"
+	"document-embeddings/pkg/provider"
+)
+
+// Summarize sends the text with the summary prompt for its document type, or
+// the summary_combine prompt when req.Combine is set, to the chat model.
+func (c *Client) Summarize(ctx context.Context, req provider.SummaryRequest) (string, error) {
+	name := prompts.Summary
+	if req.Combine {
+		name = prompts.SummaryCombine
+	}
+
+	documentType := req.DocumentType
+	if documentType == "" {
+		documentType = "text"
+	}
+	prompt, err := c.prompts.Render(name, req.DocumentType, map[string]interface{}{
+		"Text":         req.Text,
+		"DocumentType": documentType,
+		"MaxWords":     req.MaxWords,
+		"Language":     req.Language,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	content, err := c.chat(ctx, c.chatModel, []ChatMessage{textMessage("user", prompt)}, "", nil)
+	if err != nil {
+		return "", err
+	}
+
+	summary := strings.TrimSpace(content)
+	if summary == "" {
+		return "", fmt.Errorf("empty summary from OpenAI")
+	}
+	return summary, nil
+}
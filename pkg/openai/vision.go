@@ -0,0 +1,67 @@
+package openai
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"document-embeddings/pkg/prompts"
+	"document-embeddings/pkg/provider"
+)
+
+const analysisSchemaName = "image_analysis"
+
+// ExtractTextFromImage returns the text the image analysis transcribed. An
+// image without text has none: unlike Text, this does not fall back to the
+// summary, which is no transcription. A reply that does not match the schema
+// is an InvalidResponseError, so it is never taken for the image's text.
+func (c *Client) ExtractTextFromImage(ctx context.Context, image provider.ImageInput) (string, error) {
+	analysis, err := c.AnalyzeImage(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	if analysis.ResponseError != "" {
+		return "", &InvalidResponseError{Err: errors.New(analysis.ResponseError)}
+	}
+
+	return analysis.RawTextContent, nil
+}
+
+// AnalyzeImage sends the image with the image_analysis prompt for its
+// document type. A reply that does not match provider.ImageAnalysisSchema
+// even after a repair attempt is kept as the summary, with the problem in
+// ResponseError, rather than failing the image.
+func (c *Client) AnalyzeImage(ctx context.Context, image provider.ImageInput) (*provider.ImageAnalysis, error) {
+	prompt, err := c.prompts.Render(prompts.ImageAnalysis, image.DocumentType, map[string]interface{}{
+		"DocumentType": image.DocumentType,
+		"MimeType":     image.MimeType,
+		"Language":     c.prompts.Language(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	imageURL := "data:" + image.MimeType + ";base64," + base64.StdEncoding.EncodeToString(image.Data)
+	messages := []ChatMessage{{
+		Role: "user",
+		Content: []ContentPart{
+			{Type: "text", Text: prompt},
+			{Type: "image_url", ImageURL: &ImageURL{URL: imageURL}},
+		},
+	}}
+
+	var analysis provider.ImageAnalysis
+	content, err := c.chatJSON(ctx, c.visionModel, messages, image.DocumentType, analysisSchemaName, provider.ImageAnalysisSchema, &analysis)
+
+	var invalid *InvalidResponseError
+	if errors.As(err, &invalid) {
+		return &provider.ImageAnalysis{Summary: content, ResponseError: invalid.Err.Error()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	analysis.RawTextContent = strings.TrimSpace(analysis.RawTextContent)
+	return &analysis, nil
+}
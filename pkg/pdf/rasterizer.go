@@ -0,0 +1,178 @@
+package pdf
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"document-embeddings/internal/config"
+)
+
+// Rasterizer renders PDF pages to images.
+type Rasterizer interface {
+	// Rasterize renders every page of the PDF at pdfPath into outputDir and
+	// returns the image paths ordered by page number. outputDir must be
+	// private to the caller; it is scanned for the rendered pages.
+	Rasterize(ctx context.Context, pdfPath, outputDir string) ([]string, error)
+	// MimeType is the MIME type of the rendered images.
+	MimeType() string
+}
+
+// NewRasterizer returns the rasterizer selected by cfg.Rasterizer:
+// "imagemagick" (convert), "pdftoppm" (poppler) or "mutool" (MuPDF).
+func NewRasterizer(cfg config.PDFConfig) (Rasterizer, error) {
+	format, err := newImageFormat(cfg.ImageFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	dpi := cfg.DPI
+	if dpi <= 0 {
+		dpi = 150
+	}
+
+	switch strings.ToLower(cfg.Rasterizer) {
+	case "", "imagemagick", "convert":
+		return &imageMagick{dpi: dpi, format: format}, nil
+	case "pdftoppm", "poppler":
+		return &pdftoppm{dpi: dpi, format: format}, nil
+	case "mutool", "mupdf":
+		return &mutool{dpi: dpi, format: format}, nil
+	default:
+		return nil, fmt.Errorf("unknown PDF rasterizer: %s", cfg.Rasterizer)
+	}
+}
+
+type imageFormat struct {
+	ext      string
+	mimeType string
+}
+
+func newImageFormat(name string) (imageFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "png":
+		return imageFormat{ext: "png", mimeType: "image/png"}, nil
+	case "jpg", "jpeg":
+		return imageFormat{ext: "jpg", mimeType: "image/jpeg"}, nil
+	default:
+		return imageFormat{}, fmt.Errorf("unsupported PDF image format: %s", name)
+	}
+}
+
+const pagePrefix = "page-"
+
+// imageMagick shells out to ImageMagick's convert (Ghostscript under the hood).
+type imageMagick struct {
+	dpi    int
+	format imageFormat
+}
+
+func (r *imageMagick) Rasterize(ctx context.Context, pdfPath, outputDir string) ([]string, error) {
+	output := filepath.Join(outputDir, pagePrefix+"%04d."+r.format.ext)
+	args := []string{"-density", strconv.Itoa(r.dpi), pdfPath}
+	if r.format.ext == "jpg" {
+		// JPEG has no alpha channel; flatten transparent pages onto white
+		args = append(args, "-background", "white", "-alpha", "remove")
+	}
+	args = append(args, output)
+
+	if err := run(ctx, "convert", args...); err != nil {
+		return nil, err
+	}
+	return collectPages(outputDir, r.format.ext)
+}
+
+func (r *imageMagick) MimeType() string { return r.format.mimeType }
+
+// pdftoppm shells out to poppler's pdftoppm.
+type pdftoppm struct {
+	dpi    int
+	format imageFormat
+}
+
+func (r *pdftoppm) Rasterize(ctx context.Context, pdfPath, outputDir string) ([]string, error) {
+	formatFlag := "-png"
+	if r.format.ext == "jpg" {
+		formatFlag = "-jpeg"
+	}
+
+	// pdftoppm appends "-<page>.<ext>" to the output root itself
+	root := filepath.Join(outputDir, strings.TrimSuffix(pagePrefix, "-"))
+	if err := run(ctx, "pdftoppm", formatFlag, "-r", strconv.Itoa(r.dpi), pdfPath, root); err != nil {
+		return nil, err
+	}
+	return collectPages(outputDir, r.format.ext)
+}
+
+func (r *pdftoppm) MimeType() string { return r.format.mimeType }
+
+// mutool shells out to MuPDF's mutool draw.
+type mutool struct {
+	dpi    int
+	format imageFormat
+}
+
+func (r *mutool) Rasterize(ctx context.Context, pdfPath, outputDir string) ([]string, error) {
+	output := filepath.Join(outputDir, pagePrefix+"%04d."+r.format.ext)
+	if err := run(ctx, "mutool", "draw", "-q", "-r", strconv.Itoa(r.dpi), "-o", output, pdfPath); err != nil {
+		return nil, err
+	}
+	return collectPages(outputDir, r.format.ext)
+}
+
+func (r *mutool) MimeType() string { return r.format.mimeType }
+
+func run(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// collectPages returns the rendered page images in outputDir ordered by the
+// page number embedded in their names. The tools disagree on numbering (0- or
+// 1-based, padded or not), so the numbers are parsed rather than sorted as
+// strings, which would put page-10 before page-2.
+func collectPages(outputDir, ext string) ([]string, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	type page struct {
+		number int
+		path   string
+	}
+
+	var pages []page
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, pagePrefix) || !strings.HasSuffix(name, "."+ext) {
+			continue
+		}
+
+		number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, pagePrefix), "."+ext))
+		if err != nil {
+			continue
+		}
+		pages = append(pages, page{number: number, path: filepath.Join(outputDir, name)})
+	}
+
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no pages rendered")
+	}
+
+	sort.Slice(pages, func(i, j int) bool { return pages[i].number < pages[j].number })
+
+	paths := make([]string, len(pages))
+	for i, p := range pages {
+		paths[i] = p.path
+	}
+	return paths, nil
+}
@@ -0,0 +1,56 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"unicode"
+)
+
+// ExtractTextLayer returns the embedded text of every page, in page order,
+// using poppler's pdftotext. Scanned pages come back empty.
+func ExtractTextLayer(ctx context.Context, pdfPath string) ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pdftotext", "-enc", "UTF-8", pdfPath, "-")
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pdftotext failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// pdftotext ends every page with a form feed
+	pages := strings.Split(string(output), "\f")
+	if len(pages) > 0 && strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+
+	for i := range pages {
+		pages[i] = strings.TrimSpace(pages[i])
+	}
+	return pages, nil
+}
+
+// TextQuality reports how many non-space characters text has and which
+// fraction of them is plausible text. Replacement characters, control
+// characters and private-use glyphs, which broken font encodings produce,
+// count against the quality.
+func TextQuality(text string) (chars int, quality float64) {
+	var bad int
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		chars++
+		if r == unicode.ReplacementChar || unicode.IsControl(r) || unicode.Is(unicode.Co, r) {
+			bad++
+		}
+	}
+
+	if chars == 0 {
+		return 0, 0
+	}
+	return chars, float64(chars-bad) / float64(chars)
+}
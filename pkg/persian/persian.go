@@ -0,0 +1,186 @@
+// Package persian normalizes Persian/Arabic text coming out of OCR and turns
+// it into search terms. Postgres ships no Persian dictionary, so stopword
+// removal and stemming happen here and the resulting terms are indexed with
+// the plain "persian" text search configuration created in init.sql.
+package persian
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const zwnj = '\u200c'
+
+// Normalize unifies character variants so that text which looks identical
+// also compares identical:
+//   - Arabic presentation forms are folded by NFKC
+//   - Arabic ي/ى/ك/ة are mapped to Persian ی/ک/ه
+//   - Persian and Arabic-Indic digits become ASCII digits
+//   - diacritics (harakat) and tatweel are removed
+//   - zero-width characters other than ZWNJ are dropped and stray or repeated
+//     ZWNJs are cleaned up
+//   - runs of horizontal whitespace collapse to one space and more than one
+//     blank line collapses to a single blank line
+func Normalize(text string) string {
+	text = norm.NFKC.String(text)
+
+	runes := make([]rune, 0, len(text))
+	for _, r := range text {
+		if mapped, ok := mapRune(r); ok {
+			runes = append(runes, mapped)
+		}
+	}
+
+	return collapseWhitespace(cleanZWNJ(runes))
+}
+
+func mapRune(r rune) (rune, bool) {
+	switch {
+	case r == 'ي' || r == 'ى':
+		return 'ی', true
+	case r == 'ك':
+		return 'ک', true
+	case r == 'ة' || r == 'ە' || r == 'ۀ':
+		return 'ه', true
+	case r >= '۰' && r <= '۹':
+		return '0' + (r - '۰'), true
+	case r >= '٠' && r <= '٩':
+		return '0' + (r - '٠'), true
+	case isDiacritic(r) || r == '\u0640':
+		return 0, false
+	case r == '\u200b' || r == '\u200d' || r == '\u200e' || r == '\u200f' || r == '\ufeff' || r == '\u00ad':
+		return 0, false
+	case r == '\r':
+		return 0, false
+	case r != '\n' && r != zwnj && unicode.IsSpace(r):
+		return ' ', true
+	}
+	return r, true
+}
+
+func isDiacritic(r rune) bool {
+	return (r >= '\u064b' && r <= '\u065f') ||
+		r == '\u0670' ||
+		(r >= '\u06d6' && r <= '\u06dc') ||
+		(r >= '\u06df' && r <= '\u06e8') ||
+		(r >= '\u06ea' && r <= '\u06ed')
+}
+
+// cleanZWNJ keeps a ZWNJ only when it sits between two letters, which is the
+// only place it changes how a word renders.
+func cleanZWNJ(runes []rune) []rune {
+	out := runes[:0]
+	for i, r := range runes {
+		if r == zwnj {
+			if len(out) == 0 || !unicode.IsLetter(out[len(out)-1]) {
+				continue
+			}
+			if i+1 >= len(runes) || !unicode.IsLetter(runes[i+1]) {
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func collapseWhitespace(runes []rune) string {
+	lines := strings.Split(string(runes), "\n")
+
+	var b strings.Builder
+	blank := 0
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			blank++
+			continue
+		}
+		if b.Len() > 0 {
+			if blank > 0 {
+				b.WriteString("\n\n")
+			} else {
+				b.WriteByte('\n')
+			}
+		}
+		b.WriteString(line)
+		blank = 0
+	}
+	return b.String()
+}
+
+// Terms normalizes text, splits it into words, lowercases them, drops
+// stopwords and applies Stem. Letters, digits and ZWNJ form words; every other
+// character separates them.
+func Terms(text string) []string {
+	words := strings.FieldsFunc(Normalize(text), func(r rune) bool {
+		return r != zwnj && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if IsStopword(word) {
+			continue
+		}
+		if term := Stem(word); term != "" && !IsStopword(term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// SearchText returns the analyzed form of text stored in the search_text
+// columns and used to build keyword queries.
+func SearchText(text string) string {
+	return strings.Join(Terms(text), " ")
+}
+
+// zwnjSuffixes are only stripped when attached with a ZWNJ, where the
+// boundary is unambiguous.
+var zwnjSuffixes = []string{
+	"هایی", "های", "ها", "ترین", "تر", "ای", "ام", "ات", "اش", "مان", "تان", "شان", "ی",
+}
+
+// bareSuffixes are stripped without a ZWNJ only if a stem of at least
+// minBareStem runes remains, to avoid mangling words such as "تنها".
+var bareSuffixes = []string{"هایی", "های", "ها", "ترین"}
+
+var prefixes = []string{"نمی", "می"}
+
+const minBareStem = 3
+
+// Stem is a light, conservative Persian stemmer: it removes plural,
+// comparative and possessive suffixes and the continuous می/نمی verb prefix,
+// then joins what is left into a single term without ZWNJ.
+func Stem(word string) string {
+	for _, prefix := range prefixes {
+		if rest, ok := strings.CutPrefix(word, prefix+string(zwnj)); ok && runeCount(rest) >= 2 {
+			word = rest
+			break
+		}
+	}
+
+	for _, suffix := range zwnjSuffixes {
+		if rest, ok := strings.CutSuffix(word, string(zwnj)+suffix); ok && rest != "" {
+			word = rest
+			break
+		}
+	}
+
+	if !strings.ContainsRune(word, zwnj) {
+		for _, suffix := range bareSuffixes {
+			if rest, ok := strings.CutSuffix(word, suffix); ok && runeCount(rest) >= minBareStem {
+				word = rest
+				break
+			}
+		}
+	}
+
+	return strings.ReplaceAll(word, string(zwnj), "")
+}
+
+func runeCount(s string) int {
+	return len([]rune(s))
+}
@@ -0,0 +1,28 @@
+package persian
+
+var stopwords = toSet(
+	"و", "در", "به", "از", "که", "این", "آن", "با", "را", "برای", "تا", "یا",
+	"هم", "بر", "است", "هست", "بود", "شد", "شده", "شود", "می", "نمی", "یک",
+	"خود", "هر", "اما", "اگر", "نیز", "چه", "ما", "شما", "او", "آنها", "ایشان",
+	"من", "تو", "وی", "باید", "کرد", "کند", "کنند", "کرده", "دارد", "دارند",
+	"بوده", "همه", "هیچ", "چون", "پس", "نه", "ولی", "زیرا", "روی", "بین",
+	"پیش", "درباره", "مانند", "همین", "همان", "دیگر", "چنین", "آنکه",
+	"اینکه", "نیست", "باشد", "باشند", "بودن", "ای", "ها", "های", "ی",
+	"the", "a", "an", "and", "or", "of", "to", "in", "on", "for", "is", "are",
+	"was", "were", "be", "by", "with", "as", "at", "it", "this", "that",
+)
+
+// IsStopword reports whether a lowercased, normalized word carries no search
+// value.
+func IsStopword(word string) bool {
+	_, ok := stopwords[word]
+	return ok
+}
+
+func toSet(words ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		set[word] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,135 @@
+// Package prompts holds the prompt templates sent to language models. Built-in
+// templates are embedded in the binary; a directory can override them or add
+// variants per document type and language.
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"document-embeddings/internal/config"
+)
+
+// Template names.
+const (
+	// ImageAnalysis asks a vision model to describe and transcribe an image.
+	ImageAnalysis = "image_analysis"
+	// Repair asks a model to fix a reply that did not match the expected
+	// JSON schema.
+	Repair = "repair"
+	// Summary asks a chat model to summarize a text.
+	Summary = "summary"
+	// SummaryCombine asks a chat model to merge the summaries of the parts
+	// of a document into one.
+	SummaryCombine = "summary_combine"
+	// Answer asks a chat model to answer a question from numbered sources.
+	Answer = "answer"
+	// AnswerStream asks for the same as Answer as plain text, to be
+	// streamed.
+	AnswerStream = "answer_stream"
+)
+
+const templateExt = ".tmpl"
+
+//go:embed templates/*.tmpl
+var builtin embed.FS
+
+// Set is a set of prompt templates. A template file is named
+// <name>[.<document type>][.<language>].tmpl, e.g. image_analysis.tmpl,
+// image_analysis.pdf.tmpl or image_analysis.pdf.fa.tmpl; Render uses the most
+// specific one that exists.
+type Set struct {
+	templates map[string]*template.Template
+	language  string
+}
+
+// Default returns the built-in templates.
+func Default() *Set {
+	set := &Set{templates: make(map[string]*template.Template)}
+	if err := set.addFS(builtin, "templates"); err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// Load returns the built-in templates, overridden and extended by the *.tmpl
+// files in cfg.Dir if it is set. cfg.Language selects language variants.
+func Load(cfg config.PromptConfig) (*Set, error) {
+	set := Default()
+	set.language = cfg.Language
+
+	if cfg.Dir != "" {
+		if _, err := os.Stat(cfg.Dir); err != nil {
+			return nil, fmt.Errorf("failed to open prompt directory: %w", err)
+		}
+		if err := set.addFS(os.DirFS(cfg.Dir), "."); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+func (s *Set) addFS(fsys fs.FS, dir string) error {
+	paths, err := fs.Glob(fsys, filepath.ToSlash(filepath.Join(dir, "*"+templateExt)))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("failed to read prompt template %s: %w", path, err)
+		}
+
+		key := strings.TrimSuffix(filepath.Base(path), templateExt)
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse prompt template %s: %w", path, err)
+		}
+		s.templates[key] = tmpl
+	}
+	return nil
+}
+
+// Render executes the most specific template for name, documentType and the
+// set's language with data.
+func (s *Set) Render(name, documentType string, data interface{}) (string, error) {
+	var candidates []string
+	if documentType != "" && s.language != "" {
+		candidates = append(candidates, name+"."+documentType+"."+s.language)
+	}
+	if documentType != "" {
+		candidates = append(candidates, name+"."+documentType)
+	}
+	if s.language != "" {
+		candidates = append(candidates, name+"."+s.language)
+	}
+	candidates = append(candidates, name)
+
+	for _, key := range candidates {
+		tmpl, ok := s.templates[key]
+		if !ok {
+			continue
+		}
+
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return "", fmt.Errorf("failed to render prompt %s: %w", key, err)
+		}
+		return strings.TrimSpace(out.String()), nil
+	}
+
+	return "", fmt.Errorf("no prompt template named %s", name)
+}
+
+// Language returns the language variants are selected for.
+func (s *Set) Language() string {
+	return s.language
+}
@@ -0,0 +1,168 @@
+// pkg/provider/fake.go
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Fake is a deterministic, offline EmbeddingProvider, VisionProvider and
+// ChatProvider for tests and local runs without an API key.
+//
+// Embeddings are normalized bag-of-words vectors, so texts that share words
+// are similar and identical texts have a cosine similarity of 1. Images are
+// "read" as a fixed text derived from their SHA-256, or as ImageText when it
+// is set. Summaries are the first MaxWords words of the text. Questions are
+// answered with the sources that share a word with them.
+type Fake struct {
+	Dimensions int
+	// ImageText, when set, returns the text to report for an image.
+	ImageText func(imageData []byte) string
+}
+
+func NewFake(dimensions int) *Fake {
+	return &Fake{Dimensions: dimensions}
+}
+
+func (f *Fake) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = f.embed(text)
+	}
+	return embeddings, nil
+}
+
+func (f *Fake) embed(text string) []float32 {
+	vector := make([]float32, max(f.Dimensions, 1))
+
+	for _, word := range words(text) {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vector[h.Sum32()%uint32(len(vector))]++
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vector {
+			vector[i] *= scale
+		}
+	}
+	return vector
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func (f *Fake) AnalyzeImage(ctx context.Context, image ImageInput) (*ImageAnalysis, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(image.Data)
+	id := hex.EncodeToString(sum[:4])
+
+	text := "Text of image " + id
+	if f.ImageText != nil {
+		text = f.ImageText(image.Data)
+	}
+
+	return &ImageAnalysis{
+		Summary:        "Image " + id,
+		RawTextContent: text,
+		Metadata: ImageMetadata{
+			ImageType:       image.MimeType,
+			Colors:          []string{},
+			ObjectsDetected: []string{},
+		},
+	}, nil
+}
+
+func (f *Fake) ExtractTextFromImage(ctx context.Context, image ImageInput) (string, error) {
+	analysis, err := f.AnalyzeImage(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	return analysis.RawTextContent, nil
+}
+
+func (f *Fake) Summarize(ctx context.Context, req SummaryRequest) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	words := strings.Fields(req.Text)
+	if req.MaxWords > 0 && len(words) > req.MaxWords {
+		words = words[:req.MaxWords]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// NoAnswer is the text of the Fake's answers to questions its sources do not
+// answer.
+const NoAnswer = "The documents do not answer this question."
+
+func (f *Fake) Answer(ctx context.Context, req AnswerRequest) (*Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	question := make(map[string]bool)
+	for _, word := range words(req.Question) {
+		question[word] = true
+	}
+
+	answer := &Answer{Sources: []int{}}
+	var parts []string
+	for i, source := range req.Sources {
+		for _, word := range words(source.Text) {
+			if question[word] {
+				answer.Sources = append(answer.Sources, i+1)
+				parts = append(parts, fmt.Sprintf("%s [%d]", source.Text, i+1))
+				break
+			}
+		}
+	}
+
+	if len(parts) == 0 {
+		answer.Text = NoAnswer
+		return answer, nil
+	}
+	answer.Answered = true
+	answer.Text = strings.Join(parts, " ")
+	return answer, nil
+}
+
+// StreamAnswer passes the text of Answer on word by word.
+func (f *Fake) StreamAnswer(ctx context.Context, req AnswerRequest, onDelta func(delta string) error) (string, error) {
+	answer, err := f.Answer(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	for i, word := range strings.Fields(answer.Text) {
+		if i > 0 {
+			word = " " + word
+		}
+		if err := onDelta(word); err != nil {
+			return "", err
+		}
+	}
+	return answer.Text, nil
+}
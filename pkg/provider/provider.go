@@ -0,0 +1,144 @@
+// pkg/provider/provider.go
+package provider
+
+import (
+	"context"
+	"strings"
+
+	"document-embeddings/pkg/jsonschema"
+)
+
+// Providers selectable with EMBEDDING_PROVIDER, VISION_PROVIDER and
+// CHAT_PROVIDER.
+const (
+	NameOpenAI = "openai"
+	NameFake   = "fake"
+)
+
+// EmbeddingProvider turns texts into embedding vectors.
+type EmbeddingProvider interface {
+	// GenerateEmbeddings returns one vector per text, in order.
+	GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// VisionProvider reads images.
+type VisionProvider interface {
+	// AnalyzeImage describes an image and transcribes the text in it.
+	AnalyzeImage(ctx context.Context, image ImageInput) (*ImageAnalysis, error)
+	// ExtractTextFromImage returns only the text in an image.
+	ExtractTextFromImage(ctx context.Context, image ImageInput) (string, error)
+}
+
+// ChatProvider writes text with a language model.
+type ChatProvider interface {
+	// Summarize condenses a text. With Combine set, the text is the
+	// summaries of consecutive parts of one document, to be merged into one.
+	Summarize(ctx context.Context, req SummaryRequest) (string, error)
+	// Answer answers a question from the given sources only.
+	Answer(ctx context.Context, req AnswerRequest) (*Answer, error)
+	// StreamAnswer answers like Answer, but as plain text citing sources as
+	// [n], passed to onDelta piece by piece as it is written. It returns the
+	// whole text. An error from onDelta ends the answer and is returned.
+	StreamAnswer(ctx context.Context, req AnswerRequest, onDelta func(delta string) error) (string, error)
+}
+
+// SummaryRequest is a text to summarize.
+type SummaryRequest struct {
+	Text string
+	// DocumentType is the file type of the document the text comes from,
+	// which providers may use to pick a prompt.
+	DocumentType string
+	// MaxWords is the length the summary should not exceed.
+	MaxWords int
+	// Language is the language code to write in; empty keeps the language
+	// of the text.
+	Language string
+	Combine  bool
+}
+
+// AnswerRequest is a question with the sources to answer it from. Sources
+// are numbered from 1 in the order given.
+type AnswerRequest struct {
+	Question string
+	Sources  []Source
+}
+
+// Source is an excerpt of a document.
+type Source struct {
+	Title string
+	Text  string
+}
+
+// Answer is the result of Answer, in the shape chat models are asked to
+// reply with (see AnswerSchema).
+type Answer struct {
+	// Answered is false when the sources do not support an answer; Text
+	// then says so.
+	Answered bool   `json:"answered"`
+	Text     string `json:"answer"`
+	// Sources are the numbers of the sources the answer relies on.
+	Sources []int `json:"sources"`
+}
+
+// AnswerSchema is the JSON schema of Answer.
+var AnswerSchema = jsonschema.Object(map[string]*jsonschema.Schema{
+	"answered": jsonschema.Boolean("Whether the sources contain the answer"),
+	"answer":   jsonschema.String("The answer, citing sources as [n], or why the question cannot be answered from the sources"),
+	"sources":  jsonschema.Array("Numbers of the sources the answer relies on", jsonschema.Integer("")),
+})
+
+// ImageInput is an encoded image to read.
+type ImageInput struct {
+	Data     []byte
+	MimeType string
+	// DocumentType is the file type of the document the image comes from
+	// ("png", "pdf", "docx", ...), which providers may use to pick a prompt.
+	DocumentType string
+}
+
+// ImageAnalysis is the result of AnalyzeImage, in the shape vision models
+// are asked to reply with (see ImageAnalysisSchema).
+type ImageAnalysis struct {
+	Summary string `json:"summary"`
+	// RawTextContent is the text in the image, or empty if it has none.
+	RawTextContent string        `json:"raw_text_content"`
+	Metadata       ImageMetadata `json:"metadata"`
+	// ResponseError is set when the model's reply did not match the schema;
+	// Summary then holds the reply as it was.
+	ResponseError string `json:"-"`
+}
+
+// ImageMetadata describes what an image shows. It is stored as is in the
+// document metadata of image uploads.
+type ImageMetadata struct {
+	ImageType       string   `json:"image_type"`
+	Colors          []string `json:"colors"`
+	ObjectsDetected []string `json:"objects_detected"`
+	Mood            string   `json:"mood"`
+	Quality         string   `json:"quality"`
+}
+
+// ImageMetadataSchema is the JSON schema of ImageMetadata.
+var ImageMetadataSchema = jsonschema.Object(map[string]*jsonschema.Schema{
+	"image_type":       jsonschema.String("Type or category of the image"),
+	"colors":           jsonschema.Array("Dominant colors", jsonschema.String("")),
+	"objects_detected": jsonschema.Array("Objects in the image", jsonschema.String("")),
+	"mood":             jsonschema.String("Mood or atmosphere"),
+	"quality":          jsonschema.String("Quality and technical aspects"),
+})
+
+// ImageAnalysisSchema is the JSON schema of ImageAnalysis.
+var ImageAnalysisSchema = jsonschema.Object(map[string]*jsonschema.Schema{
+	"summary":          jsonschema.String("A short summary of the image"),
+	"raw_text_content": jsonschema.String("All text in the image exactly as it appears, or an empty string"),
+	"metadata":         ImageMetadataSchema,
+})
+
+// Text returns the transcribed text of the image, falling back to the
+// summary for images without text.
+func (a *ImageAnalysis) Text() string {
+	if text := strings.TrimSpace(a.RawTextContent); text != "" {
+		return text
+	}
+	return a.Summary
+}
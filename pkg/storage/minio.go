@@ -0,0 +1,120 @@
+// pkg/storage/minio.go
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	minioClient "document-embeddings/pkg/minio"
+)
+
+// MinIOStore keeps objects in a MinIO (or any S3-compatible) bucket.
+type MinIOStore struct {
+	client *minioClient.Client
+}
+
+func NewMinIOStore(client *minioClient.Client) *MinIOStore {
+	return &MinIOStore{client: client}
+}
+
+func (s *MinIOStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	_, err := s.client.PutObject(ctx, key, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return err
+	}
+
+	// MinIO stops reading after size bytes; more data is as wrong as less
+	if size >= 0 {
+		if n, _ := r.Read(make([]byte, 1)); n > 0 {
+			s.client.RemoveObject(ctx, key)
+			return checkSize(size+int64(n), size)
+		}
+	}
+	return nil
+}
+
+func (s *MinIOStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	object, err := s.client.GetObject(ctx, key)
+	if err != nil {
+		return nil, mapMinIOError(err)
+	}
+
+	// GetObject is lazy; stat it so a missing object is reported here
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, mapMinIOError(err)
+	}
+	return object, nil
+}
+
+func (s *MinIOStore) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, key)
+}
+
+func (s *MinIOStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	info, err := s.client.StatObject(ctx, s.client.BucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, mapMinIOError(err)
+	}
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s *MinIOStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	// Cancelling stops the listing goroutine if fn returns early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for object := range s.client.ListObjects(ctx, prefix) {
+		if object.Err != nil {
+			return object.Err
+		}
+		err := fn(ObjectInfo{
+			Key:          object.Key,
+			Size:         object.Size,
+			ContentType:  object.ContentType,
+			LastModified: object.LastModified,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MinIOStore) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	url, err := s.client.PresignedGetObject(ctx, s.client.BucketName, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return url.String(), nil
+}
+
+func mapMinIOError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+// pkg/storage/storage.go
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"document-embeddings/internal/config"
+	minioClient "document-embeddings/pkg/minio"
+)
+
+// Backends selectable with STORAGE_BACKEND.
+const (
+	BackendMinIO  = "minio"
+	BackendLocal  = "local"
+	BackendMemory = "memory"
+)
+
+var (
+	// ErrNotFound is returned when an object does not exist.
+	ErrNotFound = errors.New("object not found")
+	// ErrPresignNotSupported is returned by backends that cannot hand out
+	// URLs for direct download.
+	ErrPresignNotSupported = errors.New("presigned URLs are not supported by this storage backend")
+)
+
+// ObjectInfo describes a stored object. ContentType is empty when the
+// backend does not keep it.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// ObjectStore stores uploads and their artifacts under slash-separated keys.
+// A key is a clean relative path: no empty, "." or ".." segments, no leading
+// or trailing slash, and no final segment starting with ".put-", which the
+// local backend uses for files being written. Other keys are rejected by
+// every backend, so a key that works in tests works in production.
+type ObjectStore interface {
+	// Put stores size bytes read from r under key, replacing any existing
+	// object. A size of -1 means the size is not known up front.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens an object for reading; the caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List calls fn for every object whose key starts with prefix, stopping
+	// at the first error fn returns.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+	// PresignGet returns a URL that downloads the object without further
+	// credentials until expiry has passed.
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// New returns the object store selected by cfg.Storage.Backend.
+func New(cfg *config.Config) (ObjectStore, error) {
+	switch cfg.Storage.Backend {
+	case BackendMinIO:
+		client, err := minioClient.New(cfg.MinIO)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize MinIO client: %w", err)
+		}
+		return NewMinIOStore(client), nil
+	case BackendLocal:
+		return NewLocalStore(cfg.Storage.LocalDir)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (want minio, local or memory)", cfg.Storage.Backend)
+	}
+}
+
+// checkKey rejects keys that are not clean relative paths or that would
+// collide with the local backend's temporary files.
+func checkKey(key string) error {
+	clean := path.Clean("/" + key)
+	if key == "" || clean != "/"+key || strings.HasPrefix(path.Base(clean), tempPrefix) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	return nil
+}
+
+// checkSize verifies that a Put read as many bytes as it was told to expect.
+func checkSize(n, size int64) error {
+	if size >= 0 && n != size {
+		return fmt.Errorf("read %d bytes, expected %d", n, size)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"document-embeddings/internal/config"
+	minioClient "document-embeddings/pkg/minio"
+	"document-embeddings/pkg/storage"
+)
+
+// backends returns the stores every test runs against. MinIO is only
+// included when STORAGE_TEST_MINIO_ENDPOINT names a server to use; its
+// objects go to STORAGE_TEST_MINIO_BUCKET (default "storage-test").
+func backends(t *testing.T) map[string]func(t *testing.T) storage.ObjectStore {
+	t.Helper()
+
+	stores := map[string]func(t *testing.T) storage.ObjectStore{
+		storage.BackendMemory: func(t *testing.T) storage.ObjectStore {
+			return storage.NewMemoryStore()
+		},
+		storage.BackendLocal: func(t *testing.T) storage.ObjectStore {
+			store, err := storage.NewLocalStore(filepath.Join(t.TempDir(), "objects"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	}
+
+	if endpoint := os.Getenv("STORAGE_TEST_MINIO_ENDPOINT"); endpoint != "" {
+		stores[storage.BackendMinIO] = func(t *testing.T) storage.ObjectStore {
+			bucket := os.Getenv("STORAGE_TEST_MINIO_BUCKET")
+			if bucket == "" {
+				bucket = "storage-test"
+			}
+			client, err := minioClient.New(config.MinIOConfig{
+				Endpoint:        endpoint,
+				AccessKeyID:     os.Getenv("STORAGE_TEST_MINIO_ACCESS_KEY"),
+				SecretAccessKey: os.Getenv("STORAGE_TEST_MINIO_SECRET_KEY"),
+				BucketName:      bucket,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return storage.NewMinIOStore(client)
+		}
+	}
+	return stores
+}
+
+// testPrefix keeps the objects of one test apart from those of earlier runs
+// in a shared bucket, and deletes them when the test ends.
+func testPrefix(t *testing.T, store storage.ObjectStore) string {
+	prefix := fmt.Sprintf("test-%d/", time.Now().UnixNano())
+	t.Cleanup(func() {
+		ctx := context.Background()
+		var keys []string
+		store.List(ctx, prefix, func(info storage.ObjectInfo) error {
+			keys = append(keys, info.Key)
+			return nil
+		})
+		for _, key := range keys {
+			store.Delete(ctx, key)
+		}
+	})
+	return prefix
+}
+
+func TestInvalidKeysAreRejected(t *testing.T) {
+	keys := []string{"", "../x", "a/../../x", "a/../x", "./x", "/x", "x/", "a//b", ".put-x", "a/.put-123"}
+
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			ctx := context.Background()
+
+			for _, key := range keys {
+				if err := store.Put(ctx, key, strings.NewReader("data"), 4, "text/plain"); err == nil {
+					t.Errorf("Put(%q) succeeded, want an error", key)
+				}
+				if _, err := store.Get(ctx, key); err == nil || errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("Get(%q) = %v, want an invalid key error", key, err)
+				}
+				if _, err := store.Stat(ctx, key); err == nil || errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("Stat(%q) = %v, want an invalid key error", key, err)
+				}
+				if err := store.Delete(ctx, key); err == nil {
+					t.Errorf("Delete(%q) succeeded, want an error", key)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalKeysStayUnderTheRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "objects")
+	store, err := storage.NewLocalStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"../x", "a/../../x"} {
+		store.Put(ctx, key, strings.NewReader("data"), 4, "text/plain")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("a key escaped the storage root: %v", err)
+	}
+
+	// Deleting the last object of a directory removes the directory, but
+	// never the root
+	if err := store.Put(ctx, "a/b/c", strings.NewReader("data"), 4, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("empty directories were left behind: %v", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Fatalf("storage root was removed: %v", err)
+	}
+}
+
+func TestPutChecksTheSize(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			prefix := testPrefix(t, store)
+			ctx := context.Background()
+
+			for _, size := range []int64{3, 10} {
+				key := fmt.Sprintf("%sshort-%d", prefix, size)
+				if err := store.Put(ctx, key, strings.NewReader("12345"), size, "text/plain"); err == nil {
+					t.Errorf("Put of 5 bytes with size %d succeeded, want an error", size)
+				}
+				if _, err := store.Stat(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("Stat after a failed Put = %v, want ErrNotFound", err)
+				}
+			}
+
+			// Nothing is left over, not even temporary files
+			var listed []string
+			store.List(ctx, prefix, func(info storage.ObjectInfo) error {
+				listed = append(listed, info.Key)
+				return nil
+			})
+			if len(listed) != 0 {
+				t.Fatalf("failed Puts left %v", listed)
+			}
+
+			// A size of -1 is not checked, and Put replaces the object
+			key := prefix + "unknown"
+			for _, data := range []string{"first", "second"} {
+				if err := store.Put(ctx, key, strings.NewReader(data), -1, "text/plain"); err != nil {
+					t.Fatalf("Put with unknown size: %v", err)
+				}
+			}
+			object, err := store.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer object.Close()
+			if data, _ := io.ReadAll(object); string(data) != "second" {
+				t.Fatalf("Get = %q, want the replacing object", data)
+			}
+		})
+	}
+}
+
+func TestListVisitsKeysWithThePrefix(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			prefix := testPrefix(t, store)
+			ctx := context.Background()
+
+			for _, key := range []string{"a/2", "a/1", "ab/1", "b/1", "a/c/1"} {
+				if err := store.Put(ctx, prefix+key, strings.NewReader(key), int64(len(key)), "text/plain"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			list := func(p string) []string {
+				var keys []string
+				err := store.List(ctx, prefix+p, func(info storage.ObjectInfo) error {
+					if info.Size != int64(len(strings.TrimPrefix(info.Key, prefix))) {
+						t.Errorf("%s has size %d", info.Key, info.Size)
+					}
+					keys = append(keys, strings.TrimPrefix(info.Key, prefix))
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("List(%q): %v", p, err)
+				}
+				return keys
+			}
+
+			tests := []struct {
+				prefix string
+				want   []string
+			}{
+				{"", []string{"a/1", "a/2", "a/c/1", "ab/1", "b/1"}},
+				{"a", []string{"a/1", "a/2", "a/c/1", "ab/1"}},
+				{"a/", []string{"a/1", "a/2", "a/c/1"}},
+				{"a/c", []string{"a/c/1"}},
+				{"c", nil},
+			}
+			for _, tt := range tests {
+				if got := list(tt.prefix); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+				}
+			}
+
+			// The first error from fn ends the listing
+			stop := errors.New("stop")
+			var visited int
+			err := store.List(ctx, prefix, func(storage.ObjectInfo) error {
+				visited++
+				return stop
+			})
+			if !errors.Is(err, stop) || visited != 1 {
+				t.Fatalf("List = %v after %d objects, want to stop after the first", err, visited)
+			}
+		})
+	}
+}
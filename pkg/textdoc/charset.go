@@ -0,0 +1,116 @@
+package textdoc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// sniffLen is how much of the input is inspected to choose a charset.
+const sniffLen = 64 << 10
+
+var metaCharsetPattern = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.-]+)`)
+
+// metaCharset returns the charset declared by an HTML <meta> tag in the
+// first few kilobytes of the document.
+func metaCharset(data []byte) string {
+	head := data[:min(len(data), 4096)]
+	if m := metaCharsetPattern.FindSubmatch(head); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// NewReader returns a reader that decodes r to UTF-8, and the charset it
+// decodes from. The charset is chosen from the first 64KB: a byte order mark
+// wins, then the declared charset label (if any), then a guess. Valid UTF-8
+// is kept as is; otherwise Windows-1256 is chosen when the non-ASCII bytes
+// decode to Arabic-script letters in it, and Windows-1252 when they do not.
+func NewReader(r io.Reader, declared string) (io.Reader, string, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	complete := err == io.EOF
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		br.Discard(3)
+		return br, "utf-8", nil
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return decoding(br, xunicode.UTF16(xunicode.LittleEndian, xunicode.ExpectBOM), "utf-16le")
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return decoding(br, xunicode.UTF16(xunicode.BigEndian, xunicode.ExpectBOM), "utf-16be")
+	}
+
+	isUTF8 := validUTF8(head, complete)
+
+	if declared != "" {
+		if enc, err := htmlindex.Get(declared); err == nil {
+			name, _ := htmlindex.Name(enc)
+			// A declared UTF-8 that does not validate is a mislabeled legacy file
+			if name != "utf-8" || isUTF8 {
+				return decoding(br, enc, name)
+			}
+		}
+	}
+
+	if isUTF8 {
+		return br, "utf-8", nil
+	}
+
+	if looksLikeWindows1256(head) {
+		return decoding(br, charmap.Windows1256, "windows-1256")
+	}
+	return decoding(br, charmap.Windows1252, "windows-1252")
+}
+
+func decoding(r io.Reader, enc encoding.Encoding, name string) (io.Reader, string, error) {
+	if name == "utf-8" {
+		return r, name, nil
+	}
+	return transform.NewReader(r, enc.NewDecoder()), name, nil
+}
+
+// validUTF8 reports whether head is valid UTF-8. Unless head is the whole
+// input, a multi-byte sequence cut off at its end is not held against it.
+func validUTF8(head []byte, complete bool) bool {
+	if !complete {
+		for i := len(head) - 1; i >= 0 && i >= len(head)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(head[i]) {
+				if !utf8.FullRune(head[i:]) {
+					head = head[:i]
+				}
+				break
+			}
+		}
+	}
+	return utf8.Valid(head)
+}
+
+// looksLikeWindows1256 reports whether most non-ASCII bytes are Arabic-script
+// letters in Windows-1256. Western European text mostly maps to Latin
+// letters or symbols there, so it fails the test.
+func looksLikeWindows1256(data []byte) bool {
+	var high, arabic int
+	for _, b := range data {
+		if b < 0x80 {
+			continue
+		}
+		high++
+		if unicode.Is(unicode.Arabic, charmap.Windows1256.DecodeByte(b)) {
+			arabic++
+		}
+	}
+	return high > 0 && float64(arabic)/float64(high) >= 0.6
+}
@@ -0,0 +1,109 @@
+package textdoc
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// renderCSV renders every row as "header: value" pairs so that a chunk cut
+// from the middle of a large file still says what each value means. Rows are
+// read one at a time.
+func renderCSV(r io.Reader) (string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4096)
+
+	cr := csv.NewReader(br)
+	cr.Comma = detectDelimiter(string(head))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+
+	var headers []string
+	var lines []string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if headers == nil {
+			headers = headerNames(record)
+			lines = append(lines, "Columns: "+strings.Join(headers, ", "))
+			continue
+		}
+		if line := renderRow(headers, record); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// detectDelimiter picks the most frequent of the common delimiters in the
+// first line.
+func detectDelimiter(text string) rune {
+	firstLine, _, _ := strings.Cut(text, "\n")
+
+	delimiter, best := ',', 0
+	for _, candidate := range []rune{',', ';', '\t', '|'} {
+		if n := strings.Count(firstLine, string(candidate)); n > best {
+			delimiter, best = candidate, n
+		}
+	}
+	return delimiter
+}
+
+// renderRecords treats the first record as the header row and renders the
+// rest like renderCSV does.
+func renderRecords(records [][]string) string {
+	if len(records) == 0 {
+		return ""
+	}
+
+	headers := headerNames(records[0])
+	lines := []string{"Columns: " + strings.Join(headers, ", ")}
+	for _, record := range records[1:] {
+		if line := renderRow(headers, record); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func headerNames(record []string) []string {
+	headers := make([]string, len(record))
+	for i, header := range record {
+		headers[i] = collapse(header)
+		if headers[i] == "" {
+			headers[i] = fmt.Sprintf("Column %d", i+1)
+		}
+	}
+	return headers
+}
+
+// renderRow renders one record as "header: value" pairs; empty values are
+// left out.
+func renderRow(headers, record []string) string {
+	var pairs []string
+	for i, value := range record {
+		value = collapse(value)
+		if value == "" {
+			continue
+		}
+
+		header := fmt.Sprintf("Column %d", i+1)
+		if i < len(headers) {
+			header = headers[i]
+		}
+		pairs = append(pairs, header+": "+value)
+	}
+	return strings.Join(pairs, "; ")
+}
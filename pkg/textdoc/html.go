@@ -0,0 +1,233 @@
+package textdoc
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements that hold page chrome, scripts or widgets rather than content.
+var boilerplate = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Svg: true, atom.Iframe: true, atom.Nav: true, atom.Header: true,
+	atom.Footer: true, atom.Aside: true, atom.Form: true, atom.Button: true,
+	atom.Select: true,
+}
+
+var boilerplateRoles = map[string]bool{
+	"navigation": true, "banner": true, "contentinfo": true, "complementary": true,
+	"search": true, "menu": true, "menubar": true,
+}
+
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Blockquote: true, atom.Ul: true, atom.Ol: true,
+	atom.Dl: true, atom.Dt: true, atom.Dd: true, atom.Figure: true,
+	atom.Figcaption: true, atom.Hr: true, atom.Address: true, atom.Details: true,
+	atom.Summary: true, atom.Caption: true,
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// renderHTML returns the page title and the readable content of the page.
+// Navigation, headers, footers, scripts and similar chrome are dropped; when
+// the page marks its content with <main> or <article> only that is kept.
+// Headings become Markdown headings, list items "- " lines and table rows
+// "header: value" lines like CSV.
+func renderHTML(src io.Reader) (string, string, error) {
+	root, err := html.Parse(src)
+	if err != nil {
+		return "", "", err
+	}
+
+	var title string
+	if n := findElement(root, atom.Title); n != nil {
+		title = collapse(textContent(n))
+	}
+
+	content := findElement(root, atom.Main)
+	if content == nil {
+		content = findElement(root, atom.Article)
+	}
+	if content == nil {
+		content = findElement(root, atom.Body)
+	}
+	if content == nil {
+		content = root
+	}
+
+	r := &htmlRenderer{}
+	r.walk(content)
+	r.flush("")
+
+	body := strings.Join(r.blocks, "\n\n")
+	if title != "" && !strings.HasPrefix(body, "# ") {
+		body = "# " + title + "\n\n" + body
+	}
+	return title, body, nil
+}
+
+type htmlRenderer struct {
+	blocks []string
+	inline strings.Builder
+}
+
+func (r *htmlRenderer) flush(prefix string) {
+	text := collapse(r.inline.String())
+	r.inline.Reset()
+	if text != "" {
+		r.blocks = append(r.blocks, prefix+text)
+	}
+}
+
+func (r *htmlRenderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.inline.WriteString(n.Data)
+		return
+	case html.ElementNode, html.DocumentNode:
+	default:
+		return
+	}
+
+	if n.Type == html.ElementNode && isBoilerplate(n) {
+		return
+	}
+
+	switch {
+	case n.DataAtom == atom.Br:
+		r.flush("")
+		return
+
+	case n.DataAtom == atom.Pre:
+		r.flush("")
+		if text := strings.TrimSpace(textContent(n)); text != "" {
+			r.blocks = append(r.blocks, text)
+		}
+		return
+
+	case n.DataAtom == atom.Table:
+		r.flush("")
+		if text := renderRecords(tableRows(n)); text != "" {
+			r.blocks = append(r.blocks, text)
+		}
+		return
+
+	case headingLevels[n.DataAtom] > 0:
+		r.flush("")
+		r.walkChildren(n)
+		r.flush(strings.Repeat("#", headingLevels[n.DataAtom]) + " ")
+		return
+
+	case n.DataAtom == atom.Li:
+		r.flush("")
+		r.walkChildren(n)
+		r.flush("- ")
+		return
+
+	case blockElements[n.DataAtom]:
+		r.flush("")
+		r.walkChildren(n)
+		r.flush("")
+		return
+	}
+
+	r.walkChildren(n)
+}
+
+func (r *htmlRenderer) walkChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.walk(c)
+	}
+}
+
+func isBoilerplate(n *html.Node) bool {
+	if boilerplate[n.DataAtom] {
+		return true
+	}
+	for _, a := range n.Attr {
+		switch a.Key {
+		case "hidden":
+			return true
+		case "aria-hidden":
+			if a.Val == "true" {
+				return true
+			}
+		case "role":
+			if boilerplateRoles[strings.ToLower(a.Val)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tableRows collects the cell text of a table's own rows, skipping rows of
+// nested tables.
+func tableRows(table *html.Node) [][]string {
+	var rows [][]string
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Tr:
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+						row = append(row, collapse(textContent(cell)))
+					}
+				}
+				if strings.Join(row, "") != "" {
+					rows = append(rows, row)
+				}
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				visit(c)
+			}
+		}
+	}
+	visit(table)
+	return rows
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			return
+		}
+		if n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style) {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+			if c.Type == html.ElementNode && c.DataAtom == atom.Br {
+				sb.WriteByte('\n')
+			}
+		}
+	}
+	visit(n)
+	return sb.String()
+}
+
+// collapse joins runs of whitespace into single spaces.
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,92 @@
+package textdoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// renderJSON flattens JSON into one "path: value" line per scalar, keeping
+// the key order of the input, e.g. "items[0].name: Tehran". Several
+// concatenated values (JSON Lines) are rendered one after another.
+func renderJSON(r io.Reader) (string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var values []string
+	for {
+		var lines []string
+		if err := walkJSON(dec, "", &lines); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		values = append(values, strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(values, "\n\n"), nil
+}
+
+func walkJSON(dec *json.Decoder, path string, lines *[]string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyTok.(string)
+
+				childPath := key
+				if path != "" {
+					childPath = path + "." + key
+				}
+				if err := walkJSON(dec, childPath, lines); err != nil {
+					return unexpectedEOF(err)
+				}
+			}
+		case '[':
+			for i := 0; dec.More(); i++ {
+				if err := walkJSON(dec, fmt.Sprintf("%s[%d]", path, i), lines); err != nil {
+					return unexpectedEOF(err)
+				}
+			}
+		}
+		// Consume the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return unexpectedEOF(err)
+		}
+		return nil
+
+	case nil:
+		return nil
+	}
+
+	value := strings.TrimSpace(fmt.Sprint(tok))
+	if value == "" {
+		return nil
+	}
+	if path == "" {
+		*lines = append(*lines, value)
+	} else {
+		*lines = append(*lines, path+": "+value)
+	}
+	return nil
+}
+
+// unexpectedEOF keeps a truncated document from looking like a clean end of
+// input.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+// Package textdoc extracts text from plain-text based formats: plain text,
+// Markdown, HTML, CSV and JSON. Input in any common charset is decoded to
+// UTF-8 first, including legacy Windows-1256 Persian files.
+package textdoc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Document is the extracted content of a text file.
+type Document struct {
+	Text string
+	// Title is the HTML <title>, if any.
+	Title string
+	// Charset is the encoding the input was decoded from.
+	Charset string
+}
+
+// Extract decodes and renders a file of the given format ("txt", "md",
+// "html", "csv" or "json"), reading it as a stream.
+func Extract(r io.Reader, format string) (*Document, error) {
+	format = strings.ToLower(format)
+	br := bufio.NewReaderSize(r, sniffLen)
+
+	var declared string
+	if format == "html" {
+		head, _ := br.Peek(sniffLen)
+		declared = metaCharset(head)
+	}
+
+	text, charset, err := NewReader(br, declared)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", format, err)
+	}
+
+	doc := &Document{Charset: charset}
+	switch format {
+	case "txt", "md":
+		var data []byte
+		data, err = io.ReadAll(text)
+		doc.Text = strings.ReplaceAll(string(data), "\r\n", "\n")
+	case "html":
+		doc.Title, doc.Text, err = renderHTML(text)
+	case "csv":
+		doc.Text, err = renderCSV(text)
+	case "json":
+		doc.Text, err = renderJSON(text)
+	default:
+		return nil, fmt.Errorf("unsupported text format: %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", format, err)
+	}
+
+	doc.Text = strings.TrimSpace(doc.Text)
+	return doc, nil
+}
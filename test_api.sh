@@ -16,6 +16,11 @@ echo "Note: This requires a file upload. Example curl command:"
 echo "curl -X POST http://localhost:8080/api/v1/process \\"
 echo "  -F 'documentId=test-doc-123' \\"
 echo "  -F 'file=@/path/to/your/document.pdf'"
+echo "Re-uploading an identical file returns 409 unless onDuplicate is set:"
+echo "curl -X POST http://localhost:8080/api/v1/process \\"
+echo "  -F 'documentId=test-doc-456' \\"
+echo "  -F 'onDuplicate=link' \\"
+echo "  -F 'file=@/path/to/your/document.pdf'"
 echo -e "\n"
 
 # List documents
@@ -32,9 +37,19 @@ curl -X POST http://localhost:8080/api/v1/documents/batch \
   }'
 echo -e "\n\n"
 
-# Search documents (commented out since search is disabled)
-echo "5. Search Documents (disabled):"
-echo "Note: Search functionality is currently commented out"
-echo -e "\n"
+# Search documents
+echo "5. Search Documents:"
+curl -X POST http://localhost:8080/api/v1/search \
+  -H "Content-Type: application/json" \
+  -d '{
+    "query": "invoice",
+    "limit": 5
+  }'
+echo -e "\n\n"
+
+# List trashed documents
+echo "6. List Trash:"
+curl -X GET "http://localhost:8080/api/v1/documents/trash"
+echo -e "\n\n"
 
 echo "API Test Complete!"